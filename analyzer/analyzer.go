@@ -0,0 +1,573 @@
+// Package analyzer defines a go/analysis pass that reports misuse of the
+// errorx API:
+//
+//   - using the result of NewError, Wrap or WrapPrefix as a value (method
+//     call or type assertion) when the wrapped error may be nil, in which
+//     case the result is nil too;
+//   - discarding the error returned by SetMetadata;
+//   - converting a *errorx.TraceError variable that may hold nil to error or
+//     errorx.Error, which yields a non-nil interface holding a nil pointer;
+//   - re-wrapping the same error on every iteration of a loop, which nests
+//     one layer and one captured stack per iteration;
+//   - the deprecated NewErrorf, Is and MaxStackDepth.
+//
+// Suggested fixes migrate NewErrorf to Errorf, Is to errors.Is and reads of
+// MaxStackDepth to DefaultMaxStackDepth, and check the SetMetadata error in
+// functions that return a single error. Run it standalone with
+//
+//	go run github.com/neumachen/errorx/analyzer/cmd/errorxlint ./...
+//
+// The package lives in its own module so that the errorx module itself does
+// not depend on golang.org/x/tools.
+package analyzer
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+const errorxPath = "github.com/neumachen/errorx"
+
+var errorType = types.Universe.Lookup("error").Type()
+
+// Analyzer reports misuse of the errorx API.
+var Analyzer = &analysis.Analyzer{
+	Name:     "errorx",
+	Doc:      "report misuse of github.com/neumachen/errorx and migrate deprecated APIs",
+	URL:      "https://pkg.go.dev/github.com/neumachen/errorx/analyzer",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	if !importsErrorx(pass.Pkg) {
+		return nil, nil
+	}
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	filter := []ast.Node{
+		(*ast.SelectorExpr)(nil),
+		(*ast.CallExpr)(nil),
+		(*ast.ExprStmt)(nil),
+		(*ast.AssignStmt)(nil),
+		(*ast.FuncDecl)(nil),
+		(*ast.FuncLit)(nil),
+	}
+	insp.WithStack(filter, func(n ast.Node, push bool, stack []ast.Node) bool {
+		if !push {
+			return true
+		}
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			checkDeprecated(pass, n, stack)
+		case *ast.CallExpr:
+			checkNilWrap(pass, n, stack)
+		case *ast.ExprStmt:
+			checkSetMetadata(pass, n, stack)
+		case *ast.AssignStmt:
+			checkLoopRewrap(pass, n, stack)
+		case *ast.FuncDecl:
+			if n.Body != nil {
+				checkTypedNil(pass, n.Type, n.Body)
+			}
+		case *ast.FuncLit:
+			checkTypedNil(pass, n.Type, n.Body)
+		}
+		return true
+	})
+	return nil, nil
+}
+
+// importsErrorx reports whether pkg imports errorx; other packages are
+// skipped.
+func importsErrorx(pkg *types.Package) bool {
+	for _, imp := range pkg.Imports() {
+		if imp.Path() == errorxPath {
+			return true
+		}
+	}
+	return false
+}
+
+// errorxObject returns the errorx package-level object named by id, or nil.
+func errorxObject(pass *analysis.Pass, id *ast.Ident) types.Object {
+	obj := pass.TypesInfo.Uses[id]
+	if obj == nil || obj.Pkg() == nil || obj.Pkg().Path() != errorxPath || obj.Parent() != obj.Pkg().Scope() {
+		return nil
+	}
+	return obj
+}
+
+// errorxCall returns the name of the errorx package function called by
+// call, or "".
+func errorxCall(pass *analysis.Pass, call *ast.CallExpr) string {
+	var id *ast.Ident
+	switch fun := call.Fun.(type) {
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	case *ast.Ident:
+		id = fun
+	default:
+		return ""
+	}
+	if obj, ok := errorxObject(pass, id).(*types.Func); ok {
+		return obj.Name()
+	}
+	return ""
+}
+
+func checkDeprecated(pass *analysis.Pass, sel *ast.SelectorExpr, stack []ast.Node) {
+	obj := errorxObject(pass, sel.Sel)
+	if obj == nil {
+		return
+	}
+	switch obj.Name() {
+	case "NewErrorf":
+		pass.Report(analysis.Diagnostic{
+			Pos:     sel.Pos(),
+			End:     sel.End(),
+			Message: "errorx.NewErrorf is deprecated: use errorx.Errorf",
+			SuggestedFixes: []analysis.SuggestedFix{{
+				Message:   "Replace with errorx.Errorf",
+				TextEdits: []analysis.TextEdit{{Pos: sel.Sel.Pos(), End: sel.Sel.End(), NewText: []byte("Errorf")}},
+			}},
+		})
+	case "Is":
+		d := analysis.Diagnostic{
+			Pos:     sel.Pos(),
+			End:     sel.End(),
+			Message: "errorx.Is is deprecated: use errors.Is",
+		}
+		if edits, ok := useErrorsIs(pass, sel, stack); ok {
+			d.SuggestedFixes = []analysis.SuggestedFix{{Message: "Replace with errors.Is", TextEdits: edits}}
+		}
+		pass.Report(d)
+	case "MaxStackDepth":
+		d := analysis.Diagnostic{
+			Pos:     sel.Pos(),
+			End:     sel.End(),
+			Message: "errorx.MaxStackDepth is deprecated: it is an unsynchronized global; rely on errorx.DefaultMaxStackDepth",
+		}
+		if !isAssigned(sel, stack) {
+			d.SuggestedFixes = []analysis.SuggestedFix{{
+				Message:   "Replace with errorx.DefaultMaxStackDepth",
+				TextEdits: []analysis.TextEdit{{Pos: sel.Sel.Pos(), End: sel.Sel.End(), NewText: []byte("DefaultMaxStackDepth")}},
+			}}
+		}
+		pass.Report(d)
+	}
+}
+
+// useErrorsIs returns the edits replacing sel with errors.Is, importing
+// "errors" when needed. It fails when the name errors refers to something
+// else at sel.
+func useErrorsIs(pass *analysis.Pass, sel *ast.SelectorExpr, stack []ast.Node) ([]analysis.TextEdit, bool) {
+	file, ok := stack[0].(*ast.File)
+	if !ok {
+		return nil, false
+	}
+	name := ""
+	for _, imp := range file.Imports {
+		if path, _ := strconv.Unquote(imp.Path.Value); path == "errors" {
+			switch {
+			case imp.Name == nil:
+				name = "errors"
+			case imp.Name.Name != "_" && imp.Name.Name != ".":
+				name = imp.Name.Name
+			}
+		}
+	}
+	edits := []analysis.TextEdit{}
+	if name == "" {
+		scope := pass.TypesInfo.Scopes[file].Innermost(sel.Pos())
+		if scope == nil {
+			return nil, false
+		}
+		if _, obj := scope.LookupParent("errors", sel.Pos()); obj != nil {
+			return nil, false
+		}
+		edit, ok := addImport(file, "errors")
+		if !ok {
+			return nil, false
+		}
+		name = "errors"
+		edits = append(edits, edit)
+	}
+	edits = append(edits, analysis.TextEdit{Pos: sel.Pos(), End: sel.End(), NewText: []byte(name + ".Is")})
+	return edits, true
+}
+
+// addImport returns an edit adding path as the first spec of the file's
+// first parenthesized import declaration.
+func addImport(file *ast.File, path string) (analysis.TextEdit, bool) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			break
+		}
+		if gen.Lparen.IsValid() && len(gen.Specs) > 0 {
+			pos := gen.Specs[0].Pos()
+			return analysis.TextEdit{Pos: pos, End: pos, NewText: []byte(strconv.Quote(path) + "\n\t")}, true
+		}
+	}
+	return analysis.TextEdit{}, false
+}
+
+// isAssigned reports whether expr is written to: the left-hand side of an
+// assignment, an increment or decrement, or the operand of &.
+func isAssigned(expr ast.Expr, stack []ast.Node) bool {
+	parent := stack[len(stack)-2]
+	switch p := parent.(type) {
+	case *ast.AssignStmt:
+		for _, lhs := range p.Lhs {
+			if lhs == expr {
+				return true
+			}
+		}
+	case *ast.IncDecStmt:
+		return true
+	case *ast.UnaryExpr:
+		return p.Op == token.AND
+	}
+	return false
+}
+
+// checkNilWrap reports NewError, Wrap and WrapPrefix results that are used
+// as a value, which panics when the wrapped error was nil.
+func checkNilWrap(pass *analysis.Pass, call *ast.CallExpr, stack []ast.Node) {
+	name := errorxCall(pass, call)
+	if (name != "NewError" && name != "Wrap" && name != "WrapPrefix") || len(call.Args) == 0 {
+		return
+	}
+	parent := stack[len(stack)-2]
+	switch p := parent.(type) {
+	case *ast.SelectorExpr:
+	case *ast.TypeAssertExpr:
+		if len(stack) >= 3 && isCommaOk(stack[len(stack)-3], p) {
+			return
+		}
+	default:
+		return
+	}
+	arg := call.Args[0]
+	if nonNil(pass, arg) || guardedNonNil(pass, arg, stack) {
+		return
+	}
+	pass.Reportf(call.Pos(), "errorx.%s returns nil when %s is nil; check it before using the result", name, render(pass.Fset, arg))
+}
+
+func isCommaOk(parent ast.Node, assert *ast.TypeAssertExpr) bool {
+	switch p := parent.(type) {
+	case *ast.AssignStmt:
+		return len(p.Lhs) == 2 && len(p.Rhs) == 1 && p.Rhs[0] == assert
+	case *ast.ValueSpec:
+		return len(p.Names) == 2 && len(p.Values) == 1 && p.Values[0] == assert
+	}
+	return false
+}
+
+// nonNil reports whether expr is obviously a non-nil error: an address, a
+// composite literal, or a call to errors.New, fmt.Errorf or an errorx
+// constructor other than the nil-propagating ones.
+func nonNil(pass *analysis.Pass, expr ast.Expr) bool {
+	switch e := ast.Unparen(expr).(type) {
+	case *ast.UnaryExpr:
+		return e.Op == token.AND
+	case *ast.CompositeLit:
+		return true
+	case *ast.CallExpr:
+		if name := errorxCall(pass, e); name != "" {
+			return name == "Errorf" || name == "NewErrorf" || name == "FromPanic" || name == "FromRecord"
+		}
+		fn, ok := typeutil.Callee(pass.TypesInfo, e).(*types.Func)
+		if !ok || fn.Pkg() == nil {
+			return false
+		}
+		return fn.Pkg().Path() == "errors" && fn.Name() == "New" ||
+			fn.Pkg().Path() == "fmt" && fn.Name() == "Errorf"
+	}
+	return false
+}
+
+// guardedNonNil reports whether expr is a variable checked by an enclosing
+// "if v != nil" statement.
+func guardedNonNil(pass *analysis.Pass, expr ast.Expr, stack []ast.Node) bool {
+	id, ok := ast.Unparen(expr).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	obj := pass.TypesInfo.Uses[id]
+	if obj == nil {
+		return false
+	}
+	for i := len(stack) - 1; i > 0; i-- {
+		ifs, ok := stack[i].(*ast.IfStmt)
+		if !ok || stack[i+1] != ifs.Body {
+			continue
+		}
+		if bin, ok := ifs.Cond.(*ast.BinaryExpr); ok && bin.Op == token.NEQ {
+			if x, ok := bin.X.(*ast.Ident); ok && pass.TypesInfo.Uses[x] == obj && isNilIdent(pass, bin.Y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isNilIdent(pass *analysis.Pass, expr ast.Expr) bool {
+	id, ok := ast.Unparen(expr).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	_, isNil := pass.TypesInfo.Uses[id].(*types.Nil)
+	return isNil
+}
+
+// checkSetMetadata reports SetMetadata calls whose error is discarded.
+func checkSetMetadata(pass *analysis.Pass, stmt *ast.ExprStmt, stack []ast.Node) {
+	call, ok := stmt.X.(*ast.CallExpr)
+	if !ok {
+		return
+	}
+	fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+	if !ok || fn.Name() != "SetMetadata" || fn.Pkg() == nil || fn.Pkg().Path() != errorxPath {
+		return
+	}
+	d := analysis.Diagnostic{
+		Pos:     call.Pos(),
+		End:     call.End(),
+		Message: "error returned by SetMetadata is discarded; invalid JSON metadata is silently dropped",
+	}
+	if returnsOnlyError(pass, enclosingFunc(stack)) {
+		d.SuggestedFixes = []analysis.SuggestedFix{{
+			Message: "Return the SetMetadata error",
+			TextEdits: []analysis.TextEdit{
+				{Pos: stmt.Pos(), End: stmt.Pos(), NewText: []byte("if err := ")},
+				{Pos: stmt.End(), End: stmt.End(), NewText: []byte("; err != nil {\n\treturn err\n}")},
+			},
+		}}
+	}
+	pass.Report(d)
+}
+
+// enclosingFunc returns the type of the innermost function in stack.
+func enclosingFunc(stack []ast.Node) *ast.FuncType {
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch f := stack[i].(type) {
+		case *ast.FuncDecl:
+			return f.Type
+		case *ast.FuncLit:
+			return f.Type
+		}
+	}
+	return nil
+}
+
+func returnsOnlyError(pass *analysis.Pass, ft *ast.FuncType) bool {
+	if ft == nil || ft.Results == nil || len(ft.Results.List) != 1 || len(ft.Results.List[0].Names) > 1 {
+		return false
+	}
+	return types.Identical(pass.TypesInfo.TypeOf(ft.Results.List[0].Type), errorType)
+}
+
+// checkLoopRewrap reports "v = errorx.Wrap(v, ...)" inside a loop when v is
+// assigned nowhere else in the loop, so each iteration wraps the previous
+// iteration's result.
+func checkLoopRewrap(pass *analysis.Pass, assign *ast.AssignStmt, stack []ast.Node) {
+	if assign.Tok != token.ASSIGN || len(assign.Lhs) != len(assign.Rhs) {
+		return
+	}
+	var loop *ast.BlockStmt
+	for i := len(stack) - 2; i >= 0 && loop == nil; i-- {
+		switch s := stack[i].(type) {
+		case *ast.ForStmt:
+			loop = s.Body
+		case *ast.RangeStmt:
+			loop = s.Body
+		case *ast.FuncLit, *ast.FuncDecl:
+			return
+		}
+	}
+	if loop == nil {
+		return
+	}
+	for i, rhs := range assign.Rhs {
+		call, ok := rhs.(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+		name := errorxCall(pass, call)
+		if name != "NewError" && name != "Wrap" && name != "WrapPrefix" && name != "Errorf" && name != "NewErrorf" {
+			continue
+		}
+		id, ok := assign.Lhs[i].(*ast.Ident)
+		if !ok {
+			continue
+		}
+		obj := pass.TypesInfo.ObjectOf(id)
+		if obj == nil || !usesObject(pass, call.Args, obj) || assignedElsewhere(pass, loop, assign, obj) {
+			continue
+		}
+		pass.Reportf(assign.Pos(), "%s is wrapped again on every loop iteration by errorx.%s, adding a layer and a stack each time; wrap once after the loop", id.Name, name)
+	}
+}
+
+func usesObject(pass *analysis.Pass, exprs []ast.Expr, obj types.Object) bool {
+	for _, e := range exprs {
+		if id, ok := ast.Unparen(e).(*ast.Ident); ok && pass.TypesInfo.Uses[id] == obj {
+			return true
+		}
+	}
+	return false
+}
+
+func assignedElsewhere(pass *analysis.Pass, body *ast.BlockStmt, except *ast.AssignStmt, obj types.Object) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || assign == except || found {
+			return !found
+		}
+		for _, lhs := range assign.Lhs {
+			if id, ok := lhs.(*ast.Ident); ok && pass.TypesInfo.ObjectOf(id) == obj {
+				found = true
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// checkTypedNil reports *errorx.TraceError variables that may be nil (they
+// are declared without a value or assigned nil in the function) being
+// converted to error or another error interface, and such conversions of a
+// nil *errorx.TraceError.
+func checkTypedNil(pass *analysis.Pass, ft *ast.FuncType, body *ast.BlockStmt) {
+	maybeNil := map[types.Object]bool{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ValueSpec:
+			if len(n.Values) == 0 {
+				for _, name := range n.Names {
+					if obj := pass.TypesInfo.Defs[name]; obj != nil && isTraceErrorPtr(obj.Type()) {
+						maybeNil[obj] = true
+					}
+				}
+			}
+		case *ast.AssignStmt:
+			if len(n.Lhs) == len(n.Rhs) {
+				for i, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok && isNilIdent(pass, n.Rhs[i]) {
+						if obj := pass.TypesInfo.ObjectOf(id); obj != nil && isTraceErrorPtr(obj.Type()) {
+							maybeNil[obj] = true
+						}
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	suspicious := func(e ast.Expr) bool {
+		e = ast.Unparen(e)
+		if id, ok := e.(*ast.Ident); ok {
+			return maybeNil[pass.TypesInfo.Uses[id]]
+		}
+		if conv, ok := e.(*ast.CallExpr); ok && len(conv.Args) == 1 && isNilIdent(pass, conv.Args[0]) {
+			tv, ok := pass.TypesInfo.Types[conv.Fun]
+			return ok && tv.IsType() && isTraceErrorPtr(tv.Type)
+		}
+		return false
+	}
+	check := func(e ast.Expr, target types.Type) {
+		// Only error-like interfaces: passing a nil pointer as any, e.g. to
+		// json.Marshal, is deliberate.
+		if target == nil || !types.IsInterface(target) || !types.AssignableTo(target, errorType) || !suspicious(e) {
+			return
+		}
+		pass.Reportf(e.Pos(), "%s may be a nil *errorx.TraceError; converting it to %s yields a non-nil interface", render(pass.Fset, e), types.TypeString(target, packageName(pass.Pkg)))
+	}
+
+	var results []types.Type
+	if ft.Results != nil {
+		for _, field := range ft.Results.List {
+			t := pass.TypesInfo.TypeOf(field.Type)
+			for range max(1, len(field.Names)) {
+				results = append(results, t)
+			}
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			if len(n.Results) == len(results) {
+				for i, r := range n.Results {
+					check(r, results[i])
+				}
+			}
+		case *ast.AssignStmt:
+			if len(n.Lhs) == len(n.Rhs) && n.Tok == token.ASSIGN {
+				for i, rhs := range n.Rhs {
+					check(rhs, pass.TypesInfo.TypeOf(n.Lhs[i]))
+				}
+			}
+		case *ast.ValueSpec:
+			if n.Type != nil {
+				for _, v := range n.Values {
+					check(v, pass.TypesInfo.TypeOf(n.Type))
+				}
+			}
+		case *ast.CallExpr:
+			sig, ok := pass.TypesInfo.TypeOf(n.Fun).(*types.Signature)
+			if !ok {
+				return true
+			}
+			for i, arg := range n.Args {
+				if i < sig.Params().Len() && !(sig.Variadic() && i >= sig.Params().Len()-1) {
+					check(arg, sig.Params().At(i).Type())
+				}
+			}
+		}
+		return true
+	})
+}
+
+func isTraceErrorPtr(t types.Type) bool {
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	return ok && named.Obj().Name() == "TraceError" && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == errorxPath
+}
+
+// packageName qualifies types by package name, omitting pkg itself.
+func packageName(pkg *types.Package) types.Qualifier {
+	return func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	}
+}
+
+func render(fset *token.FileSet, n ast.Node) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, n); err != nil {
+		return fmt.Sprintf("%T", n)
+	}
+	return buf.String()
+}
@@ -0,0 +1,12 @@
+// Command errorxlint runs the errorx analyzer as a standalone vet tool.
+//
+//	errorxlint ./...
+//	errorxlint -fix ./...
+package main
+
+import (
+	"github.com/neumachen/errorx/analyzer"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() { singlechecker.Main(analyzer.Analyzer) }
@@ -0,0 +1,86 @@
+package a
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/neumachen/errorx"
+)
+
+func nilWrap(err error) string {
+	te := errorx.Wrap(err, 0).(*errorx.TraceError) // want `errorx.Wrap returns nil when err is nil`
+	_ = te
+	return errorx.WrapPrefix(err, "load", 0).Prefix() // want `errorx.WrapPrefix returns nil when err is nil`
+}
+
+func nilWrapChecked(err error) string {
+	if err != nil {
+		return errorx.Wrap(err, 0).Prefix()
+	}
+	if te, ok := errorx.NewError(err).(*errorx.TraceError); ok {
+		return te.Prefix()
+	}
+	return errorx.Wrap(fmt.Errorf("boom"), 0).Prefix()
+}
+
+func setMetadata(te *errorx.TraceError, md *json.RawMessage) error {
+	te.SetMetadata(md) // want `error returned by SetMetadata is discarded`
+	return nil
+}
+
+func setMetadataInterface(e errorx.Error, md *json.RawMessage) {
+	e.SetMetadata(md) // want `error returned by SetMetadata is discarded`
+	_ = e.SetMetadata(md)
+}
+
+func typedNil(fail bool) error {
+	var te *errorx.TraceError
+	if fail {
+		te = errorx.FromPanic("boom", nil)
+	}
+	return te // want `te may be a nil \*errorx.TraceError; converting it to error yields a non-nil interface`
+}
+
+func typedNilLiteral() errorx.Error {
+	return (*errorx.TraceError)(nil) // want `may be a nil \*errorx.TraceError; converting it to errorx.Error`
+}
+
+func typedNilArgument(report func(error)) {
+	var te *errorx.TraceError
+	report(te) // want `te may be a nil`
+	fmt.Println(te)
+}
+
+func notNil() error {
+	te := errorx.FromPanic("boom", nil)
+	return te
+}
+
+func loopRewrap(items []string) error {
+	err := fmt.Errorf("start")
+	for _, item := range items {
+		err = errorx.WrapPrefix(err, item, 0) // want `err is wrapped again on every loop iteration by errorx.WrapPrefix`
+	}
+	return err
+}
+
+func loopRetry(do func() error) error {
+	var err error
+	for i := 0; i < 3; i++ {
+		err = do()
+		if err == nil {
+			return nil
+		}
+		err = errorx.Wrap(err, 0)
+	}
+	return err
+}
+
+func deprecated(err error) error {
+	if errorx.Is(err, io.EOF) { // want `errorx.Is is deprecated: use errors.Is`
+		return errorx.NewErrorf("eof after %d frames", errorx.MaxStackDepth) // want `errorx.NewErrorf is deprecated` `errorx.MaxStackDepth is deprecated`
+	}
+	errorx.MaxStackDepth = 10 // want `errorx.MaxStackDepth is deprecated`
+	return nil
+}
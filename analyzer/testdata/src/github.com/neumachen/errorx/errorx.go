@@ -0,0 +1,29 @@
+// Package errorx is a minimal stand-in for github.com/neumachen/errorx
+// exposing the API surface the analyzer inspects.
+package errorx
+
+import "encoding/json"
+
+const DefaultMaxStackDepth = 50
+
+var MaxStackDepth = DefaultMaxStackDepth
+
+type Error interface {
+	error
+	Prefix() string
+	SetMetadata(*json.RawMessage) error
+}
+
+type TraceError struct{ cause error }
+
+func (e *TraceError) Error() string                         { return e.cause.Error() }
+func (e *TraceError) Prefix() string                        { return "" }
+func (e *TraceError) SetMetadata(md *json.RawMessage) error { return nil }
+
+func NewError(cause error) Error                          { return nil }
+func Wrap(err error, skip int) Error                      { return nil }
+func WrapPrefix(err error, prefix string, skip int) Error { return nil }
+func Errorf(format string, a ...any) Error                { return nil }
+func NewErrorf(format string, a ...any) Error             { return nil }
+func Is(err, target error) bool                           { return false }
+func FromPanic(value any, stack []byte) *TraceError       { return nil }
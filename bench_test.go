@@ -17,6 +17,15 @@ func BenchmarkNewError(b *testing.B) {
 	}
 }
 
+// BenchmarkNewErrorDefault guards the default construction path: one
+// TraceError and its stack, with no opt-in capture enabled.
+func BenchmarkNewErrorDefault(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		errSink = errorx.NewError(errBench)
+	}
+}
+
 func BenchmarkWrap(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_ = errorx.Wrap(errBench, 0)
@@ -137,3 +146,101 @@ func BenchmarkLogValueWithSlogHandler(b *testing.B) {
 type devNullWriter struct{}
 
 func (devNullWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func BenchmarkNewErrorWithHook(b *testing.B) {
+	defer errorx.RegisterHook(func(*errorx.TraceError, errorx.StackFrame) {})()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = errorx.NewError(errBench)
+	}
+}
+
+func BenchmarkMarshalBinary(b *testing.B) {
+	te := binaryFixture(b)
+	data, _ := te.MarshalBinary()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = te.MarshalBinary()
+	}
+	b.ReportMetric(float64(len(data)), "bytes/record")
+}
+
+func BenchmarkMarshalJSONRecord(b *testing.B) {
+	te := binaryFixture(b)
+	data, _ := te.MarshalJSON()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = te.MarshalJSON()
+	}
+	b.ReportMetric(float64(len(data)), "bytes/record")
+}
+
+func BenchmarkBinaryEncoderStream(b *testing.B) {
+	te := binaryFixture(b)
+	var cw countingWriter
+	enc := errorx.NewBinaryEncoder(&cw)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = enc.Encode(te)
+	}
+	b.ReportMetric(float64(cw.n)/float64(b.N), "bytes/record")
+}
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	data, _ := binaryFixture(b).MarshalBinary()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var te errorx.TraceError
+		_ = te.UnmarshalBinary(data)
+	}
+}
+
+func BenchmarkUnmarshalJSONRecord(b *testing.B) {
+	data, _ := binaryFixture(b).MarshalJSON()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var r errorx.Record
+		_ = json.Unmarshal(data, &r)
+	}
+}
+
+type countingWriter struct{ n int }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+func BenchmarkNewErrorWithGoroutine(b *testing.B) {
+	errorx.SetIncludeGoroutine(true)
+	defer errorx.SetIncludeGoroutine(false)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = errorx.NewError(errBench)
+	}
+}
+
+// errSink keeps benchmark results escaping, as errors returned to callers
+// do, so that the compiler cannot optimize them away.
+var errSink error
+
+func BenchmarkWithDetail(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		errSink = errorx.WithDetail(errBench, i)
+	}
+}
+
+func BenchmarkDetail(b *testing.B) {
+	err := errorx.WrapPrefix(errorx.WithDetail(errBench, 42), "ctx", 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = errorx.Detail[int](err)
+	}
+}
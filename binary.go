@@ -0,0 +1,556 @@
+package errorx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// The binary encoding is a compact alternative to MarshalJSON for
+// high-volume transport. A stream starts with a 4-byte header, the magic
+// "ERX" followed by a version byte, and holds any number of records. Each
+// record is a uvarint byte length followed by fields, each a uvarint tag,
+// a uvarint payload length and the payload. Decoders skip fields with tags
+// they do not know, so fields can be added without a new version. Repeated
+// strings (types, prefixes, file, package and function names) are written
+// once per stream, in a string field leading the record that first uses
+// them, and referenced by index afterwards, so a stream of errors from the
+// same code base shrinks further the longer it runs.
+const (
+	binaryMagic   = "ERX"
+	binaryVersion = 2
+
+	// maxBinaryRecord bounds the size of a single decoded record.
+	maxBinaryRecord = 64 << 20
+)
+
+const (
+	tagCauseText = iota + 1
+	tagRootCause
+	tagType
+	tagPrefix
+	tagMetadata
+	tagStack
+	tagFrames
+	tagDebugStack
+	tagViolations
+	tagBuildID
+	tagProcess
+	tagInlined
+	tagGoroutine
+	tagTime
+	tagDetails
+	tagStrings
+)
+
+var errBinaryCorrupt = errors.New("errorx: corrupt binary record")
+
+// remoteError is the cause of a TraceError reconstructed from an encoded
+// form. It reproduces the original cause text and Type() without the
+// original Go value.
+type remoteError struct {
+	msg  string
+	typ  string
+	root error // deepest cause when its text differs from msg
+}
+
+func (r *remoteError) Error() string { return r.msg }
+func (r *remoteError) Unwrap() error { return r.root }
+
+// decodedCause rebuilds the cause of a decoded TraceError from the cause
+// text msg, the Type string, the deepest cause text root (empty when equal
+// to msg) and any validation violations. Violations are restored as a
+// *ValidationError wherever its text appears, so errors.As keeps working.
+func decodedCause(msg, typ, root string, violations []Violation) error {
+	if typ == "panic" {
+		return uncaughtPanic{message: msg}
+	}
+	if len(violations) > 0 {
+		ve := &ValidationError{violations: violations}
+		text := ve.Error()
+		if typ == validationErrorType && msg == text {
+			return ve
+		}
+		if root == text || (root == "" && msg == text) {
+			return &remoteError{msg: msg, typ: typ, root: ve}
+		}
+	}
+	remote := &remoteError{msg: msg, typ: typ}
+	if root != "" && root != msg {
+		remote.root = errors.New(root)
+	}
+	return remote
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using the package's
+// compact binary encoding. The output is a complete single-record stream.
+func (e *TraceError) MarshalBinary() ([]byte, error) {
+	if e == nil {
+		return nil, errors.New("errorx: MarshalBinary on nil *TraceError")
+	}
+	enc := &BinaryEncoder{strings: make(map[string]uint64)}
+	out := append([]byte(binaryMagic), binaryVersion)
+	out, err := enc.appendRecord(out, e)
+	if err != nil {
+		return nil, fmt.Errorf("errorx: MarshalBinary: %w", err)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It accepts the
+// output of MarshalBinary, i.e. a stream holding exactly one record. The
+// decoded error reports the original message, Type, prefix, metadata,
+// program counters and frames; its cause is an opaque error carrying the
+// original text.
+func (e *TraceError) UnmarshalBinary(data []byte) error {
+	if e == nil {
+		return errors.New("errorx: UnmarshalBinary on nil *TraceError")
+	}
+	d := NewBinaryDecoder(bytes.NewReader(data))
+	te, err := d.Decode()
+	if err != nil {
+		return err
+	}
+	if _, err := d.r.ReadByte(); err != io.EOF {
+		return fmt.Errorf("errorx: UnmarshalBinary: trailing data after record")
+	}
+	e.setFrom(te)
+	return nil
+}
+
+// setFrom copies the immutable construction state of src into e, which
+// must not yet be shared.
+func (e *TraceError) setFrom(src *TraceError) {
+	e.cause = src.cause
+	e.prefix = src.prefix
+	e.time = src.time
+	e.stack = src.stack
+	e.extra = src.extra
+	e.metadata = src.metadata
+}
+
+// BinaryEncoder writes TraceErrors to a stream in the binary encoding. The
+// string table grows with the stream; use a new encoder per connection or
+// file. A BinaryEncoder is not safe for concurrent use.
+type BinaryEncoder struct {
+	w       io.Writer
+	strings map[string]uint64
+	// pending holds the strings first used by the record being encoded.
+	pending []string
+	header  bool
+	buf     []byte
+	// err is the first write error, after which the stream may hold a
+	// partial record.
+	err error
+}
+
+// NewBinaryEncoder returns an encoder writing to w.
+func NewBinaryEncoder(w io.Writer) *BinaryEncoder {
+	return &BinaryEncoder{w: w, strings: make(map[string]uint64)}
+}
+
+// Encode writes one record for e. The stream header is written before the
+// first record. A record that fails to encode is not written and leaves the
+// stream usable; after a write error, Encode returns that error for every
+// later record.
+func (enc *BinaryEncoder) Encode(e *TraceError) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	if e == nil {
+		return errors.New("errorx: BinaryEncoder.Encode of nil *TraceError")
+	}
+	out := enc.buf[:0]
+	if !enc.header {
+		out = append(out, binaryMagic...)
+		out = append(out, binaryVersion)
+	}
+	out, err := enc.appendRecord(out, e)
+	if err != nil {
+		// The decoder never sees the strings this record introduced.
+		for _, s := range enc.pending {
+			delete(enc.strings, s)
+		}
+		enc.pending = enc.pending[:0]
+		return fmt.Errorf("errorx: BinaryEncoder: %w", err)
+	}
+	enc.pending = enc.pending[:0]
+	enc.buf = out
+	if _, err := enc.w.Write(out); err != nil {
+		enc.err = fmt.Errorf("errorx: BinaryEncoder: %w", err)
+		return enc.err
+	}
+	enc.header = true
+	return nil
+}
+
+// appendRecord appends the length-prefixed record for e to out. The strings
+// it adds to the table stay in enc.pending for the caller to commit or
+// discard.
+func (enc *BinaryEncoder) appendRecord(out []byte, e *TraceError) ([]byte, error) {
+	var body, f []byte
+	if e.cause != nil {
+		text := e.cause.Error()
+		body = appendField(body, tagCauseText, []byte(text))
+		if root := e.Cause(); root != nil && root.Error() != text {
+			body = appendField(body, tagRootCause, []byte(root.Error()))
+		}
+	}
+	if typ := e.Type(); typ != "" {
+		body = appendField(body, tagType, enc.appendString(f[:0], typ))
+	}
+	if e.prefix != "" {
+		body = appendField(body, tagPrefix, enc.appendString(f[:0], e.prefix))
+	}
+	if md := e.Metadata(); md != nil {
+		body = appendField(body, tagMetadata, *md)
+	}
+	if len(e.stack) > 0 {
+		f = binary.AppendUvarint(f[:0], uint64(len(e.stack)))
+		var prev uintptr
+		for _, pc := range e.stack {
+			f = binary.AppendVarint(f, int64(pc-prev))
+			prev = pc
+		}
+		body = appendField(body, tagStack, f)
+	}
+	if id := e.BuildID(); id != "" {
+		body = appendField(body, tagBuildID, enc.appendString(f[:0], id))
+	}
+	x := e.ext()
+	if x.process != nil {
+		raw, err := json.Marshal(x.process)
+		if err != nil {
+			return nil, fmt.Errorf("process: %w", err)
+		}
+		body = appendField(body, tagProcess, enc.appendString(f[:0], string(raw)))
+	}
+	if !e.time.IsZero() {
+		body = appendField(body, tagTime, binary.AppendVarint(f[:0], e.time.UnixNano()))
+	}
+	if x.goroutine != nil {
+		raw, err := json.Marshal(x.goroutine)
+		if err != nil {
+			return nil, fmt.Errorf("goroutine: %w", err)
+		}
+		body = appendField(body, tagGoroutine, enc.appendString(f[:0], string(raw)))
+	}
+	if frames := e.StackFrames(); len(frames) > 0 {
+		f = binary.AppendUvarint(f[:0], uint64(len(frames)))
+		for _, fr := range frames {
+			f = enc.appendString(f, fr.File)
+			f = enc.appendString(f, fr.Package)
+			f = enc.appendString(f, fr.Name)
+			f = binary.AppendUvarint(f, uint64(fr.LineNumber))
+			f = binary.AppendUvarint(f, uint64(fr.ProgramCounter))
+		}
+		body = appendField(body, tagFrames, f)
+		// Inlined frames are listed by index in a separate field so that
+		// the frame layout stays unchanged.
+		f = f[:0]
+		for i, fr := range frames {
+			if fr.Inlined {
+				f = binary.AppendUvarint(f, uint64(i))
+			}
+		}
+		if len(f) > 0 {
+			body = appendField(body, tagInlined, f)
+		}
+	}
+	if len(x.debugStack) > 0 {
+		body = appendField(body, tagDebugStack, x.debugStack)
+	}
+	var ve *ValidationError
+	if errors.As(e, &ve) {
+		raw, err := json.Marshal(ve.violations)
+		if err != nil {
+			return nil, fmt.Errorf("violations: %w", err)
+		}
+		body = appendField(body, tagViolations, raw)
+	}
+	if details := recordDetails(e); len(details) > 0 {
+		// Type names repeat across records and go through the string
+		// table; the values rarely do.
+		f = binary.AppendUvarint(f[:0], uint64(len(details)))
+		for _, key := range slices.Sorted(maps.Keys(details)) {
+			f = enc.appendString(f, key)
+			f = appendBytes(f, details[key])
+		}
+		body = appendField(body, tagDetails, f)
+	}
+	// The strings first used by this record lead it, so a decoder skipping
+	// a field it does not know still sees every string table entry.
+	var strs []byte
+	if len(enc.pending) > 0 {
+		f = binary.AppendUvarint(f[:0], uint64(len(enc.pending)))
+		for _, s := range enc.pending {
+			f = appendBytes(f, []byte(s))
+		}
+		strs = appendField(nil, tagStrings, f)
+	}
+	out = binary.AppendUvarint(out, uint64(len(strs)+len(body)))
+	out = append(out, strs...)
+	return append(out, body...), nil
+}
+
+// appendString appends the string-table index of s, adding s to the table
+// and to the strings pending for the current record when it is new.
+func (enc *BinaryEncoder) appendString(out []byte, s string) []byte {
+	idx, ok := enc.strings[s]
+	if !ok {
+		idx = uint64(len(enc.strings))
+		enc.strings[s] = idx
+		enc.pending = append(enc.pending, s)
+	}
+	return binary.AppendUvarint(out, idx)
+}
+
+// appendField appends a field: its tag, the payload length and the payload.
+func appendField(out []byte, tag uint64, payload []byte) []byte {
+	out = binary.AppendUvarint(out, tag)
+	return appendBytes(out, payload)
+}
+
+func appendBytes(out, b []byte) []byte {
+	out = binary.AppendUvarint(out, uint64(len(b)))
+	return append(out, b...)
+}
+
+// BinaryDecoder reads TraceErrors written by a BinaryEncoder. It is not
+// safe for concurrent use.
+type BinaryDecoder struct {
+	r       *bufio.Reader
+	strings []string
+	header  bool
+}
+
+// NewBinaryDecoder returns a decoder reading from r.
+func NewBinaryDecoder(r io.Reader) *BinaryDecoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &BinaryDecoder{r: br}
+}
+
+// Decode reads the next record. It returns io.EOF when the stream ends
+// cleanly between records.
+func (d *BinaryDecoder) Decode() (*TraceError, error) {
+	if !d.header {
+		var hdr [4]byte
+		if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errBinaryCorrupt
+			}
+			return nil, err
+		}
+		if string(hdr[:3]) != binaryMagic {
+			return nil, errors.New("errorx: not an errorx binary stream")
+		}
+		if hdr[3] != binaryVersion {
+			return nil, fmt.Errorf("errorx: unsupported binary version %d", hdr[3])
+		}
+		d.header = true
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errBinaryCorrupt
+	}
+	if n > maxBinaryRecord {
+		return nil, fmt.Errorf("errorx: binary record of %d bytes exceeds limit", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(d.r, body); err != nil {
+		return nil, errBinaryCorrupt
+	}
+	return d.decodeRecord(body)
+}
+
+func (d *BinaryDecoder) decodeRecord(body []byte) (*TraceError, error) {
+	rec := &binaryParser{buf: body}
+	x := &traceExtra{decoded: true}
+	te := &TraceError{extra: x}
+	var msg, typ, root string
+	hasCause := false
+	var violations []Violation
+	for len(rec.buf) > 0 && rec.err == nil {
+		tag := rec.uvarint()
+		payload := rec.bytes()
+		if rec.err != nil {
+			break
+		}
+		// Each field is parsed from its own payload. Unknown tags are
+		// skipped and bytes a field does not read are ignored, so newer
+		// encoders can add fields and extend existing ones.
+		p := &binaryParser{buf: payload, strings: &d.strings}
+		switch tag {
+		case tagStrings:
+			n := p.count()
+			for i := 0; i < n && p.err == nil; i++ {
+				if s := p.bytes(); p.err == nil {
+					d.strings = append(d.strings, string(s))
+				}
+			}
+		case tagCauseText:
+			msg = string(payload)
+			hasCause = true
+		case tagRootCause:
+			root = string(payload)
+		case tagType:
+			typ = p.string()
+		case tagPrefix:
+			te.prefix = p.string()
+		case tagMetadata:
+			md := json.RawMessage(slices.Clone(payload))
+			te.metadata = &md
+		case tagStack:
+			n := p.count()
+			te.stack = make([]uintptr, 0, n)
+			var prev uintptr
+			for i := 0; i < n && p.err == nil; i++ {
+				prev += uintptr(p.varint())
+				te.stack = append(te.stack, prev)
+			}
+		case tagFrames:
+			n := p.count()
+			x.parsedFrames = make([]StackFrame, 0, n)
+			for i := 0; i < n && p.err == nil; i++ {
+				x.parsedFrames = append(x.parsedFrames, StackFrame{
+					File:           p.string(),
+					Package:        p.string(),
+					Name:           p.string(),
+					LineNumber:     int(p.uvarint()),
+					ProgramCounter: uintptr(p.uvarint()),
+				})
+			}
+		case tagBuildID:
+			x.buildID = p.string()
+		case tagProcess:
+			x.process = new(ProcessInfo)
+			if err := json.Unmarshal([]byte(p.string()), x.process); err != nil && p.err == nil {
+				p.err = errBinaryCorrupt
+			}
+		case tagTime:
+			te.time = time.Unix(0, p.varint())
+		case tagGoroutine:
+			x.goroutine = new(GoroutineInfo)
+			if err := json.Unmarshal([]byte(p.string()), x.goroutine); err != nil && p.err == nil {
+				p.err = errBinaryCorrupt
+			}
+		case tagInlined:
+			for len(p.buf) > 0 && p.err == nil {
+				if idx := p.uvarint(); idx < uint64(len(x.parsedFrames)) {
+					x.parsedFrames[idx].Inlined = true
+				} else if p.err == nil {
+					p.err = errBinaryCorrupt
+				}
+			}
+		case tagDebugStack:
+			x.debugStack = slices.Clone(payload)
+		case tagDetails:
+			n := p.count()
+			x.details = make(map[string]json.RawMessage, n)
+			for i := 0; i < n && p.err == nil; i++ {
+				key := p.string()
+				raw := slices.Clone(p.bytes())
+				if !json.Valid(raw) && p.err == nil {
+					p.err = errBinaryCorrupt
+				}
+				x.details[key] = raw
+			}
+		case tagViolations:
+			if err := json.Unmarshal(payload, &violations); err != nil {
+				p.err = errBinaryCorrupt
+			}
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+	}
+	if rec.err != nil {
+		return nil, rec.err
+	}
+	if hasCause {
+		te.cause = decodedCause(msg, typ, root, violations)
+	}
+	return te, nil
+}
+
+// binaryParser consumes a record body or field payload, remembering the
+// first error.
+type binaryParser struct {
+	buf     []byte
+	strings *[]string
+	err     error
+}
+
+func (p *binaryParser) uvarint() uint64 {
+	if p.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(p.buf)
+	if n <= 0 {
+		p.err = errBinaryCorrupt
+		return 0
+	}
+	p.buf = p.buf[n:]
+	return v
+}
+
+func (p *binaryParser) varint() int64 {
+	if p.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(p.buf)
+	if n <= 0 {
+		p.err = errBinaryCorrupt
+		return 0
+	}
+	p.buf = p.buf[n:]
+	return v
+}
+
+// count reads an element count, rejecting values that could not fit in the
+// remaining input at one byte per element.
+func (p *binaryParser) count() int {
+	n := p.uvarint()
+	if n > uint64(len(p.buf)) {
+		p.err = errBinaryCorrupt
+		return 0
+	}
+	return int(n)
+}
+
+// bytes reads a length-prefixed byte string. The result aliases the input.
+func (p *binaryParser) bytes() []byte {
+	n := p.count()
+	if p.err != nil {
+		return nil
+	}
+	b := p.buf[:n:n]
+	p.buf = p.buf[n:]
+	return b
+}
+
+// string reads a string-table index.
+func (p *binaryParser) string() string {
+	ref := p.uvarint()
+	if p.err != nil {
+		return ""
+	}
+	if ref >= uint64(len(*p.strings)) {
+		p.err = errBinaryCorrupt
+		return ""
+	}
+	return (*p.strings)[ref]
+}
@@ -0,0 +1,195 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func binaryFixture(t testing.TB) *errorx.TraceError {
+	inner := errorx.WrapPrefix(errors.New("disk full"), "write", 0)
+	te := errorx.WrapPrefix(fmt.Errorf("flush: %w", inner), "save", 0).(*errorx.TraceError)
+	md := json.RawMessage(`{"attempt":3}`)
+	if err := te.SetMetadata(&md); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+	return te
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *errorx.TraceError
+	}{
+		{"wrapped chain", binaryFixture(t)},
+		{"plain", errorx.Errorf("boom").(*errorx.TraceError)},
+		{"panic with stack", errorx.FromPanic("kaboom", []byte("goroutine 1 [running]:\n"))},
+		{"inlined frames", errorx.FromRecord(errorx.Record{
+			Message:     "boom",
+			StackFrames: []errorx.StackFrame{{Name: "helper", Inlined: true}, {Name: "caller"}, {Name: "leaf", Inlined: true}},
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.err.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary: %v", err)
+			}
+			var got errorx.TraceError
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatalf("UnmarshalBinary: %v", err)
+			}
+			want := tt.err.Record()
+			if rec := got.Record(); !reflect.DeepEqual(rec, want) {
+				t.Errorf("decoded record\n got %+v\nwant %+v", rec, want)
+			}
+			if !bytes.Equal(got.RuntimeStack(), tt.err.RuntimeStack()) {
+				t.Errorf("RuntimeStack differs")
+			}
+		})
+	}
+}
+
+func TestBinaryStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := errorx.NewBinaryEncoder(&buf)
+	var sizes []int
+	errs := make([]*errorx.TraceError, 3)
+	for i := range errs {
+		errs[i] = binaryFixture(t)
+		before := buf.Len()
+		if err := enc.Encode(errs[i]); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		sizes = append(sizes, buf.Len()-before)
+	}
+	if sizes[1] >= sizes[0] {
+		t.Errorf("second record (%d bytes) not smaller than first (%d): string table not reused", sizes[1], sizes[0])
+	}
+
+	dec := errorx.NewBinaryDecoder(&buf)
+	for i := range errs {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("Decode %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got.Record(), errs[i].Record()) {
+			t.Errorf("record %d differs", i)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("Decode at end = %v, want io.EOF", err)
+	}
+}
+
+// shortWriter accepts n bytes, then writes only part of each buffer and
+// fails.
+type shortWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.n {
+		w.n -= len(p)
+		return w.buf.Write(p)
+	}
+	n, _ := w.buf.Write(p[:len(p)/2])
+	w.n = 0
+	return n, io.ErrShortWrite
+}
+
+func TestBinaryEncoderWriteError(t *testing.T) {
+	first := binaryFixture(t)
+	data, _ := first.MarshalBinary()
+	w := &shortWriter{n: len(data)}
+	enc := errorx.NewBinaryEncoder(w)
+	if err := enc.Encode(first); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if err := enc.Encode(errorx.Errorf("second").(*errorx.TraceError)); !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("Encode on a failing writer = %v", err)
+	}
+	w.n = 1 << 20
+	if err := enc.Encode(errorx.Errorf("third").(*errorx.TraceError)); !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("Encode after a write error = %v, want the same error", err)
+	}
+
+	// The stream holds the first record and a partial second one.
+	dec := errorx.NewBinaryDecoder(&w.buf)
+	if got, err := dec.Decode(); err != nil || !reflect.DeepEqual(got.Record(), first.Record()) {
+		t.Fatalf("Decode first record: %v", err)
+	}
+	if _, err := dec.Decode(); err == nil || err == io.EOF {
+		t.Errorf("Decode of the partial record = %v, want an error", err)
+	}
+}
+
+func TestBinaryRejectsBadInput(t *testing.T) {
+	data, _ := binaryFixture(t).MarshalBinary()
+	tests := map[string][]byte{
+		"empty":     nil,
+		"bad magic": append([]byte("XXX"), data[3:]...),
+		"version":   append(append([]byte("ERX"), 99), data[4:]...),
+		"truncated": data[:len(data)/2],
+		"trailing":  append(append([]byte(nil), data...), 0),
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			var te errorx.TraceError
+			if err := te.UnmarshalBinary(in); err == nil {
+				t.Errorf("UnmarshalBinary accepted %s input", name)
+			}
+		})
+	}
+}
+
+func TestBinarySkipsUnknownFields(t *testing.T) {
+	want := binaryFixture(t)
+	data, _ := want.MarshalBinary()
+
+	// Splice fields with tags no decoder knows before and after the
+	// record's own fields.
+	n, w := binary.Uvarint(data[4:])
+	body := data[4+w:]
+	if uint64(len(body)) != n {
+		t.Fatalf("record length %d, body %d bytes", n, len(body))
+	}
+	unknown := func(tag uint64, payload string) []byte {
+		f := binary.AppendUvarint(nil, tag)
+		f = binary.AppendUvarint(f, uint64(len(payload)))
+		return append(f, payload...)
+	}
+	spliced := append(unknown(999, "from the future"), body...)
+	spliced = append(spliced, unknown(1000, "")...)
+	stream := append([]byte(data[:4]), binary.AppendUvarint(nil, uint64(len(spliced)))...)
+	stream = append(stream, spliced...)
+
+	var got errorx.TraceError
+	if err := got.UnmarshalBinary(stream); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if rec := got.Record(); !reflect.DeepEqual(rec, want.Record()) {
+		t.Errorf("decoded record\n got %+v\nwant %+v", rec, want.Record())
+	}
+}
+
+func TestFromRecord(t *testing.T) {
+	want := binaryFixture(t).Record()
+	got := errorx.FromRecord(want)
+	if rec := got.Record(); !reflect.DeepEqual(rec, want) {
+		t.Errorf("FromRecord(r).Record()\n got %+v\nwant %+v", rec, want)
+	}
+
+	p := errorx.FromRecord(errorx.Record{Message: "kaboom", Type: "panic"})
+	if p.Type() != "panic" || p.Error() != "kaboom" {
+		t.Errorf("panic record = %q (%s)", p.Error(), p.Type())
+	}
+}
@@ -0,0 +1,270 @@
+// Command errorx extracts panics, crashes and logged errors from text and
+// prints them readably.
+//
+// Usage:
+//
+//	errorx [flags] [file ...]
+//	errorx symbolize -binary path [-force] [file ...]
+//
+// errorx reads the named files, or stdin when there are none, and finds
+// every Go panic or "fatal error:" crash in them, skipping unrelated log
+// lines. JSON log lines are searched for an errorx Record (an object with
+// "stack_frames") or a string "stack", "stack_trace" or "stacktrace" field.
+// Each error is parsed with errorx.ParsePanic or errorx.FromRecord and
+// printed in the selected format:
+//
+//	console  the message and aligned frames, colorized on terminals
+//	json     one errorx.Record per line
+//	summary  one row per distinct origin with a count, for log files with
+//	         many crashes
+//
+// The flags are:
+//
+//	-format string
+//	    console, json or summary (default "console")
+//	-color string
+//	    auto, always or never; auto colors terminals unless NO_COLOR is set
+//	-hide regexp
+//	    drop frames whose package-qualified function matches
+//	    (default "^(runtime|testing)\.")
+//	-trim prefix
+//	    remove prefix from file paths; repeatable
+//	-max-frames n
+//	    print at most n frames per error; 0 prints all
+//
+// The exit status is 0 when at least one error was found, 1 when none was,
+// and 2 for usage or input errors.
+//
+// The symbolize subcommand resolves errorx Records logged with only their
+// raw "stack" PCs. It copies JSON log lines to stdout, adding the
+// "stack_frames" of each such Record as resolved by errorx.Symbolizer from
+// the given ELF binary, so the output can be piped into errorx again:
+//
+//	errorx symbolize -binary ./server app.log | errorx
+//
+// Records whose "build_id" does not match the binary are left unresolved
+// and reported, with exit status 1, unless -force is given.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/neumachen/errorx"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type options struct {
+	format    string
+	color     bool
+	hide      *regexp.Regexp
+	trim      []string
+	maxFrames int
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "symbolize" {
+		return runSymbolize(args[1:], stdin, stdout, stderr)
+	}
+	fs := flag.NewFlagSet("errorx", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: errorx [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+	var opts options
+	fs.StringVar(&opts.format, "format", "console", "output `format`: console, json or summary")
+	color := fs.String("color", "auto", "colorize console output: auto, always or never")
+	hide := fs.String("hide", `^(runtime|testing)\.`, "drop frames whose package-qualified function matches `regexp`")
+	fs.Func("trim", "remove `prefix` from file paths (repeatable)", func(s string) error {
+		opts.trim = append(opts.trim, s)
+		return nil
+	})
+	fs.IntVar(&opts.maxFrames, "max-frames", 0, "print at most `n` frames per error; 0 prints all")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	switch opts.format {
+	case "console", "json", "summary":
+	default:
+		fmt.Fprintf(stderr, "errorx: unknown format %q\n", opts.format)
+		return 2
+	}
+	switch *color {
+	case "always":
+		opts.color = true
+	case "never":
+	case "auto":
+		opts.color = isTerminal(stdout) && os.Getenv("NO_COLOR") == ""
+	default:
+		fmt.Fprintf(stderr, "errorx: unknown color mode %q\n", *color)
+		return 2
+	}
+	if *hide != "" {
+		re, err := regexp.Compile(*hide)
+		if err != nil {
+			fmt.Fprintf(stderr, "errorx: -hide: %v\n", err)
+			return 2
+		}
+		opts.hide = re
+	}
+
+	var all []found
+	if fs.NArg() == 0 {
+		got, err := scan(stdin, "stdin")
+		if err != nil {
+			fmt.Fprintf(stderr, "errorx: stdin: %v\n", err)
+			return 2
+		}
+		all = got
+	}
+	for _, name := range fs.Args() {
+		got, err := scanFile(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "errorx: %v\n", err)
+			return 2
+		}
+		all = append(all, got...)
+	}
+	if len(all) == 0 {
+		fmt.Fprintln(stderr, "errorx: no panics or errors found")
+		return 1
+	}
+	for i := range all {
+		all[i].Err = opts.normalize(all[i].Err)
+	}
+
+	var err error
+	switch opts.format {
+	case "console":
+		err = writeConsole(stdout, all, opts)
+	case "json":
+		err = writeJSON(stdout, all)
+	case "summary":
+		err = writeSummary(stdout, all)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "errorx: %v\n", err)
+		return 2
+	}
+	return 0
+}
+
+func scanFile(name string) ([]found, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scan(f, name)
+}
+
+// normalize applies -hide and -trim to the frames of te.
+func (o options) normalize(te *errorx.TraceError) *errorx.TraceError {
+	rec := te.Record()
+	frames := make([]errorx.StackFrame, 0, len(rec.StackFrames))
+	for _, f := range rec.StackFrames {
+		if o.hide != nil && o.hide.MatchString(f.FuncName()) {
+			continue
+		}
+		for _, p := range o.trim {
+			if rest, ok := strings.CutPrefix(f.File, p); ok {
+				f.File = rest
+				break
+			}
+		}
+		frames = append(frames, f)
+	}
+	rec.StackFrames = frames
+	rec.Stack = nil
+	return errorx.FromRecord(rec)
+}
+
+func writeConsole(w io.Writer, all []found, opts options) error {
+	for i, f := range all {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		header := "==> " + f.Source + "\n"
+		if opts.color {
+			header = "\x1b[2m" + header[:len(header)-1] + "\x1b[0m\n"
+		}
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		if err := errorx.WriteConsole(w, f.Err, errorx.ConsoleOptions{Color: opts.color, MaxFrames: opts.maxFrames}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, all []found) error {
+	enc := json.NewEncoder(w)
+	for _, f := range all {
+		if err := enc.Encode(f.Err.Record()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// group is one row of the summary.
+type group struct {
+	count  int
+	first  found
+	origin string
+}
+
+func writeSummary(w io.Writer, all []found) error {
+	byKey := map[string]*group{}
+	var order []*group
+	for _, f := range all {
+		key := errorx.GroupingKey(f.Err)
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{first: f, origin: "-"}
+			if frames := f.Err.StackFrames(); len(frames) > 0 {
+				g.origin = fmt.Sprintf("%s (%s:%d)", frames[0].FuncName(), frames[0].File, frames[0].LineNumber)
+			}
+			byKey[key] = g
+			order = append(order, g)
+		}
+		g.count++
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].count > order[j].count })
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COUNT\tFIRST SEEN\tORIGIN\tMESSAGE")
+	for _, g := range order {
+		msg, _, _ := strings.Cut(g.first.Err.Error(), "\n")
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", g.count, g.first.Source, g.origin, msg)
+	}
+	return tw.Flush()
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
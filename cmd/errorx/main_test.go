@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+const mixedLog = `2024/05/01 12:00:00 starting server
+panic: runtime error: index out of range [5] with length 3
+
+goroutine 7 [running]:
+example.com/app/handler.Get(...)
+	/src/app/handler/get.go:42 +0x1d
+main.main()
+	/src/app/main.go:10 +0x20
+exit status 2
+2024/05/01 12:00:05 restarting
+fatal error: concurrent map writes
+
+goroutine 12 [chan receive]:
+runtime.throw({0x4b, 0x15})
+	/usr/local/go/src/runtime/panic.go:1023 +0x5c
+example.com/app/cache.Put(...)
+	/src/app/cache/cache.go:17 +0x40
+{"level":"error","msg":"query failed","error":{"stack":"example.com/app/db.Query\n\t/src/app/db/db.go:5\nmain.main\n\t/src/app/main.go:12"}}
+{"level":"error","message":"save failed","stack_frames":[{"file":"/src/app/store.go","line_number":9,"name":"Save","package":"example.com/app"}],"type":"*errors.errorString"}
+{"level":"info","msg":"not an error"}
+panic: boom
+this is not a goroutine section
+`
+
+func runCLI(t *testing.T, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestScan(t *testing.T) {
+	got, err := scan(strings.NewReader(mixedLog), "app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		source, msg, origin string
+	}{
+		{"app.log:2", "runtime error: index out of range [5] with length 3", "Get"},
+		{"app.log:11", "concurrent map writes", "throw"},
+		{"app.log:18", "query failed", "Query"},
+		{"app.log:19", "save failed", "Save"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("scan found %d errors, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Source != w.source || got[i].Err.Error() != w.msg {
+			t.Errorf("[%d] = %s %q, want %s %q", i, got[i].Source, got[i].Err.Error(), w.source, w.msg)
+		}
+		if frames := got[i].Err.StackFrames(); len(frames) == 0 || frames[0].Name != w.origin {
+			t.Errorf("[%d] frames = %+v, want origin %s", i, frames, w.origin)
+		}
+	}
+}
+
+func TestConsole(t *testing.T) {
+	out, _, code := runCLI(t, mixedLog, "-color", "never", "-trim", "/src/app/", "-max-frames", "1")
+	if code != 0 {
+		t.Fatalf("exit = %d", code)
+	}
+	for _, s := range []string{
+		"==> stdin:2\nerror: runtime error: index out of range [5] with length 3\n",
+		"handler/get.go:42",
+		"==> stdin:11\n",
+		"cache/cache.go:17",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output lacks %q:\n%s", s, out)
+		}
+	}
+	// runtime.throw is hidden by default and -max-frames keeps one frame.
+	if strings.Contains(out, "panic.go") || strings.Contains(out, "main.go:10") {
+		t.Errorf("output has hidden or excess frames:\n%s", out)
+	}
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("-color never produced escapes:\n%s", out)
+	}
+
+	out, _, _ = runCLI(t, mixedLog, "-color", "always")
+	if !strings.Contains(out, "\x1b[") {
+		t.Errorf("-color always produced no escapes:\n%s", out)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	out, _, code := runCLI(t, mixedLog, "-format", "json", "-hide", "")
+	if code != 0 {
+		t.Fatalf("exit = %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d records, want 4:\n%s", len(lines), out)
+	}
+	var rec errorx.Record
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Message != "concurrent map writes" || rec.Type != "panic" || len(rec.StackFrames) != 2 || rec.StackFrames[0].Name != "throw" {
+		t.Errorf("record = %+v", rec)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.log")
+	b := filepath.Join(dir, "b.log")
+	if err := os.WriteFile(a, []byte(mixedLog), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	one := mixedLog[:strings.Index(mixedLog, "exit status")]
+	if err := os.WriteFile(b, []byte(one+one), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, code := runCLI(t, "", "-format", "summary", a, b)
+	if code != 0 {
+		t.Fatalf("exit = %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want header and 4 groups:\n%s", len(lines), out)
+	}
+	if f := strings.Fields(lines[1]); f[0] != "3" || f[1] != a+":2" || f[2] != "example.com/app/handler.Get" {
+		t.Errorf("top group = %q", lines[1])
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	if _, stderr, code := runCLI(t, "nothing to see\n"); code != 1 || !strings.Contains(stderr, "no panics") {
+		t.Errorf("no errors: exit = %d, stderr = %q", code, stderr)
+	}
+	for _, args := range [][]string{
+		{"-format", "xml"},
+		{"-color", "sometimes"},
+		{"-hide", "("},
+		{filepath.Join(t.TempDir(), "missing.log")},
+	} {
+		if _, _, code := runCLI(t, mixedLog, args...); code != 2 {
+			t.Errorf("%v: exit = %d, want 2", args, code)
+		}
+	}
+}
+
+func TestSymbolize(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	if _, err := errorx.OpenSymbolizer(exe); err != nil {
+		t.Skipf("test binary cannot be symbolized: %v", err)
+	}
+	te := errorx.Errorf("lookup failed").(*errorx.TraceError)
+	rec := te.Record()
+	rec.StackFrames = nil
+	logged, _ := json.Marshal(map[string]any{"level": "error", "msg": "request failed", "error": rec})
+	rec.BuildID = "other"
+	stale, _ := json.Marshal(rec)
+	in := "plain text <kept>\n" + string(logged) + "\n" + string(stale) + "\n"
+
+	out, stderr, code := runCLI(t, in, "symbolize", "-binary", exe)
+	if code != 1 || !strings.Contains(stderr, "stdin:3: build ID mismatch") {
+		t.Errorf("exit = %d, stderr = %q; want a reported mismatch", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 3 || lines[0] != "plain text <kept>" || lines[2] != string(stale) {
+		t.Fatalf("output =\n%s", out)
+	}
+	var got struct {
+		Msg   string        `json:"msg"`
+		Error errorx.Record `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Msg != "request failed" || !reflect.DeepEqual(got.Error.StackFrames, te.StackFrames()) {
+		t.Errorf("symbolized line = %s", lines[1])
+	}
+
+	out, _, code = runCLI(t, string(stale), "symbolize", "-force", "-binary", exe)
+	if code != 0 || !strings.Contains(out, `"stack_frames"`) {
+		t.Errorf("-force: exit = %d, output = %s", code, out)
+	}
+	if _, _, code := runCLI(t, "", "symbolize"); code != 2 {
+		t.Errorf("missing -binary: exit = %d, want 2", code)
+	}
+}
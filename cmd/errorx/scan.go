@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neumachen/errorx"
+)
+
+// maxHeaderLines bounds the lines between "panic:" and the goroutine header;
+// recovered and nested panics print a few, ordinary log text many more.
+const maxHeaderLines = 16
+
+// found is one error extracted from the input.
+type found struct {
+	Err *errorx.TraceError
+	// Source is "name:line" of the line the error started on.
+	Source string
+}
+
+// scan extracts the panics and crashes from r: runtime output starting with
+// "panic: " or "fatal error: ", and JSON log lines carrying a stack or an
+// errorx Record. Everything else is skipped. name labels the input in
+// Source.
+func scan(r io.Reader, name string) ([]found, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		lines = append(lines, strings.TrimRight(sc.Text(), "\r"))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []found
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		src := name + ":" + strconv.Itoa(i+1)
+		switch {
+		case strings.HasPrefix(line, "panic: ") || strings.HasPrefix(line, "fatal error: "):
+			text, end, ok := panicBlock(lines, i)
+			if !ok {
+				continue
+			}
+			if te := parsePanic(text); te != nil {
+				out = append(out, found{Err: te, Source: src})
+				i = end - 1
+			}
+		case strings.HasPrefix(strings.TrimSpace(line), "{"):
+			var doc any
+			if json.Unmarshal([]byte(line), &doc) != nil {
+				continue
+			}
+			if te := fromJSON(doc, ""); te != nil {
+				out = append(out, found{Err: te, Source: src})
+			}
+		}
+	}
+	return out, nil
+}
+
+// panicBlock returns the runtime output starting at lines[start] and the
+// index of the first line after it. The block ends after the frames of the
+// first goroutine: at a blank line, or where the function/location line
+// pairs stop.
+func panicBlock(lines []string, start int) (string, int, bool) {
+	i := start + 1
+	for ; i < len(lines) && i-start <= maxHeaderLines; i++ {
+		if isGoroutineHeader(lines[i]) {
+			break
+		}
+	}
+	if i >= len(lines) || !isGoroutineHeader(lines[i]) {
+		return "", 0, false
+	}
+	i++
+	for i+1 < len(lines) && lines[i] != "" && !strings.HasPrefix(lines[i], "\t") && strings.HasPrefix(lines[i+1], "\t") {
+		i += 2
+	}
+	return strings.Join(lines[start:i], "\n") + "\n", i, true
+}
+
+func isGoroutineHeader(line string) bool {
+	return strings.HasPrefix(line, "goroutine ") && strings.HasSuffix(line, ":")
+}
+
+// parsePanic parses runtime output with errorx.ParsePanic. "fatal error:"
+// crashes and goroutines in states other than running are accepted by
+// rewriting them to the form ParsePanic expects.
+func parsePanic(text string) *errorx.TraceError {
+	if rest, ok := strings.CutPrefix(text, "fatal error: "); ok {
+		text = "panic: " + rest
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if isGoroutineHeader(line) && !strings.HasSuffix(line, "[running]:") {
+			if id, _, ok := strings.Cut(line, " ["); ok {
+				lines[i] = id + " [running]:"
+			}
+		}
+	}
+	e, err := errorx.ParsePanic(strings.Join(lines, "\n"))
+	if err != nil {
+		return nil
+	}
+	te, _ := e.(*errorx.TraceError)
+	return te
+}
+
+// fromJSON looks for an error in a decoded JSON log line: an errorx Record
+// (an object with stack_frames) or a string stack under "stack",
+// "stack_trace" or "stacktrace". It searches nested objects, so both
+// {"stack": ...} and {"error": {"stack": ...}} are found. msg is the
+// nearest enclosing message, used when the stack lacks a "panic:" line.
+func fromJSON(doc any, msg string) *errorx.TraceError {
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil
+	}
+	for _, key := range []string{"message", "msg", "error"} {
+		if s, ok := obj[key].(string); ok && s != "" {
+			msg = s
+			break
+		}
+	}
+	if _, ok := obj["stack_frames"].([]any); ok {
+		raw, _ := json.Marshal(obj)
+		var rec errorx.Record
+		if json.Unmarshal(raw, &rec) == nil && len(rec.StackFrames) > 0 {
+			return errorx.FromRecord(rec)
+		}
+	}
+	for _, key := range []string{"stack", "stack_trace", "stacktrace"} {
+		if s, ok := obj[key].(string); ok {
+			if te := parseStackString(s, msg); te != nil {
+				return te
+			}
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if te := fromJSON(obj[k], msg); te != nil {
+			return te
+		}
+	}
+	return nil
+}
+
+// parseStackString parses a logged stack: full runtime output, a goroutine
+// section without the panic line, or bare "pkg.Func\n\tfile:line" pairs as
+// written by errorx.StackString.
+func parseStackString(s, msg string) *errorx.TraceError {
+	s = strings.TrimLeft(s, "\n")
+	if strings.HasPrefix(s, "panic: ") || strings.HasPrefix(s, "fatal error: ") {
+		return parsePanic(s)
+	}
+	if msg == "" {
+		msg = "(no message)"
+	}
+	if i := strings.Index(s, "goroutine "); i >= 0 && isGoroutineHeader(firstLine(s[i:])) {
+		return parsePanic("panic: " + msg + "\n\n" + s[i:])
+	}
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], "\t") {
+		return nil
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "\t") && !strings.HasSuffix(line, ")") {
+			lines[i] = line + "(...)"
+		}
+	}
+	return parsePanic("panic: " + msg + "\n\ngoroutine 1 [running]:\n" + strings.Join(lines, "\n") + "\n")
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/neumachen/errorx"
+)
+
+// runSymbolize implements "errorx symbolize": it copies JSON log lines from
+// the inputs to stdout, adding stack_frames resolved against the binary to
+// every errorx Record that carries only its raw stack PCs. Other lines pass
+// through unchanged, so the output can be piped back into errorx.
+func runSymbolize(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("errorx symbolize", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: errorx symbolize -binary path [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+	binary := fs.String("binary", "", "ELF Go `path` of the binary that logged the records")
+	force := fs.Bool("force", false, "resolve records whose build ID does not match the binary")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *binary == "" {
+		fs.Usage()
+		return 2
+	}
+	sym, err := errorx.OpenSymbolizer(*binary)
+	if err != nil {
+		fmt.Fprintf(stderr, "errorx: %v\n", err)
+		return 2
+	}
+	defer sym.Close()
+
+	s := &symbolizer{sym: sym, force: *force, stderr: stderr}
+	w := bufio.NewWriter(stdout)
+	if fs.NArg() == 0 {
+		err = s.copy(w, stdin, "stdin")
+	}
+	for _, name := range fs.Args() {
+		if err != nil {
+			break
+		}
+		err = s.copyFile(w, name)
+	}
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "errorx: %v\n", err)
+		return 2
+	}
+	if s.mismatches > 0 {
+		fmt.Fprintf(stderr, "errorx: %d records left unresolved: built by a different binary (use -force to resolve anyway)\n", s.mismatches)
+		return 1
+	}
+	return 0
+}
+
+type symbolizer struct {
+	sym        *errorx.Symbolizer
+	force      bool
+	stderr     io.Writer
+	mismatches int
+}
+
+func (s *symbolizer) copyFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.copy(w, f, name)
+}
+
+func (s *symbolizer) copy(w io.Writer, r io.Reader, name string) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if out, ok := s.line(line, fmt.Sprintf("%s:%d", name, n)); ok {
+			line = out
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// line returns the symbolized form of a JSON log line, or false when the
+// line holds nothing to resolve.
+func (s *symbolizer) line(line []byte, src string) ([]byte, bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("{")) {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var doc any
+	if dec.Decode(&doc) != nil {
+		return nil, false
+	}
+	if !s.walk(doc, src) {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if enc.Encode(doc) != nil {
+		return nil, false
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), true
+}
+
+// walk resolves every Record in v that has a numeric "stack" but no
+// "stack_frames", reporting whether any was changed.
+func (s *symbolizer) walk(v any, src string) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]any:
+		if stack, ok := v["stack"].([]any); ok && len(stack) > 0 && v["stack_frames"] == nil {
+			if frames, ok := s.resolve(v, src); ok {
+				v["stack_frames"] = frames
+				return true
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			changed = s.walk(v[k], src) || changed
+		}
+	case []any:
+		for _, e := range v {
+			changed = s.walk(e, src) || changed
+		}
+	}
+	return changed
+}
+
+func (s *symbolizer) resolve(obj map[string]any, src string) ([]errorx.StackFrame, bool) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, false
+	}
+	var rec errorx.Record
+	if json.Unmarshal(raw, &rec) != nil {
+		return nil, false
+	}
+	if s.force {
+		rec.BuildID = ""
+	}
+	rec, err = s.sym.Symbolize(rec)
+	if errors.Is(err, errorx.ErrBuildIDMismatch) {
+		s.mismatches++
+		fmt.Fprintf(s.stderr, "%s: %v\n", src, strings.TrimPrefix(err.Error(), "errorx: "))
+		return nil, false
+	}
+	return rec.StackFrames, err == nil
+}
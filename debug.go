@@ -0,0 +1,182 @@
+package errorx
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// packagePath is the import path of this package, used to tell library
+// frames from application frames.
+var packagePath = reflect.TypeOf(TraceError{}).PkgPath()
+
+// DebugRecorder keeps the most recent errors in a fixed-size ring buffer for
+// on-call inspection. Writers never block each other: each Report claims a
+// slot with a single atomic increment. Nothing is recorded unless errors are
+// passed to Report or construction recording is enabled with RecordAll.
+//
+// A DebugRecorder is an http.Handler and a Reporter, so it can be mounted on
+// a debug mux and added next to other reporters.
+type DebugRecorder struct {
+	slots []atomic.Pointer[debugEntry]
+	next  atomic.Uint64
+}
+
+type debugEntry struct {
+	err error
+	at  time.Time
+	typ string
+	// te is the first *TraceError in err's chain. Its frames are only
+	// resolved when Groups is read.
+	te *TraceError
+}
+
+// frame returns the top in-app frame of the entry.
+func (e *debugEntry) frame() StackFrame {
+	if e.te == nil {
+		return StackFrame{}
+	}
+	return inAppFrame(e.te.resolvedFrames())
+}
+
+// DebugGroup aggregates the recorded errors that share a Type and top
+// in-app frame.
+type DebugGroup struct {
+	Type      string    `json:"type"`
+	Frame     string    `json:"frame"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Example is the %+v rendering of the most recent error in the group.
+	Example string `json:"example"`
+}
+
+// NewDebugRecorder returns a recorder retaining the last size errors. A
+// non-positive size selects 256.
+func NewDebugRecorder(size int) *DebugRecorder {
+	if size <= 0 {
+		size = 256
+	}
+	return &DebugRecorder{slots: make([]atomic.Pointer[debugEntry], size)}
+}
+
+// Report records err, overwriting the oldest entry once the buffer is full.
+// Nil errors are ignored.
+func (r *DebugRecorder) Report(err error) {
+	if err == nil {
+		return
+	}
+	ent := &debugEntry{err: err, at: time.Now()}
+	var te *TraceError
+	if errors.As(err, &te) && te != nil {
+		ent.typ = te.Type()
+		ent.te = te
+	} else {
+		ent.typ = reflect.TypeOf(err).String()
+	}
+	i := r.next.Add(1) - 1
+	r.slots[i%uint64(len(r.slots))].Store(ent)
+}
+
+// RecordAll registers a construction hook that records every *TraceError
+// built by this package, and returns a function that stops recording.
+func (r *DebugRecorder) RecordAll() (stop func()) {
+	return RegisterHook(func(te *TraceError, _ StackFrame) { r.Report(te) })
+}
+
+// Groups returns the buffered errors grouped by Type and top in-app frame,
+// most frequent first.
+func (r *DebugRecorder) Groups() []DebugGroup {
+	index := make(map[[2]string]int)
+	var groups []DebugGroup
+	// examples holds the most recent error of each group, rendered once
+	// the scan is done.
+	var examples []*debugEntry
+	for i := range r.slots {
+		ent := r.slots[i].Load()
+		if ent == nil {
+			continue
+		}
+		f := ent.frame()
+		frame := f.FuncName()
+		if f.File != "" {
+			frame += fmt.Sprintf(" (%s:%d)", f.File, f.LineNumber)
+		}
+		key := [2]string{ent.typ, frame}
+		gi, ok := index[key]
+		if !ok {
+			gi = len(groups)
+			index[key] = gi
+			groups = append(groups, DebugGroup{Type: ent.typ, Frame: frame, FirstSeen: ent.at})
+			examples = append(examples, ent)
+		}
+		g := &groups[gi]
+		g.Count++
+		if ent.at.Before(g.FirstSeen) {
+			g.FirstSeen = ent.at
+		}
+		if !ent.at.Before(examples[gi].at) {
+			examples[gi] = ent
+		}
+	}
+	for gi, ent := range examples {
+		groups[gi].LastSeen = ent.at
+		groups[gi].Example = fmt.Sprintf("%+v", ent.err)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].LastSeen.After(groups[j].LastSeen)
+	})
+	return groups
+}
+
+// ServeHTTP renders Groups as plain text, or as JSON when the request has
+// the query parameter format=json.
+func (r *DebugRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	groups := r.Groups()
+	if req.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(groups)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COUNT\tTYPE\tFRAME\tFIRST SEEN\tLAST SEEN")
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", g.Count, g.Type, g.Frame,
+			g.FirstSeen.Format(time.RFC3339), g.LastSeen.Format(time.RFC3339))
+	}
+	_ = tw.Flush()
+	for _, g := range groups {
+		fmt.Fprintf(w, "\n--- %s at %s\n%s\n", g.Type, g.Frame, strings.TrimRight(g.Example, "\n"))
+	}
+}
+
+// Publish exposes Groups under name on expvar's /debug/vars. Like
+// expvar.Publish it panics if name is already in use.
+func (r *DebugRecorder) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return r.Groups() }))
+}
+
+// inAppFrame returns the first frame outside this package and the Go
+// runtime, or the zero StackFrame when there is none.
+func inAppFrame(frames []StackFrame) StackFrame {
+	for _, f := range frames {
+		pkg := strings.TrimSuffix(f.Package, "/")
+		if pkg == packagePath || pkg == "runtime" {
+			continue
+		}
+		return f
+	}
+	return StackFrame{}
+}
@@ -0,0 +1,103 @@
+package errorx_test
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func debugFailA() error { return errorx.Errorf("a failed") }
+func debugFailB() error { return errorx.Errorf("b failed") }
+
+func TestDebugRecorderGroups(t *testing.T) {
+	r := errorx.NewDebugRecorder(8)
+	for i := 0; i < 3; i++ {
+		r.Report(debugFailA())
+	}
+	r.Report(debugFailB())
+	r.Report(errors.New("plain"))
+	r.Report(nil)
+
+	groups := r.Groups()
+	if len(groups) != 3 {
+		t.Fatalf("groups = %d, want 3: %+v", len(groups), groups)
+	}
+	top := groups[0]
+	if top.Count != 3 || !strings.HasSuffix(strings.Fields(top.Frame)[0], "errorx_test.debugFailA") {
+		t.Errorf("top group = %+v", top)
+	}
+	if top.FirstSeen.After(top.LastSeen) {
+		t.Errorf("first seen %v after last seen %v", top.FirstSeen, top.LastSeen)
+	}
+	if !strings.HasPrefix(top.Example, "a failed\n") {
+		t.Errorf("example = %q, want %%+v rendering", top.Example)
+	}
+}
+
+func TestDebugRecorderIsBounded(t *testing.T) {
+	r := errorx.NewDebugRecorder(4)
+	for i := 0; i < 10; i++ {
+		r.Report(debugFailA())
+	}
+	var total int
+	for _, g := range r.Groups() {
+		total += g.Count
+	}
+	if total != 4 {
+		t.Errorf("recorded %d errors, want 4", total)
+	}
+}
+
+func TestDebugRecorderRecordAll(t *testing.T) {
+	r := errorx.NewDebugRecorder(8)
+	stop := r.RecordAll()
+	_ = debugFailA()
+	stop()
+	_ = debugFailB()
+
+	groups := r.Groups()
+	if len(groups) != 1 || groups[0].Count != 1 {
+		t.Errorf("groups = %+v, want one recorded construction", groups)
+	}
+}
+
+func TestDebugRecorderServeHTTP(t *testing.T) {
+	r := errorx.NewDebugRecorder(8)
+	r.Report(debugFailA())
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/errors", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "COUNT") || !strings.Contains(body, "debugFailA") {
+		t.Errorf("text body = %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/errors?format=json", nil))
+	var groups []errorx.DebugGroup
+	if err := json.Unmarshal(rec.Body.Bytes(), &groups); err != nil || len(groups) != 1 {
+		t.Errorf("json body = %s (%v)", rec.Body.String(), err)
+	}
+}
+
+var publishSeq atomic.Int64
+
+func TestDebugRecorderPublish(t *testing.T) {
+	r := errorx.NewDebugRecorder(8)
+	r.Report(debugFailA())
+	// expvar names cannot be reused, so each run of the test needs its own.
+	name := fmt.Sprintf("errorx_test_recent_errors_%d", publishSeq.Add(1))
+	r.Publish(name)
+
+	v := expvar.Get(name)
+	if v == nil || !strings.Contains(v.String(), "debugFailA") {
+		t.Errorf("expvar value = %v", v)
+	}
+}
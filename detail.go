@@ -0,0 +1,224 @@
+package errorx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+)
+
+// WithDetail returns err with detail attached, a typed Go value that
+// Detail[T] finds again by T. Unlike SetMetadata, the value is stored as is
+// and only JSON-encoded when the error is marshaled: Record reports it in
+// Details under the name of T, e.g. "*app.RequestInfo".
+//
+// The result is a lightweight layer around err: it captures no stack and
+// runs no hooks, and its message, formatting and unwrapping are those of
+// err. Its Error methods report the stack, prefix, type and metadata of the
+// *TraceError it wraps, possibly below other detail layers; for any other
+// err they are empty, and Cause and Type describe err itself. Wrap the
+// result, or attach details to an error that is already a *TraceError, to
+// record where it happened. WithDetail returns nil if err is nil.
+func WithDetail[T any](err error, detail T) Error {
+	if err == nil {
+		return nil
+	}
+	return &detailError{err: err, detail: detail, typ: reflect.TypeFor[T]()}
+}
+
+// Detail returns the outermost value attached with WithDetail[T] in err's
+// tree, in Walk order. Values are matched by the type argument they were
+// attached with, so a value attached as an interface type is found by that
+// interface and not by its dynamic type. For errors decoded with FromRecord
+// or a BinaryDecoder, whose details are only available as JSON, it decodes
+// the entry of Record.Details named after T into a new T; an interface T
+// other than one JSON can decode into, such as any, is not restored.
+func Detail[T any](err error) (T, bool) {
+	var found T
+	var ok bool
+	typ := reflect.TypeFor[T]()
+	key := detailKey(typ)
+	Walk(err, func(layer error, _ int, _ []int) WalkAction {
+		switch l := layer.(type) {
+		case *detailError:
+			if l.typ == typ {
+				found, _ = l.detail.(T)
+				ok = true
+				return WalkStop
+			}
+		case *TraceError:
+			if l == nil {
+				return WalkContinue
+			}
+			if raw, has := l.ext().details[key]; has {
+				var d T
+				if json.Unmarshal(raw, &d) == nil {
+					found, ok = d, true
+					return WalkStop
+				}
+			}
+		}
+		return WalkContinue
+	})
+	return found, ok
+}
+
+// detailError is the layer returned by WithDetail.
+type detailError struct {
+	err    error
+	detail any
+	// typ is the type argument of WithDetail, which names the detail in
+	// Record.Details.
+	typ reflect.Type
+}
+
+func (d *detailError) Error() string { return d.err.Error() }
+
+func (d *detailError) Unwrap() error { return d.err }
+
+// inner returns the error below d and any detail layers directly beneath
+// it, and that error as a *TraceError if it is one.
+func (d *detailError) inner() (error, *TraceError) {
+	cur := d.err
+	for l, ok := cur.(*detailError); ok; l, ok = cur.(*detailError) {
+		cur = l.err
+	}
+	te, _ := cur.(*TraceError)
+	return cur, te
+}
+
+// Cause implements Error.
+func (d *detailError) Cause() error {
+	cur, te := d.inner()
+	if te != nil {
+		return te.Cause()
+	}
+	return cur
+}
+
+// Type implements Error.
+func (d *detailError) Type() string {
+	cur, te := d.inner()
+	if te != nil {
+		return te.Type()
+	}
+	return reflect.TypeOf(cur).String()
+}
+
+// Prefix implements Error.
+func (d *detailError) Prefix() string {
+	_, te := d.inner()
+	return te.Prefix()
+}
+
+// Stack implements Error.
+func (d *detailError) Stack() []uintptr {
+	_, te := d.inner()
+	return te.Stack()
+}
+
+// StackFrames implements Error.
+func (d *detailError) StackFrames() []StackFrame {
+	_, te := d.inner()
+	return te.StackFrames()
+}
+
+// RuntimeStack implements Error.
+func (d *detailError) RuntimeStack() []byte {
+	_, te := d.inner()
+	return te.RuntimeStack()
+}
+
+// Metadata implements Error.
+func (d *detailError) Metadata() *json.RawMessage {
+	_, te := d.inner()
+	return te.Metadata()
+}
+
+// SetMetadata implements Error by setting the metadata of the wrapped
+// *TraceError. It fails when there is none.
+func (d *detailError) SetMetadata(metadata *json.RawMessage) error {
+	_, te := d.inner()
+	if te == nil {
+		return errors.New("errorx: SetMetadata on a detail layer without a *TraceError")
+	}
+	return te.SetMetadata(metadata)
+}
+
+// UnmarshalMetadata implements Error.
+func (d *detailError) UnmarshalMetadata(target any) error {
+	_, te := d.inner()
+	return te.UnmarshalMetadata(target)
+}
+
+// LogValue implements slog.LogValuer.
+func (d *detailError) LogValue() slog.Value {
+	return errorLogValue(d, defaultLogOptions())
+}
+
+// MarshalJSON encodes the Record of the first *TraceError in the chain, or
+// an otherwise empty Record, with the message and details of d.
+func (d *detailError) MarshalJSON() ([]byte, error) {
+	var rec Record
+	var te *TraceError
+	if errors.As(d.err, &te) && te != nil {
+		rec = te.Record()
+	}
+	rec.Message = d.Error()
+	rec.Details = recordDetails(d)
+	return json.Marshal(rec)
+}
+
+// Format implements fmt.Formatter by delegating to the wrapped error.
+func (d *detailError) Format(st fmt.State, verb rune) {
+	if f, ok := d.err.(fmt.Formatter); ok {
+		f.Format(st, verb)
+		return
+	}
+	_, _ = fmt.Fprintf(st, fmt.FormatString(st, verb), d.err)
+}
+
+// detailKey names a detail type in Record.Details.
+func detailKey(t reflect.Type) string {
+	if t == nil {
+		return "<nil>"
+	}
+	return t.String()
+}
+
+// recordDetails encodes the details attached anywhere in err's tree,
+// keeping the outermost of each type. Values that fail to encode are left
+// out.
+func recordDetails(err error) map[string]json.RawMessage {
+	var out map[string]json.RawMessage
+	add := func(key string, raw json.RawMessage) {
+		if _, seen := out[key]; seen {
+			return
+		}
+		if out == nil {
+			out = make(map[string]json.RawMessage)
+		}
+		out[key] = raw
+	}
+	Walk(err, func(layer error, _ int, _ []int) WalkAction {
+		switch l := layer.(type) {
+		case *detailError:
+			key := detailKey(l.typ)
+			if _, seen := out[key]; !seen {
+				if raw, err := json.Marshal(l.detail); err == nil {
+					add(key, raw)
+				}
+			}
+		case *TraceError:
+			if l == nil {
+				return WalkContinue
+			}
+			for key, raw := range l.ext().details {
+				add(key, append(json.RawMessage(nil), raw...))
+			}
+		}
+		return WalkContinue
+	})
+	return out
+}
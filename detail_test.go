@@ -0,0 +1,184 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+type requestInfo struct {
+	ID      string `json:"id"`
+	Attempt int    `json:"attempt"`
+}
+
+type userInfo struct {
+	Name string `json:"name"`
+}
+
+func TestDetail(t *testing.T) {
+	base := errors.New("timeout")
+	inner := errorx.WithDetail(errorx.Wrap(base, 0), requestInfo{ID: "r-1", Attempt: 1})
+	outer := errorx.WithDetail(errorx.WrapPrefix(inner, "handle", 0), &userInfo{Name: "ann"})
+	retried := errorx.WithDetail(outer, requestInfo{ID: "r-1", Attempt: 2})
+
+	if got, ok := errorx.Detail[requestInfo](inner); !ok || got.Attempt != 1 {
+		t.Errorf("Detail[requestInfo](inner) = %+v, %v", got, ok)
+	}
+	if got, ok := errorx.Detail[requestInfo](retried); !ok || got.Attempt != 2 {
+		t.Errorf("Detail[requestInfo](retried) = %+v, %v, want the outermost", got, ok)
+	}
+	if got, ok := errorx.Detail[*userInfo](errors.Join(errors.New("other"), retried)); !ok || got.Name != "ann" {
+		t.Errorf("Detail[*userInfo] through Join = %+v, %v", got, ok)
+	}
+	if got, ok := errorx.Detail[userInfo](retried); ok {
+		t.Errorf("Detail[userInfo] matched %+v, want only *userInfo", got)
+	}
+	if _, ok := errorx.Detail[int](base); ok {
+		t.Error("Detail found a value on a plain error")
+	}
+	if retried.Error() != "handle: timeout" || !errors.Is(retried, base) {
+		t.Errorf("WithDetail changed the error: %q", retried.Error())
+	}
+	if errorx.WithDetail(nil, 1) != nil {
+		t.Error("WithDetail(nil) != nil")
+	}
+	var te *errorx.TraceError
+	if !errors.As(retried, &te) || te.Prefix() != "handle" || te.Type() != "*errorx.TraceError" {
+		t.Errorf("WithDetail added a *TraceError layer: first is %v (%s)", te, te.Type())
+	}
+	if retried.Prefix() != "handle" || !reflect.DeepEqual(retried.Stack(), te.Stack()) || retried.Cause() != te.Cause() {
+		t.Errorf("Error methods of retried = %q, %v, not those of the wrapped *TraceError", retried.Prefix(), retried.Cause())
+	}
+	md := json.RawMessage(`{"k":1}`)
+	if err := retried.SetMetadata(&md); err != nil || string(*te.Metadata()) != `{"k":1}` {
+		t.Errorf("SetMetadata through the layer = %v, metadata %s", err, te.Metadata())
+	}
+
+	// The Record of the *TraceError covers the details below it; the outer
+	// layers are reported by the MarshalJSON and LogValue of retried.
+	rec := te.Record()
+	want := map[string]json.RawMessage{"errorx_test.requestInfo": json.RawMessage(`{"id":"r-1","attempt":1}`)}
+	if !reflect.DeepEqual(rec.Details, want) {
+		t.Errorf("Record().Details = %s", rec.Details)
+	}
+	data, _ := json.Marshal(retried)
+	if !strings.Contains(string(data), `"message":"handle: timeout"`) || !strings.Contains(string(data), `"details":{"*errorx_test.userInfo":{"name":"ann"},"errorx_test.requestInfo":{"id":"r-1","attempt":2}}`) {
+		t.Errorf("MarshalJSON = %s", data)
+	}
+	if got := fmt.Sprintf("%+v", retried); got != fmt.Sprintf("%+v", te) {
+		t.Errorf("%%+v = %q, want that of the wrapped error", got)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", retried)
+	if !bytes.Contains(buf.Bytes(), []byte(`"details":{"*errorx_test.userInfo":{"name":"ann"},"errorx_test.requestInfo":{"id":"r-1","attempt":2}}`)) {
+		t.Errorf("LogValue = %s", buf.Bytes())
+	}
+}
+
+func TestDetailRoundTrip(t *testing.T) {
+	err := errorx.Wrap(errorx.WithDetail(errorx.WithDetail(errorx.Errorf("boom"), &userInfo{Name: "bo"}), requestInfo{ID: "r-2"}), 0).(*errorx.TraceError)
+	if err.Type() != "*errorx.TraceError" || err.Cause().Error() != "boom" {
+		t.Errorf("Type() = %s, Cause() = %v through detail layers", err.Type(), err.Cause())
+	}
+	decoded := errorx.FromRecord(err.Record())
+	if got, ok := errorx.Detail[requestInfo](decoded); !ok || got.ID != "r-2" {
+		t.Errorf("Detail[requestInfo] after FromRecord = %+v, %v", got, ok)
+	}
+	if got, ok := errorx.Detail[*userInfo](decoded); !ok || got.Name != "bo" {
+		t.Errorf("Detail[*userInfo] after FromRecord = %+v, %v", got, ok)
+	}
+
+	data, merr := err.MarshalBinary()
+	if merr != nil {
+		t.Fatal(merr)
+	}
+	var fromBinary errorx.TraceError
+	if uerr := fromBinary.UnmarshalBinary(data); uerr != nil {
+		t.Fatal(uerr)
+	}
+	if !reflect.DeepEqual(fromBinary.Record().Details, err.Record().Details) {
+		t.Errorf("binary round trip Details = %s, want %s", fromBinary.Record().Details, err.Record().Details)
+	}
+	if got, ok := errorx.Detail[*userInfo](&fromBinary); !ok || got.Name != "bo" {
+		t.Errorf("Detail[*userInfo] after binary round trip = %+v, %v", got, ok)
+	}
+}
+
+func TestDetailNotEncodable(t *testing.T) {
+	ch := make(chan int)
+	err := errorx.Wrap(errorx.WithDetail(errorx.Errorf("boom"), ch), 0)
+	if got, ok := errorx.Detail[chan int](err); !ok || got != ch {
+		t.Errorf("Detail[chan int] = %v, %v", got, ok)
+	}
+	if details := err.(*errorx.TraceError).Record().Details; details != nil {
+		t.Errorf("Record().Details = %s, want the value left out", details)
+	}
+	if _, merr := json.Marshal(err); merr != nil {
+		t.Errorf("MarshalJSON: %v", merr)
+	}
+}
+
+// describer is a detail attached under an interface type.
+type describer interface{ Describe() string }
+
+func (u *userInfo) Describe() string { return u.Name }
+
+func TestDetailInterfaceType(t *testing.T) {
+	base := errors.New("timeout")
+	err := errorx.Wrap(errorx.WithDetail[describer](base, &userInfo{Name: "cy"}), 0).(*errorx.TraceError)
+	if got, ok := errorx.Detail[describer](err); !ok || got.Describe() != "cy" {
+		t.Errorf("Detail[describer] = %v, %v", got, ok)
+	}
+	if got, ok := errorx.Detail[*userInfo](err); ok {
+		t.Errorf("Detail[*userInfo] matched %v, want only the attached type", got)
+	}
+	rec := err.Record()
+	if want := map[string]json.RawMessage{"errorx_test.describer": json.RawMessage(`{"name":"cy"}`)}; !reflect.DeepEqual(rec.Details, want) {
+		t.Errorf("Record().Details = %s, want it keyed by the interface", rec.Details)
+	}
+	decoded := errorx.FromRecord(rec)
+	if !reflect.DeepEqual(decoded.Record().Details, rec.Details) {
+		t.Errorf("Details after FromRecord = %s", decoded.Record().Details)
+	}
+
+	anyErr := errorx.Wrap(errorx.WithDetail[any](base, map[string]any{"n": 1.0}), 0).(*errorx.TraceError)
+	got, ok := errorx.Detail[any](errorx.FromRecord(anyErr.Record()))
+	if !ok || !reflect.DeepEqual(got, map[string]any{"n": 1.0}) {
+		t.Errorf("Detail[any] after FromRecord = %v, %v", got, ok)
+	}
+}
+
+func TestWithDetailIsLightweight(t *testing.T) {
+	base := errors.New("timeout")
+	err := errorx.WithDetail(base, requestInfo{ID: "r-3"})
+	var te *errorx.TraceError
+	if errors.As(err, &te) {
+		t.Error("WithDetail of a plain error produced a *TraceError")
+	}
+	if errors.Unwrap(err) != base || err.Error() != "timeout" {
+		t.Errorf("WithDetail layer = %q unwrapping to %v", err, errors.Unwrap(err))
+	}
+	if err.Cause() != base || err.Type() != "*errors.errorString" || err.StackFrames() != nil {
+		t.Errorf("Error methods of a layer over a plain error: Cause %v, Type %s, %d frames", err.Cause(), err.Type(), len(err.StackFrames()))
+	}
+	if serr := err.SetMetadata(nil); serr == nil {
+		t.Error("SetMetadata without a *TraceError succeeded")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { _ = errorx.WithDetail(base, 7) }); allocs > 2 {
+		t.Errorf("WithDetail allocates %v times", allocs)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", err)
+	if !bytes.Contains(buf.Bytes(), []byte(`"err":{"message":"timeout","details":{"errorx_test.requestInfo":{"id":"r-3","attempt":0}}}`)) {
+		t.Errorf("LogValue = %s", buf.Bytes())
+	}
+}
@@ -0,0 +1,74 @@
+package errorx
+
+import (
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/neumachen/errorx/internal/modroot"
+)
+
+// DeterministicOptions configures Deterministic.
+type DeterministicOptions struct {
+	// Root is the directory that file paths are made relative to. Empty
+	// selects the nearest directory at or above the working directory that
+	// holds a go.mod file. Paths outside Root keep their last directory and
+	// file name, e.g. "testing/testing.go".
+	Root string
+	// PlaceholderLines replaces every line number with 0 so that output
+	// survives edits that move code around.
+	PlaceholderLines bool
+}
+
+type deterministicState struct {
+	root  string
+	lines bool
+}
+
+var deterministic atomic.Pointer[deterministicState]
+
+// Deterministic makes stack output reproducible until the end of the
+// calling test: StackFrames, StackFrame.String, Record, Format and every
+// output derived from them report zero program counters and relative file
+// paths, and optionally placeholder line numbers; Record and LogValue omit
+// construction times. The previous mode is
+// restored through tb.Cleanup, so calls nest.
+//
+// tb is typically a *testing.T, *testing.B or *testing.F. The mode is
+// process-wide while active; do not combine it with t.Parallel. Stack() and
+// the raw bytes of FromPanic stacks are not affected.
+func Deterministic(tb interface{ Cleanup(func()) }, opts DeterministicOptions) {
+	root := opts.Root
+	if root == "" {
+		root = modroot.Find()
+	}
+	if root != "" {
+		root = filepath.ToSlash(filepath.Clean(root)) + "/"
+	}
+	prev := deterministic.Swap(&deterministicState{root: root, lines: opts.PlaceholderLines})
+	tb.Cleanup(func() { deterministic.Store(prev) })
+}
+
+// frame returns f with its program counter zeroed, its absolute path made
+// relative and, when configured, its line number replaced.
+func (d *deterministicState) frame(f StackFrame) StackFrame {
+	f.ProgramCounter = 0
+	if d.lines {
+		f.LineNumber = 0
+	}
+	file := filepath.ToSlash(f.File)
+	switch {
+	case !strings.HasPrefix(file, "/") && !filepath.IsAbs(f.File):
+		// Already relative, e.g. from an earlier pass.
+	case d.root != "" && strings.HasPrefix(file, d.root):
+		f.File = strings.TrimPrefix(file, d.root)
+	default:
+		if i := strings.LastIndex(file, "/"); i > 0 {
+			if j := strings.LastIndex(file[:i], "/"); j >= 0 {
+				file = file[j+1:]
+			}
+		}
+		f.File = file
+	}
+	return f
+}
@@ -0,0 +1,81 @@
+package errorx_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+// deterministicFixture is not inlined so that its frame's JSON is the same
+// with and without -gcflags=-l.
+//
+//go:noinline
+func deterministicFixture() *errorx.TraceError {
+	return errorx.WrapPrefix(errors.New("disk full"), "save", 0).(*errorx.TraceError)
+}
+
+func TestDeterministic(t *testing.T) {
+	t.Run("placeholders", func(t *testing.T) {
+		errorx.Deterministic(t, errorx.DeterministicOptions{PlaceholderLines: true})
+		te := deterministicFixture()
+
+		want := "save: disk full\n" +
+			"github.com/neumachen/errorxWrapPrefix\n\terror.go:0 +0x0\n" +
+			"github.com/neumachen/errorx_testdeterministicFixture\n\tdeterministic_test.go:0 +0x0\n" +
+			"github.com/neumachen/errorx_testTestDeterministic.func1\n\tdeterministic_test.go:0 +0x0\n" +
+			"testingtRunner\n\ttesting/testing.go:0 +0x0\n"
+		if got := fmt.Sprintf("%+v", te); !strings.HasPrefix(got, want) {
+			t.Errorf("%%+v =\n%s\nwant prefix\n%s", got, want)
+		}
+
+		rec := te.Record()
+		for _, pc := range rec.Stack {
+			if pc != 0 {
+				t.Fatalf("Record().Stack = %v, want zeros", rec.Stack)
+			}
+		}
+		data, err := json.Marshal(te)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `{"file":"deterministic_test.go","line_number":0,"name":"deterministicFixture","package":"github.com/neumachen/errorx_test","program_counter":0}`) {
+			t.Errorf("MarshalJSON = %s", data)
+		}
+		if len(te.Stack()) == 0 || te.Stack()[0] == 0 {
+			t.Errorf("Stack() = %v, want raw PCs", te.Stack())
+		}
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		errorx.Deterministic(t, errorx.DeterministicOptions{PlaceholderLines: true})
+		t.Run("inner", func(t *testing.T) {
+			errorx.Deterministic(t, errorx.DeterministicOptions{Root: "/nonexistent"})
+			f := deterministicFixture().StackFrames()[1]
+			// Outside Root only the last directory and file name remain.
+			if f.LineNumber == 0 || strings.Count(f.File, "/") != 1 || !strings.HasSuffix(f.File, "/deterministic_test.go") {
+				t.Errorf("inner frame = %+v", f)
+			}
+		})
+		if f := deterministicFixture().StackFrames()[1]; f.LineNumber != 0 || f.File != "deterministic_test.go" {
+			t.Errorf("outer mode not restored: %+v", f)
+		}
+	})
+
+	f := deterministicFixture().StackFrames()[1]
+	if f.ProgramCounter == 0 || !filepath.IsAbs(f.File) || f.LineNumber == 0 {
+		t.Errorf("mode leaked past the test: %+v", f)
+	}
+}
+
+func TestDeterministicStackFrameString(t *testing.T) {
+	frame := errorx.StackFrame{File: "/elsewhere/pkg/f.go", LineNumber: 7, Name: "F", Package: "example.com/pkg", ProgramCounter: 0x1234}
+	errorx.Deterministic(t, errorx.DeterministicOptions{Root: "/elsewhere"})
+	if got, want := frame.String(), "example.com/pkgF\n\tpkg/f.go:7 +0x0\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"log/slog"
 	"reflect"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // DefaultMaxStackDepth is the default cap on captured program counters per
@@ -66,12 +69,40 @@ type Record struct {
 	// Prefix is this wrapper's own prefix; it does not include prefixes
 	// contributed by wrapped TraceErrors.
 	Prefix string `json:"prefix,omitempty"`
+	// Time is when this layer was constructed, without its monotonic clock
+	// reading. It is zero for errors parsed from text, and while
+	// Deterministic is active.
+	Time time.Time `json:"time,omitzero"`
 	// StackFrames contains resolved frame data with no source-code lines.
 	StackFrames []StackFrame `json:"stack_frames,omitempty"`
-	// Stack contains the raw captured program counters.
+	// Stack contains the raw captured program counters, all zero while
+	// Deterministic is active.
 	Stack []uintptr `json:"stack,omitempty"`
+	// BuildID is the Go build ID of the binary that captured Stack, so a
+	// Symbolizer can detect PCs from a different build. It is empty when
+	// Stack is, or while Deterministic is active.
+	BuildID string `json:"build_id,omitempty"`
+	// Process describes the process that built the error. It is set only
+	// when SetIncludeProcess was enabled there, and never while
+	// Deterministic is active.
+	Process *ProcessInfo `json:"process,omitempty"`
+	// Goroutine identifies the goroutine that built the error. It is set
+	// only when SetIncludeGoroutine was enabled there; its IDs are zero
+	// while Deterministic is active.
+	Goroutine *GoroutineInfo `json:"goroutine,omitempty"`
 	// Metadata is caller-supplied raw JSON.
 	Metadata *json.RawMessage `json:"metadata,omitempty"`
+	// Violations lists the field violations of a *ValidationError found in
+	// the Unwrap chain.
+	Violations []Violation `json:"violations,omitempty"`
+	// Details holds the values attached with WithDetail in the Unwrap
+	// chain, JSON-encoded and keyed by the type argument they were attached
+	// with. The outermost value of each type wins.
+	Details map[string]json.RawMessage `json:"details,omitempty"`
+	// Chain lists the *TraceError layers of the Unwrap chain, outermost
+	// first, when SetRecordOptions enabled IncludeChain and there is more
+	// than one. FromRecord does not restore it.
+	Chain []ChainLayer `json:"chain,omitempty"`
 }
 
 // TraceError is an enriched error value with a captured stack trace, an
@@ -88,16 +119,40 @@ type Record struct {
 type TraceError struct {
 	cause  error
 	prefix string
+	// time is when the error was constructed, including the monotonic
+	// clock reading for errors constructed in this process.
+	time time.Time
 
 	// stack holds program counters captured at construction. The slice is
 	// never mutated after the struct is returned to the caller.
 	stack []uintptr
+	// extra holds the data most errors do not carry; nil for a plain
+	// error constructed in this process. Read it through ext.
+	extra *traceExtra
 
 	framesOnce sync.Once
 	frames     []StackFrame
 
 	mu       sync.RWMutex
 	metadata *json.RawMessage
+}
+
+// traceExtra is the part of a TraceError that only opt-in captures, panics
+// and decoding fill in. It is allocated on first use during construction,
+// so that the default path stays one small allocation.
+type traceExtra struct {
+	// decoded is set for errors rebuilt from a Record or the binary
+	// encoding, whose buildID is the one recorded. Other errors report the
+	// build ID of the running binary.
+	decoded bool
+	buildID string
+	// process is the info of the process that built the error; it points
+	// at the shared Process() value for errors constructed locally.
+	process *ProcessInfo
+	// goroutine describes the goroutine that built the error.
+	goroutine *GoroutineInfo
+	// details holds the JSON-encoded details of a decoded error.
+	details map[string]json.RawMessage
 
 	// debugStack is the raw runtime.Stack() / debug.Stack() bytes for
 	// errors built from a pre-formatted panic stack. It is non-nil only
@@ -109,6 +164,36 @@ type TraceError struct {
 	parsedFrames []StackFrame
 }
 
+// noExtra is what ext returns for errors without extra data. It must not
+// be modified.
+var noExtra traceExtra
+
+// ext returns the extra data of e for reading.
+func (e *TraceError) ext() *traceExtra {
+	if e.extra == nil {
+		return &noExtra
+	}
+	return e.extra
+}
+
+// setExtra returns the extra data of e for writing, allocating it. It must
+// only be called during construction.
+func (e *TraceError) setExtra() *traceExtra {
+	if e.extra == nil {
+		e.extra = new(traceExtra)
+	}
+	return e.extra
+}
+
+// captureExtra records the opt-in process and goroutine info into e.
+func (e *TraceError) captureExtra() {
+	p, g := capturedProcess(), capturedGoroutine()
+	if p != nil || g != nil {
+		x := e.setExtra()
+		x.process, x.goroutine = p, g
+	}
+}
+
 // captureStack records up to MaxStackDepth program counters, skipping the
 // given number of frames. The returned slice is owned by the caller.
 func captureStack(skip int) []uintptr {
@@ -123,13 +208,39 @@ func captureStack(skip int) []uintptr {
 	return out
 }
 
-// newTraceError builds a *TraceError around the given cause with a fresh
-// stack capture. The caller is responsible for nil-checking cause.
-func newTraceError(cause error, skip int) *TraceError {
-	return &TraceError{
+var clock atomic.Pointer[Clock]
+
+// SetClock replaces the time source that stamps errors on construction
+// (see Time), typically with a fake in tests. Passing nil restores
+// SystemClock. It is safe for concurrent use.
+func SetClock(c Clock) {
+	if c == nil {
+		clock.Store(nil)
+		return
+	}
+	clock.Store(&c)
+}
+
+// now returns the construction time from the installed Clock.
+func now() time.Time {
+	if c := clock.Load(); c != nil {
+		return (*c).Now()
+	}
+	return time.Now()
+}
+
+// newTraceError builds a *TraceError around the given cause and stack. The
+// constructors capture the stack themselves, so that runtime.Callers has no
+// frame of this function to unwind. The caller is responsible for
+// nil-checking cause.
+func newTraceError(cause error, stack []uintptr) *TraceError {
+	te := &TraceError{
 		cause: cause,
-		stack: captureStack(skip + 1),
+		time:  now(),
+		stack: stack,
 	}
+	te.captureExtra()
+	return te
 }
 
 // NewError returns a *TraceError wrapping cause. It returns nil if cause is
@@ -138,7 +249,9 @@ func NewError(cause error) Error {
 	if cause == nil {
 		return nil
 	}
-	return newTraceError(cause, 1)
+	te := newTraceError(cause, captureStack(1))
+	constructed(te, 0)
+	return te
 }
 
 // NewErrorf creates a *TraceError from a formatted message. The message is
@@ -147,14 +260,18 @@ func NewError(cause error) Error {
 //
 // Deprecated: prefer Errorf. NewErrorf is retained for source compatibility.
 func NewErrorf(format string, a ...any) Error {
-	return newTraceError(fmt.Errorf(format, a...), 1)
+	te := newTraceError(fmt.Errorf(format, a...), captureStack(1))
+	constructed(te, 0)
+	return te
 }
 
 // Errorf creates a *TraceError from a formatted message. It is the canonical
 // alias for the historical NewErrorf and is the form the README recommends.
 // %w directives participate in errors.Is / errors.As.
 func Errorf(format string, a ...any) Error {
-	return newTraceError(fmt.Errorf(format, a...), 1)
+	te := newTraceError(fmt.Errorf(format, a...), captureStack(1))
+	constructed(te, 0)
+	return te
 }
 
 // Wrap returns a *TraceError around err with a fresh stack capture at the
@@ -168,7 +285,9 @@ func Wrap(err error, stackToSkip int) Error {
 	if err == nil {
 		return nil
 	}
-	return newTraceError(err, stackToSkip+1)
+	te := newTraceError(err, captureStack(stackToSkip+1))
+	constructed(te, 0)
+	return te
 }
 
 // WrapPrefix returns a new *TraceError that wraps err and prepends prefix to
@@ -181,8 +300,9 @@ func WrapPrefix(err error, prefix string, skip int) Error {
 	if err == nil {
 		return nil
 	}
-	te := newTraceError(err, skip+1)
+	te := newTraceError(err, captureStack(skip+1))
 	te.prefix = prefix
+	constructed(te, 0)
 	return te
 }
 
@@ -207,15 +327,30 @@ func Is(err, target error) bool {
 //	        err = errorx.FromPanic(r, debug.Stack())
 //	    }
 //	}()
+//
+// Registered construction hooks run before the error is returned. When a
+// panic Reporter is installed with SetPanicReporter, the error is also
+// handed to it.
 func FromPanic(value any, stack []byte) *TraceError {
+	te := fromPanic(value, stack)
+	constructed(te, 0)
+	reportPanic(te)
+	return te
+}
+
+// fromPanic builds the FromPanic error without reporting it. When no stack
+// is supplied the capture starts at the caller of fromPanic's caller.
+func fromPanic(value any, stack []byte) *TraceError {
 	te := &TraceError{
-		cause:      uncaughtPanic{message: fmt.Sprint(value)},
-		debugStack: append([]byte(nil), stack...),
+		cause: uncaughtPanic{message: fmt.Sprint(value)},
+		time:  now(),
 	}
 	if len(stack) == 0 {
-		te.debugStack = nil
-		te.stack = captureStack(1)
+		te.stack = captureStack(2)
+	} else {
+		te.setExtra().debugStack = append([]byte(nil), stack...)
 	}
+	te.captureExtra()
 	return te
 }
 
@@ -247,15 +382,23 @@ func (e *TraceError) Unwrap() error {
 	return e.cause
 }
 
-// Cause returns the deepest non-TraceError cause in the wrapper chain. It is
-// retained for source compatibility with callers that want the "original"
-// error; new code should use errors.Is / errors.As / errors.Unwrap.
+// Cause returns the deepest non-TraceError cause in the wrapper chain,
+// looking through layers added by WithDetail. It is retained for source
+// compatibility with callers that want the "original" error; new code
+// should use errors.Is / errors.As / errors.Unwrap.
 func (e *TraceError) Cause() error {
 	if e == nil {
 		return nil
 	}
 	cur := e.cause
 	for {
+		if r, ok := cur.(*remoteError); ok && r.root != nil {
+			return r.root
+		}
+		if d, ok := cur.(*detailError); ok {
+			cur = d.err
+			continue
+		}
 		next, ok := cur.(*TraceError)
 		if !ok || next == nil {
 			return cur
@@ -273,18 +416,26 @@ func (e *TraceError) Prefix() string {
 	return e.prefix
 }
 
-// Type returns a Go type string describing the underlying cause. For errors
-// produced by ParsePanic or FromPanic it returns "panic". The empty string is
-// returned when no cause is present. The result is diagnostic only and is
-// not stable enough for domain control flow.
+// Type returns a Go type string describing the underlying cause, ignoring
+// layers added by WithDetail. For errors produced by ParsePanic or FromPanic
+// it returns "panic". The empty string is returned when no cause is
+// present. The result is diagnostic only and is not stable enough for
+// domain control flow.
 func (e *TraceError) Type() string {
 	if e == nil || e.cause == nil {
 		return ""
 	}
-	if _, ok := e.cause.(uncaughtPanic); ok {
+	cause := e.cause
+	for d, ok := cause.(*detailError); ok; d, ok = cause.(*detailError) {
+		cause = d.err
+	}
+	switch c := cause.(type) {
+	case uncaughtPanic:
 		return "panic"
+	case *remoteError:
+		return c.typ
 	}
-	return reflect.TypeOf(e.cause).String()
+	return reflect.TypeOf(cause).String()
 }
 
 // Stack returns a copy of the captured program counters. Callers may
@@ -298,17 +449,57 @@ func (e *TraceError) Stack() []uintptr {
 	return out
 }
 
+// BuildID returns the Go build ID of the binary the stack was captured in,
+// for resolving Stack offline with a Symbolizer. It is empty when there is
+// no captured stack or the build ID could not be read.
+func (e *TraceError) BuildID() string {
+	if e == nil || len(e.stack) == 0 {
+		return ""
+	}
+	if x := e.ext(); x.decoded {
+		return x.buildID
+	}
+	return executableBuildID()
+}
+
+// Time returns when the error was constructed. For errors constructed in
+// this process it carries a monotonic clock reading, so the latency between
+// layers of a chain is outer.Time().Sub(inner.Time()). It is zero for
+// errors built by ParsePanic, and for decoded errors that were encoded
+// without a time.
+func (e *TraceError) Time() time.Time {
+	if e == nil {
+		return time.Time{}
+	}
+	return e.time
+}
+
 // StackFrames returns a copy of the resolved stack frame data. Frames are
 // resolved lazily on first call. Subsequent calls reuse the cached frames
-// and return a fresh copy each time.
+// and return a fresh copy each time, normalized while Deterministic is
+// active.
 func (e *TraceError) StackFrames() []StackFrame {
 	if e == nil {
 		return nil
 	}
+	frames := e.resolvedFrames()
+	out := make([]StackFrame, len(frames))
+	copy(out, frames)
+	if d := deterministic.Load(); d != nil {
+		for i := range out {
+			out[i] = d.frame(out[i])
+		}
+	}
+	return out
+}
+
+// resolvedFrames returns the cached frames, resolving them on first use.
+// The result is shared and must not be modified.
+func (e *TraceError) resolvedFrames() []StackFrame {
 	e.framesOnce.Do(func() {
 		switch {
-		case e.parsedFrames != nil:
-			e.frames = e.parsedFrames
+		case e.ext().parsedFrames != nil:
+			e.frames = e.ext().parsedFrames
 		case len(e.stack) > 0:
 			frames := make([]StackFrame, 0, len(e.stack))
 			it := runtime.CallersFrames(e.stack)
@@ -319,14 +510,7 @@ func (e *TraceError) StackFrames() []StackFrame {
 				if i < len(e.stack) {
 					pc = e.stack[i]
 				}
-				pkg, name := splitPackageAndName(rf.Function)
-				frames = append(frames, StackFrame{
-					File:           rf.File,
-					LineNumber:     rf.Line,
-					Name:           name,
-					Package:        pkg,
-					ProgramCounter: pc,
-				})
+				frames = append(frames, newRuntimeFrame(rf, pc))
 				i++
 				if !more {
 					break
@@ -335,9 +519,7 @@ func (e *TraceError) StackFrames() []StackFrame {
 			e.frames = frames
 		}
 	})
-	out := make([]StackFrame, len(e.frames))
-	copy(out, e.frames)
-	return out
+	return e.frames
 }
 
 // RuntimeStack returns a formatted byte slice describing the captured stack.
@@ -350,9 +532,9 @@ func (e *TraceError) RuntimeStack() []byte {
 	if e == nil {
 		return nil
 	}
-	if len(e.debugStack) > 0 {
-		out := make([]byte, len(e.debugStack))
-		copy(out, e.debugStack)
+	if debugStack := e.ext().debugStack; len(debugStack) > 0 {
+		out := make([]byte, len(debugStack))
+		copy(out, debugStack)
 		return out
 	}
 	frames := e.StackFrames()
@@ -429,15 +611,78 @@ func (e *TraceError) Record() Record {
 	if c := e.Cause(); c != nil {
 		causeMsg = c.Error()
 	}
+	var violations []Violation
+	var ve *ValidationError
+	if errors.As(e, &ve) {
+		violations = ve.Violations()
+	}
+	stack, buildID, at := e.Stack(), e.BuildID(), e.time.Round(0)
+	if deterministic.Load() != nil {
+		clear(stack)
+		buildID = ""
+		at = time.Time{}
+	}
 	return Record{
 		Message:     e.Error(),
 		Cause:       causeMsg,
 		Type:        e.Type(),
 		Prefix:      e.Prefix(),
+		Time:        at,
 		StackFrames: e.StackFrames(),
-		Stack:       e.Stack(),
+		Stack:       stack,
+		BuildID:     buildID,
+		Process:     e.processInfo(),
+		Goroutine:   e.goroutineInfo(),
 		Metadata:    e.Metadata(),
+		Violations:  violations,
+		Details:     recordDetails(e),
+		Chain:       recordChain(e),
+	}
+}
+
+// FromRecord rebuilds a *TraceError from a Record, typically one received
+// from another process. The result reports the record's message, Type,
+// prefix, time, cause text, metadata, details, PCs and frames; its cause is
+// an opaque error carrying the original text, so errors.Is against the
+// original sentinels does not match; Violations are restored as a
+// *ValidationError in the chain, and Detail decodes Details on demand. A
+// Record whose Message does not start with its Prefix is treated as
+// unprefixed.
+func FromRecord(r Record) *TraceError {
+	te := &TraceError{
+		time:  r.Time,
+		stack: append([]uintptr(nil), r.Stack...),
+		extra: &traceExtra{
+			decoded:      true,
+			buildID:      r.BuildID,
+			parsedFrames: append([]StackFrame{}, r.StackFrames...),
+		},
+	}
+	if r.Process != nil {
+		p := *r.Process
+		te.extra.process = &p
+	}
+	if r.Goroutine != nil {
+		g := *r.Goroutine
+		te.extra.goroutine = &g
+	}
+	msg := r.Message
+	if r.Prefix != "" && strings.HasPrefix(msg, r.Prefix+": ") {
+		te.prefix = r.Prefix
+		msg = strings.TrimPrefix(msg, r.Prefix+": ")
+	}
+	te.cause = decodedCause(msg, r.Type, r.Cause, append([]Violation(nil), r.Violations...))
+	if r.Metadata != nil {
+		md := append(json.RawMessage(nil), *r.Metadata...)
+		te.metadata = &md
+	}
+	for key, raw := range r.Details {
+		if te.extra.details == nil {
+			te.extra.details = make(map[string]json.RawMessage, len(r.Details))
+		}
+		te.extra.details[key] = append(json.RawMessage(nil), raw...)
 	}
+	return te
 }
 
 // MarshalJSON encodes the error as a Record.
@@ -448,41 +693,33 @@ func (e *TraceError) MarshalJSON() ([]byte, error) {
 	return json.Marshal(e.Record())
 }
 
-// LogValue returns a slog.Value with the structured fields from Record. The
-// raw stack PCs are omitted from the slog output to keep log lines compact;
-// they remain available via JSON marshaling and the Stack method.
+// LogValue returns a slog.Value with the structured fields from Record,
+// shaped by the package-wide LogOptions (see SetDefaultLogOptions). With the
+// standard options the raw stack PCs are omitted from the slog output to
+// keep log lines compact; they remain available via JSON marshaling and the
+// Stack method.
 func (e *TraceError) LogValue() slog.Value {
 	if e == nil {
 		return slog.Value{}
 	}
-	r := e.Record()
-	attrs := make([]slog.Attr, 0, 6)
-	if r.Message != "" {
-		attrs = append(attrs, slog.String("message", r.Message))
-	}
-	if r.Cause != "" && r.Cause != r.Message {
-		attrs = append(attrs, slog.String("cause", r.Cause))
-	}
-	if r.Type != "" {
-		attrs = append(attrs, slog.String("type", r.Type))
-	}
-	if r.Prefix != "" {
-		attrs = append(attrs, slog.String("prefix", r.Prefix))
-	}
-	if len(r.StackFrames) > 0 {
-		attrs = append(attrs, slog.Any("stack_frames", r.StackFrames))
-	}
-	if r.Metadata != nil {
-		attrs = append(attrs, slog.Any("metadata", r.Metadata))
+	return e.logValue(e, defaultLogOptions())
+}
+
+// LogValueWith is like LogValue but uses opts instead of the package-wide
+// defaults.
+func (e *TraceError) LogValueWith(opts LogOptions) slog.Value {
+	if e == nil {
+		return slog.Value{}
 	}
-	return slog.GroupValue(attrs...)
+	return e.logValue(e, opts)
 }
 
 // Format implements fmt.Formatter.
 //
 //	%s, %v  → Error()
 //	%q      → quoted Error()
-//	%+v     → Error() followed by RuntimeStack()
+//	%+v     → Error() followed by RuntimeStack(), or every layer of the
+//	          chain when FormatOptions.ElideCommonFrames is set
 func (e *TraceError) Format(s fmt.State, verb rune) {
 	if e == nil {
 		_, _ = io.WriteString(s, "<nil>")
@@ -491,6 +728,10 @@ func (e *TraceError) Format(s fmt.State, verb rune) {
 	switch verb {
 	case 'v':
 		if s.Flag('+') {
+			if opts := formatOptions.Load(); opts != nil && opts.ElideCommonFrames {
+				e.formatChain(s)
+				return
+			}
 			_, _ = io.WriteString(s, e.Error())
 			_, _ = s.Write([]byte{'\n'})
 			_, _ = s.Write(e.RuntimeStack())
@@ -63,6 +63,16 @@ func TestNewError(t *testing.T) {
 	}
 }
 
+func TestNewErrorAllocs(t *testing.T) {
+	// The stack buffer, the stack and the TraceError; opt-in data lives in
+	// a side struct that plain errors never allocate.
+	var err error
+	if allocs := testing.AllocsPerRun(100, func() { err = errorx.NewError(errBench) }); allocs > 3 {
+		t.Errorf("NewError allocates %v times, want at most 3", allocs)
+	}
+	_ = err
+}
+
 func TestNilHandling(t *testing.T) {
 	if got := errorx.NewError(nil); got != nil {
 		t.Errorf("NewError(nil) = %v, want nil", got)
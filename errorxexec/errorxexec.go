@@ -0,0 +1,251 @@
+// Package errorxexec runs external commands so that their failures are
+// *errorx.TraceError values carrying the command context.
+//
+// Every error gets a CommandInfo detail, retrievable with
+// errorx.Detail[errorxexec.CommandInfo] and reported by Record and LogValue
+// under "details", holding the command line (after an optional redaction
+// hook), the working directory, the exit code or terminating signal, the
+// tail of what the command wrote to stderr and how long it ran. The
+// original error stays in the chain, so errors.As still finds the
+// *exec.ExitError:
+//
+//	cmd := exec.CommandContext(ctx, "git", "fetch", remote)
+//	if err := errorxexec.Run(cmd, errorxexec.Options{}); err != nil {
+//	    logger.Error("fetch failed", "err", err)
+//	}
+package errorxexec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/neumachen/errorx"
+)
+
+// DefaultStderrTail is the default number of trailing stderr bytes kept.
+const DefaultStderrTail = 4096
+
+// CommandInfo is the detail attached to every error returned by this
+// package.
+type CommandInfo struct {
+	// Args is the command line, including the program name as given,
+	// after Options.Redact.
+	Args []string `json:"args"`
+	// Dir is the working directory, empty for the current one.
+	Dir string `json:"dir,omitempty"`
+	// ExitCode is the exit status, or -1 when the command did not exit
+	// normally: it was killed by a signal or never started.
+	ExitCode int `json:"exit_code"`
+	// Signal names the signal that terminated the command.
+	Signal string `json:"signal,omitempty"`
+	// Stderr is the tail of the command's standard error, at most
+	// Options.StderrTail bytes.
+	Stderr string `json:"stderr,omitempty"`
+	// Duration is how long the command ran. It is zero for errors passed
+	// to Wrap.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Options configures Run, Output and Wrap. The zero value of every field
+// selects a sensible default.
+type Options struct {
+	// Redact rewrites the command line before it is recorded, to keep
+	// secrets passed as arguments out of logs and reports. It receives a
+	// copy it may modify. Default: the command line is recorded as is.
+	Redact func(args []string) []string
+	// StderrTail is the number of trailing stderr bytes kept. Default
+	// DefaultStderrTail; a negative value keeps none.
+	StderrTail int
+	// Clock is the time source for durations. Default errorx.SystemClock.
+	Clock errorx.Clock
+}
+
+func (o Options) withDefaults() Options {
+	if o.StderrTail == 0 {
+		o.StderrTail = DefaultStderrTail
+	}
+	if o.Clock == nil {
+		o.Clock = errorx.SystemClock
+	}
+	return o
+}
+
+// RedactFlags returns an Options.Redact hook that replaces the value of
+// each named flag with "REDACTED", in both the "-flag value" and
+// "-flag=value" forms. Names include their dashes, e.g. "--password".
+func RedactFlags(names ...string) func(args []string) []string {
+	return func(args []string) []string {
+		for i := 0; i < len(args); i++ {
+			for _, name := range names {
+				switch {
+				case args[i] == name && i+1 < len(args):
+					i++
+					args[i] = "REDACTED"
+				case strings.HasPrefix(args[i], name+"="):
+					args[i] = name + "=REDACTED"
+				default:
+					continue
+				}
+				break
+			}
+		}
+		return args
+	}
+}
+
+// Run runs cmd like cmd.Run and returns its error, if any, with a
+// CommandInfo detail. The tail of stderr is captured in addition to any
+// writer already set as cmd.Stderr, except an *os.File such as os.Stderr:
+// capturing would make os/exec give the command a pipe instead of the file,
+// changing what it sees when it checks for a terminal, so the file is left
+// as is and no tail is recorded.
+func Run(cmd *exec.Cmd, opts Options) error {
+	opts = opts.withDefaults()
+	tail := captureStderr(cmd, opts.StderrTail)
+	start := opts.Clock.Now()
+	err := cmd.Run()
+	return wrap(cmd, err, tail.String(), opts.Clock.Now().Sub(start), opts)
+}
+
+// Output runs cmd like cmd.Output and returns its standard output and its
+// error, if any, with a CommandInfo detail. When cmd.Stderr is nil, it is
+// left so, and the *exec.ExitError holds stderr as with cmd.Output; the
+// tail is taken from there. Other writers are handled as in Run.
+func Output(cmd *exec.Cmd, opts Options) ([]byte, error) {
+	opts = opts.withDefaults()
+	var tail *tailBuffer
+	if cmd.Stderr != nil {
+		tail = captureStderr(cmd, opts.StderrTail)
+	}
+	start := opts.Clock.Now()
+	out, err := cmd.Output()
+	d := opts.Clock.Now().Sub(start)
+	stderr := exitStderr(err)
+	if tail != nil {
+		stderr = tail.String()
+	}
+	return out, wrap(cmd, err, stderr, d, opts)
+}
+
+// Wrap returns err, the error of running cmd by other means, with a
+// CommandInfo detail. The stderr tail is taken from the *exec.ExitError,
+// which holds it when cmd ran through cmd.Output without cmd.Stderr set.
+// Wrap returns nil if err is nil.
+func Wrap(cmd *exec.Cmd, err error, opts Options) error {
+	return wrap(cmd, err, exitStderr(err), 0, opts.withDefaults())
+}
+
+// exitStderr returns the stderr held by the *exec.ExitError in err's chain.
+func exitStderr(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(exitErr.Stderr)
+	}
+	return ""
+}
+
+func wrap(cmd *exec.Cmd, err error, stderr string, d time.Duration, opts Options) error {
+	if err == nil {
+		return nil
+	}
+	args := append([]string(nil), cmd.Args...)
+	if len(args) == 0 {
+		args = []string{cmd.Path}
+	}
+	if opts.Redact != nil {
+		args = opts.Redact(args)
+	}
+	info := CommandInfo{
+		Args:     args,
+		Dir:      cmd.Dir,
+		ExitCode: -1,
+		Stderr:   lastBytes(stderr, opts.StderrTail),
+		Duration: d,
+	}
+	if ps := cmd.ProcessState; ps != nil {
+		info.ExitCode = ps.ExitCode()
+		if ws, ok := ps.Sys().(interface {
+			Signaled() bool
+			Signal() syscall.Signal
+		}); ok && ws.Signaled() {
+			info.Signal = ws.Signal().String()
+		}
+	}
+	// Skip errorx.Wrap, wrap and the exported function that called it, so
+	// that the stack starts at the application's call site.
+	return errorx.Wrap(errorx.WithDetail(err, info), 3)
+}
+
+// captureStderr adds a tail buffer to cmd.Stderr. It leaves cmd.Stderr nil
+// when keeping no bytes, and leaves an *os.File alone.
+func captureStderr(cmd *exec.Cmd, limit int) *tailBuffer {
+	tail := &tailBuffer{limit: limit}
+	if _, isFile := cmd.Stderr.(*os.File); limit <= 0 || isFile {
+		return tail
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = tail
+	} else {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, tail)
+	}
+	return tail
+}
+
+// tailBuffer is an io.Writer keeping the last limit bytes written.
+type tailBuffer struct {
+	limit int
+	buf   bytes.Buffer
+	// cut is set once bytes have been dropped from the front.
+	cut bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) >= t.limit {
+		t.cut = t.cut || t.buf.Len() > 0 || len(p) > t.limit
+		t.buf.Reset()
+		p = p[len(p)-t.limit:]
+	} else if over := t.buf.Len() + len(p) - t.limit; over > 0 {
+		t.cut = true
+		t.buf.Next(over)
+	}
+	t.buf.Write(p)
+	return n, nil
+}
+
+// String returns the bytes kept, starting at a rune boundary.
+func (t *tailBuffer) String() string {
+	if t.cut {
+		return trimPartialRune(t.buf.String())
+	}
+	return t.buf.String()
+}
+
+// lastBytes returns at most the last limit bytes of s, starting at a rune
+// boundary, or "" for a non-positive limit.
+func lastBytes(s string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	if len(s) > limit {
+		return trimPartialRune(s[len(s)-limit:])
+	}
+	return s
+}
+
+// trimPartialRune drops the continuation bytes of a UTF-8 sequence cut off
+// at the start of s.
+func trimPartialRune(s string) string {
+	i := 0
+	for i < len(s) && i < utf8.UTFMax-1 && !utf8.RuneStart(s[i]) {
+		i++
+	}
+	return s[i:]
+}
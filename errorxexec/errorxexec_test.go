@@ -0,0 +1,236 @@
+package errorxexec_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/neumachen/errorx"
+	"github.com/neumachen/errorx/errorxexec"
+)
+
+// helperEnv selects the behavior of the test binary re-executed as a
+// command.
+const helperEnv = "ERRORXEXEC_HELPER"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "fail":
+		for i := range 1000 {
+			fmt.Fprintf(os.Stderr, "line %d\n", i)
+		}
+		fmt.Fprint(os.Stderr, "fatal: bad credentials")
+		os.Exit(3)
+	case "utf8":
+		fmt.Fprint(os.Stderr, "fatal: wörld")
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+	case "ok":
+		fmt.Print("done")
+	}
+	os.Exit(0)
+}
+
+func helper(mode string, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), helperEnv+"="+mode)
+	return cmd
+}
+
+// stepClock advances by 5ms every time it is read.
+type stepClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(5 * time.Millisecond)
+	return c.now
+}
+
+func (c *stepClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func commandInfo(t *testing.T, err error) errorxexec.CommandInfo {
+	t.Helper()
+	var te *errorx.TraceError
+	if !errors.As(err, &te) {
+		t.Fatalf("%v (%T) is not a *TraceError", err, err)
+	}
+	info, ok := errorx.Detail[errorxexec.CommandInfo](err)
+	if !ok {
+		t.Fatalf("%v carries no CommandInfo", err)
+	}
+	return info
+}
+
+func TestRun(t *testing.T) {
+	var stderr bytes.Buffer
+	cmd := helper("fail", "clone", "--token", "s3cret", "--password=hunter2", "repo")
+	cmd.Dir = t.TempDir()
+	cmd.Stderr = &stderr
+	err := errorxexec.Run(cmd, errorxexec.Options{
+		Redact:     errorxexec.RedactFlags("--token", "--password"),
+		StderrTail: 31,
+		Clock:      &stepClock{},
+	})
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || err.Error() != "exit status 3" {
+		t.Fatalf("Run = %v", err)
+	}
+	info := commandInfo(t, err)
+	want := errorxexec.CommandInfo{
+		Args:     []string{os.Args[0], "clone", "--token", "REDACTED", "--password=REDACTED", "repo"},
+		Dir:      cmd.Dir,
+		ExitCode: 3,
+		Stderr:   "line 999\nfatal: bad credentials",
+		Duration: 5 * time.Millisecond,
+	}
+	if fmt.Sprint(info) != fmt.Sprint(want) {
+		t.Errorf("CommandInfo = %+v\nwant %+v", info, want)
+	}
+	if !strings.HasSuffix(stderr.String(), "fatal: bad credentials") || !strings.HasPrefix(stderr.String(), "line 0\n") {
+		t.Error("cmd.Stderr did not receive the full output")
+	}
+	if cmd.Args[3] != "s3cret" {
+		t.Error("Redact modified cmd.Args")
+	}
+
+	rec, _ := json.Marshal(err.(*errorx.TraceError).Record().Details)
+	if !strings.Contains(string(rec), `"errorxexec.CommandInfo":{"args":[`) || !strings.Contains(string(rec), `"exit_code":3,"stderr":"line 999\nfatal: bad credentials","duration":5000000}`) {
+		t.Errorf("Record().Details = %s", rec)
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("clone failed", "err", err)
+	if !strings.Contains(buf.String(), `"details":{"errorxexec.CommandInfo":{"args":`) || strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("LogValue = %s", buf.String())
+	}
+
+	if err := errorxexec.Run(helper("ok"), errorxexec.Options{}); err != nil {
+		t.Errorf("Run of a successful command = %v", err)
+	}
+}
+
+func TestRunSignal(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("no signals")
+	}
+	cmd := helper("hang")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Process.Kill()
+	err := errorxexec.Wrap(cmd, cmd.Wait(), errorxexec.Options{})
+	if info := commandInfo(t, err); info.ExitCode != -1 || info.Signal != "killed" || info.Duration != 0 {
+		t.Errorf("CommandInfo = %+v", info)
+	}
+}
+
+func TestOutputAndWrap(t *testing.T) {
+	out, err := errorxexec.Output(helper("ok"), errorxexec.Options{})
+	if err != nil || string(out) != "done" {
+		t.Errorf("Output = %q, %v", out, err)
+	}
+
+	cmd := helper("fail")
+	_, err = cmd.Output()
+	err = errorxexec.Wrap(cmd, err, errorxexec.Options{StderrTail: 5})
+	if info := commandInfo(t, err); info.ExitCode != 3 || info.Stderr != "tials" {
+		t.Errorf("Wrap after cmd.Output: CommandInfo = %+v", info)
+	}
+
+	_, err = errorxexec.Output(helper("fail"), errorxexec.Options{StderrTail: 5})
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || !bytes.HasSuffix(exitErr.Stderr, []byte("fatal: bad credentials")) {
+		t.Errorf("Output left ExitError.Stderr = %q", exitErr.Stderr)
+	}
+	if info := commandInfo(t, err); info.Stderr != "tials" {
+		t.Errorf("Output: CommandInfo.Stderr = %q", info.Stderr)
+	}
+
+	_, err = errorxexec.Output(exec.Command("errorxexec-no-such-command"), errorxexec.Options{})
+	if info := commandInfo(t, err); !errors.Is(err, exec.ErrNotFound) || info.ExitCode != -1 || info.Args[0] != "errorxexec-no-such-command" {
+		t.Errorf("Output of a missing command = %v, %+v", err, info)
+	}
+	if errorxexec.Wrap(cmd, nil, errorxexec.Options{}) != nil {
+		t.Error("Wrap(nil) != nil")
+	}
+}
+
+func TestStderrTailRuneBoundary(t *testing.T) {
+	// The last 4 bytes of "wörld" start in the middle of "ö".
+	opts := errorxexec.Options{StderrTail: 4}
+	if info := commandInfo(t, errorxexec.Run(helper("utf8"), opts)); info.Stderr != "rld" {
+		t.Errorf("Run: CommandInfo.Stderr = %q, want %q", info.Stderr, "rld")
+	}
+	var stderr bytes.Buffer
+	cmd := helper("utf8")
+	cmd.Stderr = &stderr
+	if info := commandInfo(t, errorxexec.Run(cmd, errorxexec.Options{StderrTail: 5})); !utf8.ValidString(info.Stderr) || info.Stderr != "örld" {
+		t.Errorf("Run: CommandInfo.Stderr = %q, want %q", info.Stderr, "örld")
+	}
+	_, err := errorxexec.Output(helper("utf8"), opts)
+	if info := commandInfo(t, err); info.Stderr != "rld" {
+		t.Errorf("Output: CommandInfo.Stderr = %q, want %q", info.Stderr, "rld")
+	}
+}
+
+func runFail() error { return errorxexec.Run(helper("fail"), errorxexec.Options{}) }
+
+func outputFail() error {
+	_, err := errorxexec.Output(helper("utf8"), errorxexec.Options{})
+	return err
+}
+
+func wrapFail() error {
+	cmd := helper("fail")
+	return errorxexec.Wrap(cmd, cmd.Run(), errorxexec.Options{})
+}
+
+func TestErrorOrigin(t *testing.T) {
+	errs := map[string]error{"runFail": runFail(), "outputFail": outputFail(), "wrapFail": wrapFail()}
+	keys := make(map[string]string)
+	for name, err := range errs {
+		var te *errorx.TraceError
+		if !errors.As(err, &te) || te.StackFrames()[0].Name != name {
+			t.Errorf("%s: first frame = %+v, want the call site", name, te.StackFrames()[0])
+		}
+		if other, dup := keys[errorx.OriginKey(err)]; dup {
+			t.Errorf("%s and %s share OriginKey %s", name, other, errorx.OriginKey(err))
+		}
+		keys[errorx.OriginKey(err)] = name
+	}
+}
+
+func TestRunLeavesFileStderr(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmd := helper("utf8")
+	cmd.Stderr = f
+	err = errorxexec.Run(cmd, errorxexec.Options{})
+	if info := commandInfo(t, err); cmd.Stderr != f || info.Stderr != "" {
+		t.Errorf("cmd.Stderr = %T, CommandInfo.Stderr = %q, want the file untouched", cmd.Stderr, info.Stderr)
+	}
+	if data, _ := os.ReadFile(f.Name()); string(data) != "fatal: wörld" {
+		t.Errorf("file received %q", data)
+	}
+}
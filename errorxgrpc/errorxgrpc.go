@@ -0,0 +1,378 @@
+// Package errorxgrpc converts errorx errors to and from gRPC statuses.
+//
+// A Converter maps an error chain to a status code through registered
+// errors.Is / errors.As rules and attaches google.rpc error details:
+// ErrorInfo (type, prefix and scalar metadata), optionally DebugInfo (the
+// stack in Go runtime format), RetryInfo for errors that advertise a retry
+// delay, and BadRequest for *errorx.ValidationError and other errors that
+// describe invalid fields. FromStatus performs the reverse conversion on the
+// client side.
+//
+// The package lives in its own module so that the errorx module itself has
+// no gRPC dependency.
+package errorxgrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/neumachen/errorx"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Metadata keys used in ErrorInfo for the errorx fields, and by FromStatus
+// for the status fields. Keys starting with MetadataNamespace are reserved:
+// Status drops error metadata members that use them.
+const (
+	MetadataNamespace = "errorx."
+	MetadataType      = MetadataNamespace + "type"
+	MetadataPrefix    = MetadataNamespace + "prefix"
+	MetadataCode      = MetadataNamespace + "code"
+	MetadataReason    = MetadataNamespace + "reason"
+	MetadataDomain    = MetadataNamespace + "domain"
+)
+
+// RetryDelayer is implemented by errors that know when the failed operation
+// may be retried. Converter adds a RetryInfo detail for them.
+type RetryDelayer interface {
+	RetryDelay() time.Duration
+}
+
+// FieldViolation describes one invalid request field.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// FieldViolator is implemented by errors that describe invalid request
+// fields. Converter adds a BadRequest detail for them.
+type FieldViolator interface {
+	FieldViolations() []FieldViolation
+}
+
+// Options configures a Converter.
+type Options struct {
+	// Domain is the ErrorInfo domain, typically the service name.
+	Domain string
+	// IncludeDebugInfo attaches a DebugInfo detail with the error's stack
+	// frames. Stacks disclose file paths and function names; only enable
+	// it for trusted clients.
+	IncludeDebugInfo bool
+}
+
+type rule struct {
+	match  func(error) bool
+	code   codes.Code
+	reason string
+}
+
+// Converter maps errors to gRPC statuses. Rules are evaluated in
+// registration order and the first match wins. A Converter is safe for
+// concurrent use, including registration.
+type Converter struct {
+	opts Options
+
+	mu    sync.RWMutex
+	rules []rule
+}
+
+// NewConverter returns a Converter with no rules.
+func NewConverter(opts Options) *Converter {
+	return &Converter{opts: opts}
+}
+
+// RegisterIs maps errors for which errors.Is(err, target) holds to code.
+// reason becomes the ErrorInfo reason; empty selects the code name in
+// UPPER_SNAKE_CASE.
+func (c *Converter) RegisterIs(target error, code codes.Code, reason string) {
+	c.register(rule{match: func(err error) bool { return errors.Is(err, target) }, code: code, reason: reason})
+}
+
+// RegisterAs maps errors whose chain contains a T, as found by errors.As,
+// to code.
+func RegisterAs[T error](c *Converter, code codes.Code, reason string) {
+	c.register(rule{match: func(err error) bool {
+		var target T
+		return errors.As(err, &target)
+	}, code: code, reason: reason})
+}
+
+func (c *Converter) register(r rule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = append(c.rules, r)
+}
+
+// Code returns the status code and ErrorInfo reason for err. Without a
+// matching rule, errors carrying a gRPC status keep its code, context
+// cancellation and deadlines map to Canceled and DeadlineExceeded,
+// *errorx.ValidationError to InvalidArgument, panics to Internal, and
+// everything else to Unknown.
+func (c *Converter) Code(err error) (codes.Code, string) {
+	if err == nil {
+		return codes.OK, ""
+	}
+	c.mu.RLock()
+	for _, r := range c.rules {
+		if r.match(err) {
+			c.mu.RUnlock()
+			if r.reason == "" {
+				return r.code, reasonFor(r.code)
+			}
+			return r.code, r.reason
+		}
+	}
+	c.mu.RUnlock()
+
+	var code codes.Code
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	var te *errorx.TraceError
+	var ve *errorx.ValidationError
+	switch {
+	case errors.As(err, &grpcErr):
+		code = grpcErr.GRPCStatus().Code()
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.As(err, &ve):
+		code = codes.InvalidArgument
+	case errors.As(err, &te) && te.Type() == "panic":
+		code = codes.Internal
+	default:
+		code = codes.Unknown
+	}
+	return code, reasonFor(code)
+}
+
+// Status converts err into a status with error details. A nil err yields
+// an OK status.
+func (c *Converter) Status(err error) *status.Status {
+	if err == nil {
+		return status.New(codes.OK, "")
+	}
+	var te *errorx.TraceError
+	if !errors.As(err, &te) {
+		// Plain status errors, e.g. from a downstream call, pass through.
+		if st, ok := status.FromError(err); ok {
+			return st
+		}
+	}
+	code, reason := c.Code(err)
+	st := status.New(code, err.Error())
+
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: c.opts.Domain, Metadata: map[string]string{}}
+	details := []protoadapt.MessageV1{info}
+	if te != nil {
+		addScalarMetadata(info.Metadata, te)
+		info.Metadata[MetadataType] = te.Type()
+		if p := te.Prefix(); p != "" {
+			info.Metadata[MetadataPrefix] = p
+		}
+		if c.opts.IncludeDebugInfo {
+			details = append(details, &errdetails.DebugInfo{
+				StackEntries: stackEntries(te.StackFrames()),
+				Detail:       err.Error(),
+			})
+		}
+	}
+	var rd RetryDelayer
+	if errors.As(err, &rd) {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(rd.RetryDelay())})
+	}
+	if violations := fieldViolations(err); len(violations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		details = append(details, br)
+	}
+	if withDetails, derr := st.WithDetails(details...); derr == nil {
+		st = withDetails
+	}
+	return st
+}
+
+// Err is shorthand for c.Status(err).Err(); it returns nil for nil.
+func (c *Converter) Err(err error) error {
+	if err == nil {
+		return nil
+	}
+	return c.Status(err).Err()
+}
+
+// UnaryServerInterceptor converts handler errors with Status and recovers
+// handler panics into Internal statuses via errorx.FromPanic. The panic's
+// stack is captured as program counters so that DebugInfo can list it.
+func (c *Converter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				resp, err = nil, c.Err(errorx.FromPanic(r, nil))
+			}
+		}()
+		resp, err = handler(ctx, req)
+		return resp, c.Err(err)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func (c *Converter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = c.Err(errorx.FromPanic(r, nil))
+			}
+		}()
+		return c.Err(handler(srv, ss))
+	}
+}
+
+// StatusError is the error returned by FromStatus. It unwraps to the
+// reconstructed *errorx.TraceError and still reports the original status
+// to status.FromError and status.Code.
+type StatusError struct {
+	te *errorx.TraceError
+	st *status.Status
+}
+
+// Error returns the status message.
+func (e *StatusError) Error() string { return e.te.Error() }
+
+// Unwrap returns the reconstructed *errorx.TraceError.
+func (e *StatusError) Unwrap() error { return e.te }
+
+// GRPCStatus returns the original status.
+func (e *StatusError) GRPCStatus() *status.Status { return e.st }
+
+// FromStatus rebuilds an error from a status produced by Converter.Status:
+// the message, Type and prefix come from ErrorInfo and the frames from
+// DebugInfo when present. Remaining ErrorInfo metadata becomes the error's
+// JSON metadata, next to the code, reason and domain under MetadataCode,
+// MetadataReason and MetadataDomain. It returns nil for an OK or nil status.
+func FromStatus(st *status.Status) error {
+	if st == nil || st.Code() == codes.OK {
+		return nil
+	}
+	rec := errorx.Record{Message: st.Message()}
+	md := map[string]string{}
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			for k, v := range d.GetMetadata() {
+				switch {
+				case k == MetadataType:
+					rec.Type = v
+				case k == MetadataPrefix:
+					rec.Prefix = v
+				case !strings.HasPrefix(k, MetadataNamespace):
+					md[k] = v
+				}
+			}
+			md[MetadataReason] = d.GetReason()
+			if d.GetDomain() != "" {
+				md[MetadataDomain] = d.GetDomain()
+			}
+		case *errdetails.DebugInfo:
+			rec.StackFrames = parseStackEntries(d.GetStackEntries())
+		}
+	}
+	md[MetadataCode] = st.Code().String()
+	if raw, err := json.Marshal(md); err == nil {
+		msg := json.RawMessage(raw)
+		rec.Metadata = &msg
+	}
+	return &StatusError{te: errorx.FromRecord(rec), st: st}
+}
+
+// fieldViolations returns the violations of the first FieldViolator or
+// *errorx.ValidationError in err's chain. Rejected values are not sent.
+func fieldViolations(err error) []FieldViolation {
+	var fv FieldViolator
+	if errors.As(err, &fv) {
+		return fv.FieldViolations()
+	}
+	var ve *errorx.ValidationError
+	if !errors.As(err, &ve) {
+		return nil
+	}
+	violations := ve.Violations()
+	out := make([]FieldViolation, len(violations))
+	for i, v := range violations {
+		out[i] = FieldViolation{Field: v.Field, Description: v.Message}
+	}
+	return out
+}
+
+// stackEntries renders frames in Go runtime format, one frame per entry.
+func stackEntries(frames []errorx.StackFrame) []string {
+	text := string(errorx.FormatGoroutineStack("", frames))
+	_, body, _ := strings.Cut(text, "[running]:\n")
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	entries := make([]string, 0, len(lines)/2)
+	for i := 0; i+1 < len(lines); i += 2 {
+		entries = append(entries, lines[i]+"\n"+lines[i+1])
+	}
+	return entries
+}
+
+// parseStackEntries parses entries written by stackEntries with
+// errorx.ParsePanic. Unparseable input yields no frames.
+func parseStackEntries(entries []string) []errorx.StackFrame {
+	if len(entries) == 0 {
+		return nil
+	}
+	text := "panic: \n\ngoroutine 1 [running]:\n" + strings.Join(entries, "\n") + "\n"
+	parsed, err := errorx.ParsePanic(text)
+	if err != nil {
+		return nil
+	}
+	return parsed.StackFrames()
+}
+
+// addScalarMetadata copies the string, number and boolean members of a JSON
+// object metadata value into m, except those with reserved keys.
+func addScalarMetadata(m map[string]string, te *errorx.TraceError) {
+	var obj map[string]any
+	if err := te.UnmarshalMetadata(&obj); err != nil {
+		return
+	}
+	for k, v := range obj {
+		if strings.HasPrefix(k, MetadataNamespace) {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			m[k] = v
+		case float64, bool:
+			m[k] = fmt.Sprint(v)
+		}
+	}
+}
+
+// reasonFor returns the code name in UPPER_SNAKE_CASE, e.g. NOT_FOUND.
+func reasonFor(code codes.Code) string {
+	var b strings.Builder
+	for i, r := range code.String() {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,280 @@
+package errorxgrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neumachen/errorx"
+	"github.com/neumachen/errorx/errorxgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var errNotFound = errors.New("not found")
+
+type quotaError struct{ delay time.Duration }
+
+func (e *quotaError) Error() string             { return "quota exceeded" }
+func (e *quotaError) RetryDelay() time.Duration { return e.delay }
+
+type invalidError struct{}
+
+func (invalidError) Error() string { return "invalid request" }
+func (invalidError) FieldViolations() []errorxgrpc.FieldViolation {
+	return []errorxgrpc.FieldViolation{{Field: "service", Description: "must not be empty"}}
+}
+
+// healthServer fails in a way selected by the requested service name.
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+}
+
+func (healthServer) fail(service string) error {
+	switch service {
+	case "missing":
+		return errorx.WrapPrefix(errNotFound, "lookup", 0)
+	case "quota":
+		return errorx.NewError(&quotaError{delay: 3 * time.Second})
+	case "invalid":
+		return errorx.NewError(invalidError{})
+	case "validate":
+		v := errorx.NewValidator()
+		v.Field("service").OneOf(service, "users", "orders")
+		return v.Err()
+	case "panic":
+		panic("handler exploded")
+	}
+	return nil
+}
+
+func (h healthServer) Check(_ context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if err := h.fail(req.GetService()); err != nil {
+		return nil, err
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (h healthServer) Watch(req *grpc_health_v1.HealthCheckRequest, _ grpc_health_v1.Health_WatchServer) error {
+	return h.fail(req.GetService())
+}
+
+func newClient(t *testing.T, conv *errorxgrpc.Converter) grpc_health_v1.HealthClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(conv.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(conv.StreamServerInterceptor()),
+	)
+	grpc_health_v1.RegisterHealthServer(srv, healthServer{})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+func newConverter() *errorxgrpc.Converter {
+	conv := errorxgrpc.NewConverter(errorxgrpc.Options{Domain: "health.test", IncludeDebugInfo: true})
+	conv.RegisterIs(errNotFound, codes.NotFound, "")
+	errorxgrpc.RegisterAs[*quotaError](conv, codes.ResourceExhausted, "QUOTA")
+	errorxgrpc.RegisterAs[invalidError](conv, codes.InvalidArgument, "")
+	return conv
+}
+
+func check(t *testing.T, client grpc_health_v1.HealthClient, service string) *status.Status {
+	t.Helper()
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+	if err == nil {
+		t.Fatalf("Check(%q) succeeded", service)
+	}
+	return status.Convert(err)
+}
+
+func TestUnaryRulesAndDetails(t *testing.T) {
+	client := newClient(t, newConverter())
+
+	st := check(t, client, "missing")
+	if st.Code() != codes.NotFound || st.Message() != "lookup: not found" {
+		t.Fatalf("status = %v", st)
+	}
+	var info *errdetails.ErrorInfo
+	var dbg *errdetails.DebugInfo
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.DebugInfo:
+			dbg = d
+		}
+	}
+	if info == nil || info.GetReason() != "NOT_FOUND" || info.GetDomain() != "health.test" ||
+		info.GetMetadata()[errorxgrpc.MetadataPrefix] != "lookup" {
+		t.Errorf("ErrorInfo = %v", info)
+	}
+	if dbg == nil || len(dbg.GetStackEntries()) == 0 || !strings.Contains(strings.Join(dbg.GetStackEntries(), "\n"), "healthServer.fail") {
+		t.Errorf("DebugInfo = %v", dbg)
+	}
+
+	st = check(t, client, "quota")
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if r, ok := d.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	if st.Code() != codes.ResourceExhausted || retry == nil || retry.GetRetryDelay().AsDuration() != 3*time.Second {
+		t.Errorf("quota status = %v, retry = %v", st, retry)
+	}
+
+	st = check(t, client, "invalid")
+	var br *errdetails.BadRequest
+	for _, d := range st.Details() {
+		if b, ok := d.(*errdetails.BadRequest); ok {
+			br = b
+		}
+	}
+	if st.Code() != codes.InvalidArgument || br == nil || br.GetFieldViolations()[0].GetField() != "service" {
+		t.Errorf("invalid status = %v, bad request = %v", st, br)
+	}
+}
+
+func TestValidationErrorBadRequest(t *testing.T) {
+	client := newClient(t, errorxgrpc.NewConverter(errorxgrpc.Options{}))
+	st := check(t, client, "validate")
+	var br *errdetails.BadRequest
+	for _, d := range st.Details() {
+		if b, ok := d.(*errdetails.BadRequest); ok {
+			br = b
+		}
+	}
+	if st.Code() != codes.InvalidArgument || br == nil || len(br.GetFieldViolations()) != 1 {
+		t.Fatalf("status = %v, bad request = %v", st, br)
+	}
+	if fv := br.GetFieldViolations()[0]; fv.GetField() != "service" || fv.GetDescription() != "must be one of users, orders" {
+		t.Errorf("field violation = %v", fv)
+	}
+}
+
+func TestUnaryRecoversPanics(t *testing.T) {
+	client := newClient(t, newConverter())
+	st := check(t, client, "panic")
+	if st.Code() != codes.Internal || st.Message() != "handler exploded" {
+		t.Errorf("status = %v", st)
+	}
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	client := newClient(t, newConverter())
+	for service, want := range map[string]codes.Code{"missing": codes.NotFound, "panic": codes.Internal} {
+		stream, err := client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Watch: %v", err)
+		}
+		_, err = stream.Recv()
+		if got := status.Code(err); got != want {
+			t.Errorf("Watch(%q) code = %v, want %v (%v)", service, got, want, err)
+		}
+	}
+}
+
+func TestFromStatusRoundTrip(t *testing.T) {
+	client := newClient(t, newConverter())
+	st := check(t, client, "missing")
+
+	err := errorxgrpc.FromStatus(st)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("status.Code(FromStatus) = %v", status.Code(err))
+	}
+	var te *errorx.TraceError
+	if !errors.As(err, &te) {
+		t.Fatalf("FromStatus result has no *TraceError: %T", err)
+	}
+	if te.Error() != "lookup: not found" || te.Prefix() != "lookup" || te.Type() != "*errors.errorString" {
+		t.Errorf("rebuilt error = %q prefix=%q type=%q", te.Error(), te.Prefix(), te.Type())
+	}
+	frames := te.StackFrames()
+	if len(frames) == 0 || frames[0].Name == "" {
+		t.Fatalf("rebuilt frames = %v", frames)
+	}
+	var found bool
+	for _, f := range frames {
+		if strings.HasSuffix(f.Name, "healthServer.fail") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("rebuilt frames lack the failing function: %v", frames)
+	}
+	var md map[string]string
+	if err := te.UnmarshalMetadata(&md); err != nil || md[errorxgrpc.MetadataReason] != "NOT_FOUND" || md[errorxgrpc.MetadataCode] != "NotFound" || md[errorxgrpc.MetadataDomain] != "health.test" {
+		t.Errorf("metadata = %v (%v)", md, err)
+	}
+
+	if errorxgrpc.FromStatus(status.New(codes.OK, "")) != nil {
+		t.Errorf("FromStatus(OK) is not nil")
+	}
+}
+
+func TestMetadataKeysDoNotCollide(t *testing.T) {
+	te := errorx.WrapPrefix(errNotFound, "lookup", 0).(*errorx.TraceError)
+	md := json.RawMessage(`{"errorx.type":"forged","errorx.prefix":"forged","errorx.code":"OK","reason":"user reason","code":7}`)
+	if err := te.SetMetadata(&md); err != nil {
+		t.Fatal(err)
+	}
+	st := newConverter().Status(te)
+	info := st.Details()[0].(*errdetails.ErrorInfo)
+	want := map[string]string{
+		errorxgrpc.MetadataType:   te.Type(),
+		errorxgrpc.MetadataPrefix: "lookup",
+		"reason":                  "user reason",
+		"code":                    "7",
+	}
+	if !maps.Equal(info.GetMetadata(), want) {
+		t.Errorf("ErrorInfo metadata = %v, want %v", info.GetMetadata(), want)
+	}
+
+	var rebuilt *errorx.TraceError
+	if !errors.As(errorxgrpc.FromStatus(st), &rebuilt) {
+		t.Fatal("FromStatus result has no *TraceError")
+	}
+	var out map[string]string
+	if err := rebuilt.UnmarshalMetadata(&out); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{
+		"reason":                  "user reason",
+		"code":                    "7",
+		errorxgrpc.MetadataReason: "NOT_FOUND",
+		errorxgrpc.MetadataCode:   "NotFound",
+		errorxgrpc.MetadataDomain: "health.test",
+	}
+	if !maps.Equal(out, want) || rebuilt.Type() != te.Type() || rebuilt.Prefix() != "lookup" {
+		t.Errorf("FromStatus metadata = %v type=%q prefix=%q", out, rebuilt.Type(), rebuilt.Prefix())
+	}
+}
+
+func TestStatusPassesThroughPlainStatusErrors(t *testing.T) {
+	conv := newConverter()
+	in := status.Error(codes.Unavailable, "downstream")
+	if st := conv.Status(in); st.Code() != codes.Unavailable || st.Message() != "downstream" {
+		t.Errorf("status = %v", st)
+	}
+}
@@ -0,0 +1,523 @@
+// Package errorxsql wraps database/sql drivers so that the errors they
+// return are *errorx.TraceError values carrying the query context.
+//
+// Every error from a wrapped driver gets a QueryInfo detail, retrievable
+// with errorx.Detail[errorxsql.QueryInfo], holding the operation, a
+// fingerprint of the query with its literals stripped, how long the
+// operation took and whether the failure was at the connection level rather
+// than in the query. The driver's error stays in the chain, so errors.Is
+// and errors.As keep matching it, and database/sql's own errors such as
+// sql.ErrNoRows are returned unchanged:
+//
+//	db := sql.OpenDB(errorxsql.WrapConnector(connector, errorxsql.Options{}))
+//	if _, err := db.ExecContext(ctx, query, id); err != nil {
+//	    if errorxsql.IsConnError(err) {
+//	        ...
+//	    }
+//	}
+package errorxsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/neumachen/errorx"
+)
+
+// Op names a driver operation.
+type Op string
+
+// Operations recorded in QueryInfo.
+const (
+	OpConnect  Op = "Connect"
+	OpPing     Op = "Ping"
+	OpPrepare  Op = "Prepare"
+	OpBegin    Op = "Begin"
+	OpCommit   Op = "Commit"
+	OpRollback Op = "Rollback"
+	OpExec     Op = "Exec"
+	OpQuery    Op = "Query"
+	// OpNext is the iteration of the rows returned by a query.
+	OpNext  Op = "Next"
+	OpClose Op = "Close"
+)
+
+// QueryInfo is the detail attached to every error returned by a wrapped
+// driver.
+type QueryInfo struct {
+	Op Op `json:"op"`
+	// Query is the Fingerprint of the statement, empty for operations that
+	// have none.
+	Query    string        `json:"query,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// ConnError reports a failure of the connection rather than of the
+	// statement: every Connect error, and errors classified by
+	// Options.ConnError.
+	ConnError bool `json:"conn_error,omitempty"`
+}
+
+// IsConnError reports whether err came from a wrapped driver and was a
+// connection-level failure.
+func IsConnError(err error) bool {
+	info, ok := errorx.Detail[QueryInfo](err)
+	return ok && info.ConnError
+}
+
+// Options configures a wrapped driver. The zero value of every field
+// selects a sensible default.
+type Options struct {
+	// ConnError reports whether a driver error is a connection-level
+	// failure. Default DefaultConnError.
+	ConnError func(error) bool
+	// Clock is the time source for durations. Default errorx.SystemClock.
+	Clock errorx.Clock
+}
+
+// DefaultConnError reports driver.ErrBadConn and net.Error failures as
+// connection-level.
+func DefaultConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+func (o Options) withDefaults() Options {
+	if o.ConnError == nil {
+		o.ConnError = DefaultConnError
+	}
+	if o.Clock == nil {
+		o.Clock = errorx.SystemClock
+	}
+	return o
+}
+
+// packagePath is the import path of this package.
+var packagePath = reflect.TypeOf(Options{}).PkgPath()
+
+// wrap returns err as a *TraceError carrying op, the fingerprint of query
+// and the time elapsed since start, whose stack starts at the application's
+// call into database/sql. Sentinel errors that database/sql compares by
+// identity, and nil, are returned unchanged.
+func (o *Options) wrap(err error, op Op, query string, start time.Time) error {
+	if err == nil || err == driver.ErrSkip || err == driver.ErrRemoveArgument || err == io.EOF {
+		return err
+	}
+	info := QueryInfo{
+		Op:        op,
+		Query:     Fingerprint(query),
+		Duration:  o.Clock.Now().Sub(start),
+		ConnError: op == OpConnect || o.ConnError(err),
+	}
+	return errorx.Wrap(errorx.WithDetail(err, info), callerSkip())
+}
+
+// callerSkip returns the skip that makes errorx.Wrap, called from wrap,
+// leave out the frames of wrap, the rest of this package and database/sql,
+// so that errors from different call sites get different origins. It
+// returns 0, keeping every frame, when there is no other frame, as for
+// connections opened by database/sql's own goroutines.
+func callerSkip() int {
+	var pcs [64]uintptr
+	// Skip runtime.Callers and callerSkip, so that the first frame is wrap.
+	n := runtime.Callers(2, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for skip := 1; ; skip++ {
+		f, more := frames.Next()
+		if !internalFrame(f.Function) {
+			return skip
+		}
+		if !more {
+			return 0
+		}
+	}
+}
+
+// internalFrame reports whether the function named fn belongs to this
+// package or to database/sql.
+func internalFrame(fn string) bool {
+	return strings.HasPrefix(fn, packagePath+".") || strings.HasPrefix(fn, "database/sql.") ||
+		strings.HasPrefix(fn, "database/sql/driver.")
+}
+
+// WrapDriver returns a driver.Driver whose connections return enriched
+// errors. The result also implements driver.DriverContext.
+func WrapDriver(d driver.Driver, opts Options) driver.Driver {
+	return &wrappedDriver{d: d, opts: opts.withDefaults()}
+}
+
+// WrapConnector returns a driver.Connector whose connections return
+// enriched errors, for use with sql.OpenDB.
+func WrapConnector(c driver.Connector, opts Options) driver.Connector {
+	opts = opts.withDefaults()
+	return &connector{c: c, d: &wrappedDriver{d: c.Driver(), opts: opts}, opts: &opts}
+}
+
+type wrappedDriver struct {
+	d    driver.Driver
+	opts Options
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	start := d.opts.Clock.Now()
+	c, err := d.d.Open(name)
+	if err != nil {
+		return nil, d.opts.wrap(err, OpConnect, "", start)
+	}
+	return &conn{c: c, opts: &d.opts}, nil
+}
+
+func (d *wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.d.(driver.DriverContext); ok {
+		start := d.opts.Clock.Now()
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, d.opts.wrap(err, OpConnect, "", start)
+		}
+		return &connector{c: c, d: d, opts: &d.opts}, nil
+	}
+	return &connector{c: dsnConnector{name: name, d: d.d}, d: d, opts: &d.opts}, nil
+}
+
+// dsnConnector is the connector of a driver without driver.DriverContext.
+type dsnConnector struct {
+	name string
+	d    driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open(c.name) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.d }
+
+type connector struct {
+	c    driver.Connector
+	d    *wrappedDriver
+	opts *Options
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	start := c.opts.Clock.Now()
+	dc, err := c.c.Connect(ctx)
+	if err != nil {
+		return nil, c.opts.wrap(err, OpConnect, "", start)
+	}
+	return &conn{c: dc, opts: c.opts}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return c.d }
+
+// Close closes the wrapped connector if it implements io.Closer.
+func (c *connector) Close() error {
+	if cl, ok := c.c.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// conn implements every optional connection interface, falling back to
+// what database/sql does when the wrapped connection lacks one.
+type conn struct {
+	c    driver.Conn
+	opts *Options
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	start := c.opts.Clock.Now()
+	var s driver.Stmt
+	var err error
+	if pc, ok := c.c.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.c.Prepare(query)
+	}
+	if err != nil {
+		return nil, c.opts.wrap(err, OpPrepare, query, start)
+	}
+	return &stmt{s: s, conn: c.c, query: query, opts: c.opts}, nil
+}
+
+func (c *conn) Close() error {
+	start := c.opts.Clock.Now()
+	return c.opts.wrap(c.c.Close(), OpClose, "", start)
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := c.opts.Clock.Now()
+	var t driver.Tx
+	var err error
+	if bc, ok := c.c.(driver.ConnBeginTx); ok {
+		t, err = bc.BeginTx(ctx, opts)
+	} else if opts.Isolation != driver.IsolationLevel(0) {
+		err = errors.New("sql: driver does not support non-default isolation level")
+	} else if opts.ReadOnly {
+		err = errors.New("sql: driver does not support read-only transactions")
+	} else {
+		t, err = c.c.Begin()
+	}
+	if err != nil {
+		return nil, c.opts.wrap(err, OpBegin, "", start)
+	}
+	return &tx{t: t, opts: c.opts}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := c.opts.Clock.Now()
+	var res driver.Result
+	var err error
+	switch x := c.c.(type) {
+	case driver.ExecerContext:
+		res, err = x.ExecContext(ctx, query, args)
+	case driver.Execer:
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			res, err = x.Exec(query, values)
+		}
+	default:
+		return nil, driver.ErrSkip
+	}
+	if err != nil {
+		return nil, c.opts.wrap(err, OpExec, query, start)
+	}
+	return res, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := c.opts.Clock.Now()
+	var r driver.Rows
+	var err error
+	switch x := c.c.(type) {
+	case driver.QueryerContext:
+		r, err = x.QueryContext(ctx, query, args)
+	case driver.Queryer:
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			r, err = x.Query(query, values)
+		}
+	default:
+		return nil, driver.ErrSkip
+	}
+	if err != nil {
+		return nil, c.opts.wrap(err, OpQuery, query, start)
+	}
+	return &rows{r: r, query: query, opts: c.opts}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	p, ok := c.c.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	start := c.opts.Clock.Now()
+	return c.opts.wrap(p.Ping(ctx), OpPing, "", start)
+}
+
+// ResetSession is called by database/sql's pool rather than by users, so
+// its driver.ErrBadConn signal is passed on as is.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.c.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.c.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	return checkNamedValue(nil, c.c, nv)
+}
+
+// checkNamedValue defers to the statement's checker, then the
+// connection's, as database/sql does. driver.ErrSkip selects the default
+// conversion.
+func checkNamedValue(s driver.Stmt, c driver.Conn, nv *driver.NamedValue) error {
+	if ch, ok := s.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	if ch, ok := c.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues converts args for the pre-context driver interfaces, which
+// do not support named parameters.
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+type stmt struct {
+	s     driver.Stmt
+	conn  driver.Conn
+	query string
+	opts  *Options
+}
+
+func (s *stmt) Close() error {
+	start := s.opts.Clock.Now()
+	return s.opts.wrap(s.s.Close(), OpClose, s.query, start)
+}
+
+func (s *stmt) NumInput() int { return s.s.NumInput() }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := s.opts.Clock.Now()
+	res, err := s.s.Exec(args)
+	return res, s.opts.wrap(err, OpExec, s.query, start)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := s.opts.Clock.Now()
+	r, err := s.s.Query(args)
+	if err != nil {
+		return nil, s.opts.wrap(err, OpQuery, s.query, start)
+	}
+	return &rows{r: r, query: s.query, opts: s.opts}, nil
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := s.opts.Clock.Now()
+	var res driver.Result
+	var err error
+	if sc, ok := s.s.(driver.StmtExecContext); ok {
+		res, err = sc.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			res, err = s.s.Exec(values)
+		}
+	}
+	return res, s.opts.wrap(err, OpExec, s.query, start)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := s.opts.Clock.Now()
+	var r driver.Rows
+	var err error
+	if sc, ok := s.s.(driver.StmtQueryContext); ok {
+		r, err = sc.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			r, err = s.s.Query(values)
+		}
+	}
+	if err != nil {
+		return nil, s.opts.wrap(err, OpQuery, s.query, start)
+	}
+	return &rows{r: r, query: s.query, opts: s.opts}, nil
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	return checkNamedValue(s.s, s.conn, nv)
+}
+
+type tx struct {
+	t    driver.Tx
+	opts *Options
+}
+
+func (t *tx) Commit() error {
+	start := t.opts.Clock.Now()
+	return t.opts.wrap(t.t.Commit(), OpCommit, "", start)
+}
+
+func (t *tx) Rollback() error {
+	start := t.opts.Clock.Now()
+	return t.opts.wrap(t.t.Rollback(), OpRollback, "", start)
+}
+
+// rows implements every optional rows interface with the defaults
+// database/sql uses when the wrapped rows lack one.
+type rows struct {
+	r     driver.Rows
+	query string
+	opts  *Options
+}
+
+func (r *rows) Columns() []string { return r.r.Columns() }
+
+func (r *rows) Close() error {
+	start := r.opts.Clock.Now()
+	return r.opts.wrap(r.r.Close(), OpClose, r.query, start)
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	start := r.opts.Clock.Now()
+	return r.opts.wrap(r.r.Next(dest), OpNext, r.query, start)
+}
+
+func (r *rows) HasNextResultSet() bool {
+	n, ok := r.r.(driver.RowsNextResultSet)
+	return ok && n.HasNextResultSet()
+}
+
+func (r *rows) NextResultSet() error {
+	n, ok := r.r.(driver.RowsNextResultSet)
+	if !ok {
+		return io.EOF
+	}
+	start := r.opts.Clock.Now()
+	return r.opts.wrap(n.NextResultSet(), OpNext, r.query, start)
+}
+
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	if c, ok := r.r.(driver.RowsColumnTypeScanType); ok {
+		return c.ColumnTypeScanType(index)
+	}
+	return reflect.TypeFor[any]()
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	if c, ok := r.r.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return c.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *rows) ColumnTypeLength(index int) (int64, bool) {
+	if c, ok := r.r.(driver.RowsColumnTypeLength); ok {
+		return c.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if c, isNullable := r.r.(driver.RowsColumnTypeNullable); isNullable {
+		return c.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *rows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if c, hasPrecision := r.r.(driver.RowsColumnTypePrecisionScale); hasPrecision {
+		return c.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
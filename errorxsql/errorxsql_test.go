@@ -0,0 +1,295 @@
+package errorxsql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neumachen/errorx"
+	"github.com/neumachen/errorx/errorxsql"
+)
+
+var (
+	errDuplicate = errors.New("duplicate key")
+	errCursor    = errors.New("cursor lost")
+	errConflict  = errors.New("serialization failure")
+)
+
+// fakeDriver is an in-memory driver.Driver with a users table mapping ids
+// to names. INSERT statements always fail with errDuplicate, the table
+// "broken" fails with driver.ErrBadConn and "SELECT id FROM users" loses
+// its cursor after the first row.
+type fakeDriver struct {
+	users      map[int64]string
+	dialErr    error
+	failCommit bool
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) {
+	if d.dialErr != nil {
+		return nil, d.dialErr
+	}
+	return &fakeConn{d: d}, nil
+}
+
+type fakeConnector struct{ d *fakeDriver }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open("") }
+func (c fakeConnector) Driver() driver.Driver                        { return c.d }
+
+// fakeConn implements the context interfaces; its statements only the
+// required ones, so that both paths of the wrapper are exercised.
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c: c, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{c.d}, nil }
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{c.d}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if strings.HasPrefix(query, "INSERT") {
+		return nil, errDuplicate
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case strings.Contains(query, "broken"):
+		return nil, driver.ErrBadConn
+	case query == "SELECT id FROM users":
+		return &fakeRows{column: "id", values: []driver.Value{int64(1), int64(2)}, failAt: 1}, nil
+	}
+	rows := &fakeRows{column: "name", failAt: -1}
+	if name, ok := c.d.users[args[0].Value.(int64)]; ok {
+		rows.values = []driver.Value{name}
+	}
+	return rows, nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, nil)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return s.c.QueryContext(context.Background(), s.query, named)
+}
+
+type fakeTx struct{ d *fakeDriver }
+
+func (t fakeTx) Commit() error {
+	if t.d.failCommit {
+		return errConflict
+	}
+	return nil
+}
+func (t fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	column string
+	values []driver.Value
+	failAt int
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return []string{r.column} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == r.failAt {
+		return errCursor
+	}
+	if r.next == len(r.values) {
+		return io.EOF
+	}
+	dest[0] = r.values[r.next]
+	r.next++
+	return nil
+}
+
+// stepClock advances by 5ms every time it is read.
+type stepClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(5 * time.Millisecond)
+	return c.now
+}
+
+func (c *stepClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func openDB(t *testing.T, d *fakeDriver) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(errorxsql.WrapConnector(fakeConnector{d}, errorxsql.Options{Clock: &stepClock{}}))
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func queryInfo(t *testing.T, err error) errorxsql.QueryInfo {
+	t.Helper()
+	var te *errorx.TraceError
+	if !errors.As(err, &te) {
+		t.Fatalf("%v (%T) is not a *TraceError", err, err)
+	}
+	info, ok := errorx.Detail[errorxsql.QueryInfo](err)
+	if !ok {
+		t.Fatalf("%v carries no QueryInfo", err)
+	}
+	return info
+}
+
+func TestExecError(t *testing.T) {
+	db := openDB(t, &fakeDriver{})
+	_, err := db.Exec("INSERT INTO users (id, name) VALUES (1, 'ann')")
+	if !errors.Is(err, errDuplicate) || err.Error() != "duplicate key" {
+		t.Fatalf("Exec = %v", err)
+	}
+	want := errorxsql.QueryInfo{Op: errorxsql.OpExec, Query: "INSERT INTO users (id, name) VALUES (?, ?)", Duration: 5 * time.Millisecond}
+	if info := queryInfo(t, err); info != want {
+		t.Errorf("QueryInfo = %+v, want %+v", info, want)
+	}
+	if errorxsql.IsConnError(err) {
+		t.Error("a query error was reported as a connection error")
+	}
+	details, jerr := json.Marshal(err.(*errorx.TraceError).Record().Details)
+	if jerr != nil || string(details) != `{"errorxsql.QueryInfo":{"op":"Exec","query":"INSERT INTO users (id, name) VALUES (?, ?)","duration":5000000}}` {
+		t.Errorf("Record().Details = %s, %v", details, jerr)
+	}
+
+	stmt, perr := db.Prepare("INSERT INTO users (name) VALUES ($1)")
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec("bo"); !errors.Is(err, errDuplicate) || queryInfo(t, err).Query != "INSERT INTO users (name) VALUES ($1)" {
+		t.Errorf("prepared Exec = %v", err)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	db := openDB(t, &fakeDriver{users: map[int64]string{1: "ann"}})
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM users WHERE id = ?", int64(1)).Scan(&name); err != nil || name != "ann" {
+		t.Fatalf("QueryRow = %q, %v", name, err)
+	}
+	if err := db.QueryRow("SELECT name FROM users WHERE id = ?", int64(2)).Scan(&name); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("QueryRow of a missing user = %v, want sql.ErrNoRows", err)
+	}
+
+	rows, err := db.Query("SELECT id FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Err(); !errors.Is(err, errCursor) || queryInfo(t, err).Op != errorxsql.OpNext {
+		t.Errorf("rows.Err() = %v", err)
+	}
+
+	_, err = db.Query("SELECT * FROM broken WHERE note = 'x'")
+	if !errors.Is(err, driver.ErrBadConn) || !errorxsql.IsConnError(err) {
+		t.Fatalf("Query of a broken connection = %v", err)
+	}
+	if info := queryInfo(t, err); info.Op != errorxsql.OpQuery || info.Query != "SELECT * FROM broken WHERE note = ?" {
+		t.Errorf("QueryInfo = %+v", info)
+	}
+}
+
+func TestTxErrors(t *testing.T) {
+	db := openDB(t, &fakeDriver{failCommit: true})
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.Is(err, errConflict) || queryInfo(t, err).Op != errorxsql.OpCommit {
+		t.Errorf("Commit = %v", err)
+	}
+	if _, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true}); err != nil {
+		t.Errorf("BeginTx = %v", err)
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	db := openDB(t, &fakeDriver{dialErr: dialErr})
+	err := db.Ping()
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || !errorxsql.IsConnError(err) || queryInfo(t, err).Op != errorxsql.OpConnect {
+		t.Errorf("Ping = %v", err)
+	}
+}
+
+func TestWrapDriver(t *testing.T) {
+	// Open through OpenConnector rather than sql.Register, which panics when
+	// the test runs more than once.
+	d := errorxsql.WrapDriver(&fakeDriver{}, errorxsql.Options{
+		ConnError: func(err error) bool { return errors.Is(err, errDuplicate) },
+	})
+	c, err := d.(driver.DriverContext).OpenConnector("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO users VALUES (1)")
+	if !errors.Is(err, errDuplicate) || !errorxsql.IsConnError(err) {
+		t.Errorf("Exec = %v, want a connection error by Options.ConnError", err)
+	}
+	if errorxsql.IsConnError(errDuplicate) {
+		t.Error("IsConnError matched an unwrapped error")
+	}
+}
+
+func insertUser(db *sql.DB) error {
+	_, err := db.Exec("INSERT INTO users (id) VALUES (1)")
+	return err
+}
+
+func insertOrder(db *sql.DB) error {
+	_, err := db.Exec("INSERT INTO users (id) VALUES (1)")
+	return err
+}
+
+func TestErrorOrigin(t *testing.T) {
+	db := openDB(t, &fakeDriver{})
+	userErr, orderErr := insertUser(db), insertOrder(db)
+	for name, err := range map[string]error{"insertUser": userErr, "insertOrder": orderErr} {
+		var te *errorx.TraceError
+		if !errors.As(err, &te) || te.StackFrames()[0].Name != name {
+			t.Errorf("%s: first frame = %+v, want the call site", name, te.StackFrames()[0])
+		}
+	}
+	if errorx.GroupingKey(userErr) == errorx.GroupingKey(orderErr) || errorx.OriginKey(userErr) == errorx.OriginKey(orderErr) {
+		t.Errorf("errors from two call sites share a key: %s, %s", errorx.GroupingKey(userErr), errorx.OriginKey(userErr))
+	}
+}
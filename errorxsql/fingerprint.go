@@ -0,0 +1,168 @@
+package errorxsql
+
+import (
+	"strings"
+)
+
+// Fingerprint returns query with its literals replaced so that it
+// identifies the statement without the data it carried: string and number
+// literals, including PostgreSQL $$...$$ and $tag$...$tag$ strings, become
+// "?", comments are removed and runs of whitespace are collapsed to one
+// space. Quoted identifiers and placeholders such as "?", "$1", ":name" and
+// "@p1" are kept.
+//
+// A "#" starts a comment, as in MySQL, unless it begins one of the
+// PostgreSQL JSON operators "#>", "#>>" and "#-". The PostgreSQL "#"
+// bitwise XOR operator is therefore read as a comment too, which drops the
+// rest of its line.
+//
+//	Fingerprint("SELECT * FROM users WHERE name = 'ann' AND age > 30 -- adults")
+//	// SELECT * FROM users WHERE name = ? AND age > ?
+func Fingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false
+	emit := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			space = true
+			i += end
+		case c == '#' && !strings.HasPrefix(query[i+1:], ">") && !strings.HasPrefix(query[i+1:], "-"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			space = true
+			i += end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i - 4
+			}
+			space = true
+			i += end + 4
+		case c == '\'':
+			emit("?")
+			i = skipQuoted(query, i, '\'', false)
+		case c == '"' || c == '`':
+			end := skipQuoted(query, i, c, false)
+			emit(query[i:end])
+			i = end
+		case isDigit(c) || c == '.' && i+1 < len(query) && isDigit(query[i+1]):
+			emit("?")
+			i = skipNumber(query, i)
+		case isWordStart(c):
+			end := i + 1
+			for end < len(query) && isWordPart(query[end]) {
+				end++
+			}
+			// E'...', N'...', X'...' and B'...' are string literals with
+			// a prefix; only in E'...' does a backslash escape a quote.
+			if end == i+1 && end < len(query) && query[end] == '\'' && strings.IndexByte("eEnNxXbB", c) >= 0 {
+				emit("?")
+				i = skipQuoted(query, end, '\'', c == 'e' || c == 'E')
+				continue
+			}
+			emit(query[i:end])
+			i = end
+		case c == '$' && dollarTag(query, i) != "":
+			tag := dollarTag(query, i)
+			emit("?")
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += 2*len(tag) + end
+			}
+		case c == '$' || c == ':' || c == '@':
+			// Placeholders keep their number or name.
+			end := i + 1
+			for end < len(query) && isWordPart(query[end]) {
+				end++
+			}
+			emit(query[i:end])
+			i = end
+		default:
+			emit(query[i : i+1])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// skipQuoted returns the index just past the quoted section starting at
+// query[start], where a doubled quote is an escaped one and, if backslash
+// is set, a backslash escapes the byte after it.
+func skipQuoted(query string, start int, quote byte, backslash bool) int {
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// dollarTag returns the delimiter of the dollar-quoted string starting at
+// query[start], "$$" or "$tag$", or "" if there is none there.
+func dollarTag(query string, start int) string {
+	i := start + 1
+	if i < len(query) && isWordStart(query[i]) {
+		for i < len(query) && isWordPart(query[i]) && query[i] != '$' {
+			i++
+		}
+	}
+	if i < len(query) && query[i] == '$' {
+		return query[start : i+1]
+	}
+	return ""
+}
+
+// skipNumber returns the index just past the number starting at
+// query[start], including hexadecimal digits and exponents.
+func skipNumber(query string, start int) int {
+	i := start
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case isWordPart(c) || c == '.':
+			i++
+		case (c == '+' || c == '-') && (query[i-1] == 'e' || query[i-1] == 'E'):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isWordStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isWordPart(c byte) bool { return isWordStart(c) || isDigit(c) || c == '$' }
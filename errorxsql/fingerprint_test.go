@@ -0,0 +1,30 @@
+package errorxsql_test
+
+import (
+	"testing"
+
+	"github.com/neumachen/errorx/errorxsql"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM users WHERE name = 'ann' AND age > 30 -- adults", "SELECT * FROM users WHERE name = ? AND age > ?"},
+		{"SELECT  a,\n\tb FROM t /* hint */ WHERE x = -1.5e+3", "SELECT a, b FROM t WHERE x = -?"},
+		{"INSERT INTO t2 (c1) VALUES ('it''s', E'a\\'b', X'ff', 0x1F)", "INSERT INTO t2 (c1) VALUES (?, ?, ?, ?)"},
+		{`SELECT "col 1", ` + "`k`" + ` FROM "T" WHERE id = $1 OR id = :id OR id = @p1 OR id = ?`, `SELECT "col 1", ` + "`k`" + ` FROM "T" WHERE id = $1 OR id = :id OR id = @p1 OR id = ?`},
+		{`SELECT * FROM f WHERE p = 'C:\' OR tok = 'hunter2'`, "SELECT * FROM f WHERE p = ? OR tok = ?"},
+		{"SELECT $$secret pw$$, $tag$hunter2 $x$ $tag$ FROM t WHERE id = $1", "SELECT ?, ? FROM t WHERE id = $1"},
+		{"SELECT $$unterminated hunter2", "SELECT ?"},
+		{"SELECT a # pw=hunter2\nFROM t WHERE doc #>> '{a}' = 'x' AND doc #- '{b}' IS NULL", "SELECT a FROM t WHERE doc #>> ? = ? AND doc #- ? IS NULL"},
+		{"SELECT 'unterminated", "SELECT ?"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := errorxsql.Fingerprint(tt.query); got != tt.want {
+			t.Errorf("Fingerprint(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package errorxtest provides test assertions for errors built with errorx:
+// the prefixes of a wrapper chain, the function an error originated in, its
+// metadata and codes, and golden-file comparison of %+v and JSON output with
+// machine-specific details normalized.
+//
+// Assertions report failures with t.Errorf, so a test continues after a
+// failed assertion, and return whether the assertion held.
+package errorxtest
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+// errorxPackage is the package path of the errorx constructors, whose
+// frames lead every captured stack.
+const errorxPackage = "github.com/neumachen/errorx"
+
+// AssertChain checks the non-empty prefixes of the *errorx.TraceError
+// layers reachable from err through errors.Unwrap, outermost first.
+//
+//	err := errorx.WrapPrefix(errorx.WrapPrefix(io.EOF, "read", 0), "load", 0)
+//	errorxtest.AssertChain(t, err, "load", "read")
+func AssertChain(t testing.TB, err error, prefixes ...string) bool {
+	t.Helper()
+	var got []string
+	for _, te := range layers(err) {
+		if p := te.Prefix(); p != "" {
+			got = append(got, p)
+		}
+	}
+	if !slices.Equal(got, prefixes) {
+		t.Errorf("errorxtest: prefix chain of %q = %q, want %q", errorText(err), got, prefixes)
+		return false
+	}
+	return true
+}
+
+// AssertOriginatedIn checks the function that created the innermost
+// *errorx.TraceError in err's chain: the first captured frame outside errorx
+// and the runtime. fn is the package-qualified function name; the package
+// may be given by its last path element only, e.g. "store.(*DB).Get" for
+// "example.com/app/store.(*DB).Get".
+func AssertOriginatedIn(t testing.TB, err error, fn string) bool {
+	t.Helper()
+	chain := layers(err)
+	if len(chain) == 0 {
+		t.Errorf("errorxtest: %q has no *errorx.TraceError in its chain", errorText(err))
+		return false
+	}
+	origin, ok := originFrame(chain[len(chain)-1].StackFrames())
+	if !ok {
+		t.Errorf("errorxtest: %q has no frames outside errorx", errorText(err))
+		return false
+	}
+	got := origin.FuncName()
+	if got != fn && !strings.HasSuffix(got, "/"+fn) {
+		t.Errorf("errorxtest: %q originated in %s (%s:%d), want %s", errorText(err), got, origin.File, origin.LineNumber, fn)
+		return false
+	}
+	return true
+}
+
+// AssertMetadata checks the metadata of the outermost layer in err's chain
+// that has any. want is compared as JSON, so a struct, a map or a
+// json.RawMessage holding the same document all match.
+func AssertMetadata(t testing.TB, err error, want any) bool {
+	t.Helper()
+	var md *json.RawMessage
+	for _, te := range layers(err) {
+		if md = te.Metadata(); md != nil {
+			break
+		}
+	}
+	if md == nil {
+		t.Errorf("errorxtest: %q has no metadata", errorText(err))
+		return false
+	}
+	wantJSON, merr := json.Marshal(want)
+	if merr != nil {
+		t.Errorf("errorxtest: AssertMetadata: marshal want: %v", merr)
+		return false
+	}
+	var gotValue, wantValue any
+	if err := json.Unmarshal(*md, &gotValue); err != nil {
+		t.Errorf("errorxtest: AssertMetadata: %v", err)
+		return false
+	}
+	if err := json.Unmarshal(wantJSON, &wantValue); err != nil {
+		t.Errorf("errorxtest: AssertMetadata: %v", err)
+		return false
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("errorxtest: metadata of %q = %s, want %s", errorText(err), *md, wantJSON)
+		return false
+	}
+	return true
+}
+
+// AssertCode checks the code reported by the first error in err's chain
+// that has a Code() C method, as found by errors.As.
+//
+//	errorxtest.AssertCode(t, err, http.StatusNotFound)
+func AssertCode[C comparable](t testing.TB, err error, want C) bool {
+	t.Helper()
+	var coder interface{ Code() C }
+	if !errors.As(err, &coder) {
+		var zero C
+		t.Errorf("errorxtest: %q has no error with a Code() %T method", errorText(err), zero)
+		return false
+	}
+	if got := coder.Code(); got != want {
+		t.Errorf("errorxtest: code of %q = %v, want %v", errorText(err), got, want)
+		return false
+	}
+	return true
+}
+
+// layers returns the *errorx.TraceError values reachable from err through
+// single errors.Unwrap calls, outermost first.
+func layers(err error) []*errorx.TraceError {
+	var out []*errorx.TraceError
+	for cur := err; cur != nil; cur = errors.Unwrap(cur) {
+		if te, ok := cur.(*errorx.TraceError); ok && te != nil {
+			out = append(out, te)
+		}
+	}
+	return out
+}
+
+// originFrame returns the first frame outside errorx and the runtime.
+func originFrame(frames []errorx.StackFrame) (errorx.StackFrame, bool) {
+	for _, f := range frames {
+		if f.Package == errorxPackage || f.Package == "runtime" {
+			continue
+		}
+		return f, true
+	}
+	return errorx.StackFrame{}, false
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
@@ -0,0 +1,149 @@
+package errorxtest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+	"github.com/neumachen/errorx/errorxtest"
+)
+
+// recorder captures assertion failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+type httpError struct{ status int }
+
+func (e *httpError) Error() string { return fmt.Sprintf("http %d", e.status) }
+func (e *httpError) Code() int     { return e.status }
+
+func loadConfig() error {
+	err := errorx.WrapPrefix(io.ErrUnexpectedEOF, "read", 0)
+	md := json.RawMessage(`{"path":"/etc/app.conf","attempt":2}`)
+	_ = err.(*errorx.TraceError).SetMetadata(&md)
+	return errorx.WrapPrefix(fmt.Errorf("parse: %w", err), "load config", 0)
+}
+
+func TestAssertChain(t *testing.T) {
+	err := loadConfig()
+	if !errorxtest.AssertChain(t, err, "load config", "read") {
+		return
+	}
+	r := &recorder{TB: t}
+	if errorxtest.AssertChain(r, err, "read") || len(r.failures) != 1 {
+		t.Fatalf("mismatched chain passed: %v", r.failures)
+	}
+	if !strings.Contains(r.failures[0], `["load config" "read"]`) {
+		t.Errorf("failure = %q", r.failures[0])
+	}
+	errorxtest.AssertChain(t, errors.New("plain"))
+}
+
+func TestAssertOriginatedIn(t *testing.T) {
+	err := loadConfig()
+	errorxtest.AssertOriginatedIn(t, err, "errorxtest_test.loadConfig")
+	errorxtest.AssertOriginatedIn(t, err, "github.com/neumachen/errorx/errorxtest_test.loadConfig")
+
+	r := &recorder{TB: t}
+	if errorxtest.AssertOriginatedIn(r, err, "errorxtest_test.TestAssertOriginatedIn") {
+		t.Error("wrong origin passed")
+	}
+	if errorxtest.AssertOriginatedIn(r, io.EOF, "io.EOF") {
+		t.Error("plain error passed")
+	}
+	if len(r.failures) != 2 || !strings.Contains(r.failures[0], "originated in github.com/neumachen/errorx/errorxtest_test.loadConfig") {
+		t.Errorf("failures = %q", r.failures)
+	}
+}
+
+func TestAssertMetadata(t *testing.T) {
+	err := loadConfig()
+	errorxtest.AssertMetadata(t, err, map[string]any{"path": "/etc/app.conf", "attempt": 2})
+	errorxtest.AssertMetadata(t, err, struct {
+		Attempt int    `json:"attempt"`
+		Path    string `json:"path"`
+	}{2, "/etc/app.conf"})
+	errorxtest.AssertMetadata(t, err, json.RawMessage(`{"attempt": 2, "path": "/etc/app.conf"}`))
+
+	r := &recorder{TB: t}
+	errorxtest.AssertMetadata(r, err, map[string]any{"attempt": 3})
+	errorxtest.AssertMetadata(r, errorx.Errorf("bare"), nil)
+	if len(r.failures) != 2 || !strings.Contains(r.failures[1], "has no metadata") {
+		t.Errorf("failures = %q", r.failures)
+	}
+}
+
+func TestAssertCode(t *testing.T) {
+	err := errorx.WrapPrefix(&httpError{status: 404}, "fetch", 0)
+	errorxtest.AssertCode(t, err, 404)
+
+	r := &recorder{TB: t}
+	errorxtest.AssertCode(r, err, 500)
+	errorxtest.AssertCode(r, err, "404")
+	if len(r.failures) != 2 || !strings.Contains(r.failures[0], "= 404, want 500") ||
+		!strings.Contains(r.failures[1], "no error with a Code() string method") {
+		t.Errorf("failures = %q", r.failures)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.ToSlash(filepath.Dir(wd))
+	in := "boom\ngithub.com/x/pkg.F\n\t" + root + "/pkg/f.go:42 +0x1a2b\n" +
+		"testingx.Run\n\t" + root + "/testingx/run.go:7 +0x10\n" +
+		"runtimeconfigLoad\n\t" + root + "/runtimeconfig/load.go:3 +0x8\n" +
+		"testing.tRunner\n\t/usr/local/go/src/testing/testing.go:1 +0x5\n" +
+		"runtimegoexit\n\t/usr/local/go/src/runtime/asm_amd64.s:1 +0x5\n" +
+		"goroutine 17 [running]:\n"
+	want := "boom\ngithub.com/x/pkg.F\n\tpkg/f.go:0 +0x0\ntestingx.Run\n\ttestingx/run.go:0 +0x0\n" +
+		"runtimeconfigLoad\n\truntimeconfig/load.go:0 +0x0\ngoroutine 1 [running]:\n"
+	if got := errorxtest.Normalize(in); got != want {
+		t.Errorf("Normalize =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNormalizeJSON(t *testing.T) {
+	in := `{"message":"boom","time":"2026-01-02T03:04:05Z","build_id":"abc","process":{"pid":7},` +
+		`"goroutine":{"id":42},"metadata":{"time":"kept"},"details":{"pkg.Info":{"build_id":"kept"}}}`
+	want := `{"details":{"pkg.Info":{"build_id":"kept"}},"goroutine":{"id":1},"message":"boom","metadata":{"time":"kept"}}`
+	got, err := errorxtest.NormalizeJSON([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, got); err != nil || compact.String() != want {
+		t.Errorf("NormalizeJSON =\n%s\nwant\n%s", compact.String(), want)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	err := loadConfig()
+	errorxtest.AssertGoldenFormat(t, filepath.Join("testdata", "load_config.txt"), err)
+	errorxtest.AssertGoldenJSON(t, filepath.Join("testdata", "load_config.json"), err)
+}
+
+func TestGoldenMismatch(t *testing.T) {
+	t.Setenv(errorxtest.UpdateEnv, "")
+	r := &recorder{TB: t}
+	if errorxtest.AssertGolden(r, filepath.Join("testdata", "load_config.txt"), []byte("other")) {
+		t.Error("mismatched golden passed")
+	}
+	if errorxtest.AssertGolden(r, filepath.Join("testdata", "missing.txt"), nil) {
+		t.Error("missing golden passed")
+	}
+}
@@ -0,0 +1,211 @@
+package errorxtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx/internal/modroot"
+)
+
+// UpdateEnv is the environment variable that, when set to a non-empty
+// value, makes the golden assertions rewrite their files instead of
+// comparing:
+//
+//	ERRORXTEST_UPDATE=1 go test ./...
+const UpdateEnv = "ERRORXTEST_UPDATE"
+
+var (
+	pcPattern        = regexp.MustCompile(`\+0x[0-9a-f]+`)
+	linePattern      = regexp.MustCompile(`(\.(?:go|s)):\d+`)
+	goroutinePattern = regexp.MustCompile(`goroutine \d+ \[`)
+	// harnessPattern matches the two-line frames of the testing and runtime
+	// packages that sit below every test function. Their files and number
+	// depend on the Go version and architecture. StackFrame.String runs the
+	// package and function names together, so a frame is recognized by its
+	// file under GOROOT/src rather than by a separator after the package.
+	harnessPattern = regexp.MustCompile(`(?m)^(?:testing|runtime)[^\n]*\n\t(?:[^\n]*/src/)?(?:testing|runtime)/[^\n]*\n`)
+)
+
+// Normalize rewrites the machine-specific parts of %+v and runtime stack
+// output: program counters become +0x0, line numbers become 0, goroutine
+// IDs become 1, paths inside the current module are made relative to its
+// root, paths inside GOROOT and the module cache start with $GOROOT and
+// $GOMODCACHE, and the frames of the testing and runtime packages are
+// removed.
+func Normalize(text string) string {
+	text = harnessPattern.ReplaceAllString(text, "")
+	text = trimPaths(text)
+	text = pcPattern.ReplaceAllString(text, "+0x0")
+	text = linePattern.ReplaceAllString(text, "${1}:0")
+	return goroutinePattern.ReplaceAllString(text, "goroutine 1 [")
+}
+
+// NormalizeJSON normalizes a JSON document holding a Record, as produced by
+// MarshalJSON: program counters and line numbers become 0, file paths are
+// rewritten as in Normalize, frames of the testing and runtime packages are
+// removed, and the stack keeps one zero PC per remaining frame. Members that
+// vary between runs (inlining marks, the construction time, the build ID and
+// process info) are dropped, and goroutine IDs become 1. The result is
+// indented. Metadata and details are left untouched.
+func NormalizeJSON(data []byte) ([]byte, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("errorxtest: NormalizeJSON: %w", err)
+	}
+	normalizeRecord(doc)
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// AssertGoldenFormat compares the normalized %+v output of err with the
+// golden file at path, typically under testdata.
+func AssertGoldenFormat(t testing.TB, path string, err error) bool {
+	t.Helper()
+	return AssertGolden(t, path, []byte(Normalize(fmt.Sprintf("%+v", err))))
+}
+
+// AssertGoldenJSON compares the normalized JSON encoding of err with the
+// golden file at path.
+func AssertGoldenJSON(t testing.TB, path string, err error) bool {
+	t.Helper()
+	data, merr := json.Marshal(err)
+	if merr != nil {
+		t.Errorf("errorxtest: marshal %q: %v", errorText(err), merr)
+		return false
+	}
+	if data, merr = NormalizeJSON(data); merr != nil {
+		t.Errorf("%v", merr)
+		return false
+	}
+	return AssertGolden(t, path, append(data, '\n'))
+}
+
+// AssertGolden compares got with the contents of the golden file at path.
+// When UpdateEnv is set, it writes got to path instead, creating missing
+// directories.
+func AssertGolden(t testing.TB, path string, got []byte) bool {
+	t.Helper()
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Errorf("errorxtest: %v", err)
+			return false
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Errorf("errorxtest: %v", err)
+			return false
+		}
+		return true
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("errorxtest: %v (set %s=1 to create it)", err, UpdateEnv)
+		return false
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("errorxtest: output differs from %s (set %s=1 to update)\n--- got\n%s\n--- want\n%s", path, UpdateEnv, got, want)
+		return false
+	}
+	return true
+}
+
+// volatileRecordKeys are the Record members NormalizeJSON removes: they
+// differ between runs, machines or builds and a golden file cannot pin
+// them. volatileFrameKeys are the StackFrame members removed likewise.
+var (
+	volatileRecordKeys = []string{"time", "build_id", "process"}
+	volatileFrameKeys  = []string{"inlined"}
+)
+
+// opaqueRecordKeys are the Record members holding caller data, which
+// NormalizeJSON leaves untouched.
+var opaqueRecordKeys = []string{"metadata", "details", "stack_frames"}
+
+func normalizeRecord(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		_, hasMessage := v["message"]
+		frames, hasFrames := v["stack_frames"].([]any)
+		if hasFrames {
+			kept := frames[:0]
+			for _, f := range frames {
+				frame, ok := f.(map[string]any)
+				if !ok {
+					continue
+				}
+				if pkg, _ := frame["package"].(string); pkg == "testing" || pkg == "runtime" {
+					continue
+				}
+				if file, ok := frame["file"].(string); ok {
+					frame["file"] = trimPaths(file)
+				}
+				frame["line_number"] = 0
+				if _, ok := frame["program_counter"]; ok {
+					frame["program_counter"] = 0
+				}
+				for _, k := range volatileFrameKeys {
+					delete(frame, k)
+				}
+				kept = append(kept, frame)
+			}
+			v["stack_frames"] = kept
+			if _, ok := v["stack"]; ok {
+				v["stack"] = make([]int, len(kept))
+			}
+		}
+		if hasMessage || hasFrames {
+			for _, k := range volatileRecordKeys {
+				delete(v, k)
+			}
+			if g, ok := v["goroutine"].(map[string]any); ok {
+				normalizeGoroutine(g)
+			}
+		}
+		for k, child := range v {
+			if !slices.Contains(opaqueRecordKeys, k) {
+				normalizeRecord(child)
+			}
+		}
+	case []any:
+		for _, child := range v {
+			normalizeRecord(child)
+		}
+	}
+}
+
+// normalizeGoroutine rewrites the goroutine info of a Record like the
+// "goroutine N [" headers in Normalize.
+func normalizeGoroutine(g map[string]any) {
+	for _, k := range []string{"id", "creator_id"} {
+		if _, ok := g[k]; ok {
+			g[k] = 1
+		}
+	}
+	if frame, ok := g["created_by"].(map[string]any); ok {
+		if file, ok := frame["file"].(string); ok {
+			frame["file"] = trimPaths(file)
+		}
+		frame["line_number"] = 0
+	}
+}
+
+// trimPaths relativizes paths below the current module root and replaces
+// the GOROOT and module cache prefixes with $GOROOT and $GOMODCACHE.
+func trimPaths(text string) string {
+	if root := modroot.Find(); root != "" {
+		text = strings.ReplaceAll(text, filepath.ToSlash(root)+"/", "")
+	}
+	if goroot := build.Default.GOROOT; goroot != "" {
+		text = strings.ReplaceAll(text, filepath.ToSlash(goroot)+"/", "$GOROOT/")
+	}
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		text = strings.ReplaceAll(text, filepath.ToSlash(filepath.Join(gopath, "pkg", "mod"))+"/", "$GOMODCACHE/")
+	}
+	return text
+}
@@ -0,0 +1,125 @@
+package errorx
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportedErrorEventType is the @type value that makes Google Cloud Logging
+// forward a structured log entry to Error Reporting.
+const ReportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// ServiceContext identifies the service an error event belongs to, as
+// defined by the Error Reporting API.
+type ServiceContext struct {
+	Service string `json:"service"`
+	Version string `json:"version,omitempty"`
+}
+
+// SourceLocation is the Error Reporting reportLocation of an event.
+type SourceLocation struct {
+	FilePath     string `json:"filePath"`
+	LineNumber   int    `json:"lineNumber"`
+	FunctionName string `json:"functionName"`
+}
+
+// ErrorContext carries the optional context of a ReportedErrorEvent.
+type ErrorContext struct {
+	ReportLocation *SourceLocation `json:"reportLocation,omitempty"`
+}
+
+// ReportedErrorEvent is the JSON payload understood by Google Cloud Error
+// Reporting. Message holds the error text followed by a Go runtime style
+// stack trace, which is what Error Reporting uses to group occurrences.
+type ReportedErrorEvent struct {
+	Type           string         `json:"@type"`
+	EventTime      time.Time      `json:"eventTime,omitzero"`
+	ServiceContext ServiceContext `json:"serviceContext"`
+	Message        string         `json:"message"`
+	Context        *ErrorContext  `json:"context,omitempty"`
+}
+
+// ReportedErrorEvent returns the error as an Error Reporting event for the
+// given service. EventTime is left zero so that the log entry timestamp is
+// used.
+func (e *TraceError) ReportedErrorEvent(svc ServiceContext) ReportedErrorEvent {
+	if e == nil {
+		return ReportedErrorEvent{Type: ReportedErrorEventType, ServiceContext: svc}
+	}
+	frames := e.StackFrames()
+	ev := ReportedErrorEvent{
+		Type:           ReportedErrorEventType,
+		ServiceContext: svc,
+		Message:        string(FormatGoroutineStack(e.Error(), frames)),
+	}
+	if f := inAppFrame(frames); f.Name != "" {
+		ev.Context = &ErrorContext{ReportLocation: &SourceLocation{
+			FilePath:     f.File,
+			LineNumber:   f.LineNumber,
+			FunctionName: f.FuncName(),
+		}}
+	}
+	return ev
+}
+
+// GoroutineStack returns the error rendered as Go runtime panic output:
+//
+//	panic: <message>
+//
+//	goroutine 1 [running]:
+//	pkg.Func(...)
+//		/path/file.go:12 +0x1d
+//
+// The result parses back with ParsePanic to the same frames, and is the
+// text format Google Cloud Error Reporting groups Go errors by.
+func (e *TraceError) GoroutineStack() []byte {
+	if e == nil {
+		return nil
+	}
+	return FormatGoroutineStack(e.Error(), e.StackFrames())
+}
+
+// FormatGoroutineStack renders message and frames in the Go runtime's panic
+// format. Multi-line messages are kept as-is; only the first line survives a
+// round trip through ParsePanic. Frames without a program counter omit the
+// "+0x" offset, as the runtime does for frames it cannot attribute.
+func FormatGoroutineStack(message string, frames []StackFrame) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("panic: ")
+	buf.WriteString(message)
+	buf.WriteString("\n\ngoroutine 1 [running]:\n")
+	writeGoroutineFrames(&buf, frames)
+	return buf.Bytes()
+}
+
+func writeGoroutineFrames(buf *bytes.Buffer, frames []StackFrame) {
+	for _, f := range frames {
+		buf.WriteString(f.FuncName())
+		buf.WriteString("(...)\n\t")
+		buf.WriteString(f.File)
+		buf.WriteByte(':')
+		buf.WriteString(strconv.Itoa(f.LineNumber))
+		if off, ok := f.entryOffset(); ok {
+			buf.WriteString(" +0x")
+			buf.WriteString(strconv.FormatUint(uint64(off), 16))
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+// entryOffset returns the frame's program counter relative to the entry of
+// its function, as printed by the runtime.
+func (s StackFrame) entryOffset() (uintptr, bool) {
+	fn := s.Func()
+	if fn == nil || s.ProgramCounter < fn.Entry() {
+		return 0, false
+	}
+	return s.ProgramCounter - fn.Entry(), true
+}
+
+// goroutineStackString is the StackGoroutine rendering used by LogOptions.
+func goroutineStackString(message string, frames []StackFrame) string {
+	return strings.TrimSuffix(string(FormatGoroutineStack(message, frames)), "\n")
+}
@@ -0,0 +1,87 @@
+package errorx_test
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func TestGoroutineStackRoundTrip(t *testing.T) {
+	te := errorx.WrapPrefix(errors.New("disk full"), "save", 0).(*errorx.TraceError)
+	text := string(te.GoroutineStack())
+
+	if !strings.HasPrefix(text, "panic: save: disk full\n\ngoroutine 1 [running]:\n") {
+		t.Fatalf("unexpected header:\n%s", text)
+	}
+	frameLine := regexp.MustCompile(`^\t.+\.(go|s):\d+ \+0x[0-9a-f]+$`)
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i := 3; i < len(lines); i += 2 {
+		if !strings.HasSuffix(lines[i], "(...)") {
+			t.Errorf("function line %q lacks (...)", lines[i])
+		}
+		if !frameLine.MatchString(lines[i+1]) {
+			t.Errorf("location line %q is not runtime format", lines[i+1])
+		}
+	}
+
+	parsed, err := errorx.ParsePanic(text)
+	if err != nil {
+		t.Fatalf("ParsePanic: %v", err)
+	}
+	if parsed.Error() != te.Error() {
+		t.Errorf("parsed message = %q, want %q", parsed.Error(), te.Error())
+	}
+	want := te.StackFrames()
+	got := parsed.StackFrames()
+	if len(got) != len(want) {
+		t.Fatalf("parsed %d frames, want %d", len(got), len(want))
+	}
+	for i := range want {
+		w := want[i]
+		w.ProgramCounter = 0
+		if got[i] != w {
+			t.Errorf("frame %d = %+v, want %+v", i, got[i], w)
+		}
+	}
+}
+
+func TestReportedErrorEvent(t *testing.T) {
+	te := errorx.Errorf("boom").(*errorx.TraceError)
+	ev := te.ReportedErrorEvent(errorx.ServiceContext{Service: "api", Version: "1.2.3"})
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if m["@type"] != errorx.ReportedErrorEventType {
+		t.Errorf("@type = %v", m["@type"])
+	}
+	if svc := m["serviceContext"].(map[string]any); svc["service"] != "api" || svc["version"] != "1.2.3" {
+		t.Errorf("serviceContext = %v", svc)
+	}
+	if msg, _ := m["message"].(string); !strings.HasPrefix(msg, "panic: boom\n\ngoroutine 1 [running]:\n") {
+		t.Errorf("message = %q", msg)
+	}
+	if _, ok := m["eventTime"]; ok {
+		t.Errorf("zero eventTime was marshaled")
+	}
+	loc := ev.Context.ReportLocation
+	if !strings.HasSuffix(loc.FunctionName, "TestReportedErrorEvent") || loc.LineNumber == 0 {
+		t.Errorf("reportLocation = %+v", loc)
+	}
+}
+
+func TestGCPLogOptionsUsesGoroutineFormat(t *testing.T) {
+	got := logJSON(t, "err", errorx.LogWith(errorx.Errorf("boom"), errorx.GCPLogOptions()))
+	if trace, _ := got["stack_trace"].(string); !strings.HasPrefix(trace, "panic: boom\n\ngoroutine 1 [running]:\n") {
+		t.Errorf("stack_trace = %q", got["stack_trace"])
+	}
+}
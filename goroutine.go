@@ -0,0 +1,256 @@
+package errorx
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"maps"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// GoroutineInfo identifies the goroutine that constructed an error, so that
+// errors from concurrent workers can be told apart.
+type GoroutineInfo struct {
+	// ID is the goroutine ID the runtime prints in panics and tracebacks.
+	ID int64 `json:"id,omitempty"`
+	// Labels are the runtime/pprof labels active on the goroutine, as set
+	// by pprof.Do or pprof.SetGoroutineLabels. The runtime reports them
+	// from Go 1.27 on while the tracebacklabels GODEBUG setting is
+	// enabled, which is the default for main modules declaring go 1.27 or
+	// later; otherwise Labels is empty. WrapContext reads them from its
+	// context instead, which works on every Go version.
+	Labels map[string]string `json:"labels,omitempty"`
+	// CreatedBy is the go statement that started the goroutine and
+	// CreatorID the goroutine that executed it. Both are empty for the main
+	// goroutine. CreatedBy has no program counter.
+	CreatedBy *StackFrame `json:"created_by,omitempty"`
+	CreatorID int64       `json:"creator_id,omitempty"`
+}
+
+// LogValue implements slog.LogValuer, logging the non-empty fields as a
+// group under their JSON names. Labels are logged as a group in key order.
+func (g GoroutineInfo) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, 4)
+	if g.ID != 0 {
+		attrs = append(attrs, slog.Int64("id", g.ID))
+	}
+	if len(g.Labels) > 0 {
+		labels := make([]slog.Attr, 0, len(g.Labels))
+		for _, k := range slices.Sorted(maps.Keys(g.Labels)) {
+			labels = append(labels, slog.String(k, g.Labels[k]))
+		}
+		attrs = append(attrs, slog.Attr{Key: "labels", Value: slog.GroupValue(labels...)})
+	}
+	if f := g.CreatedBy; f != nil {
+		attrs = append(attrs, slog.Any("created_by", logFrame{File: f.File, LineNumber: f.LineNumber, Name: f.Name, Package: f.Package}))
+	}
+	if g.CreatorID != 0 {
+		attrs = append(attrs, slog.Int64("creator_id", g.CreatorID))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+var includeGoroutine atomic.Bool
+
+// SetIncludeGoroutine controls whether errors constructed afterwards record
+// the GoroutineInfo of the constructing goroutine, reported by
+// GoroutineID, GoroutineLabels and CreatedBy, in Record.Goroutine and
+// therefore in MarshalJSON, the binary encoding and, under
+// LogOptions.GoroutineKey, in LogValue. It is off by default: the capture
+// formats the goroutine's traceback with runtime.Stack, which costs several
+// microseconds per error. It is safe for concurrent use.
+func SetIncludeGoroutine(include bool) {
+	includeGoroutine.Store(include)
+}
+
+// capturedGoroutine returns the info of the calling goroutine, or nil when
+// SetIncludeGoroutine is off.
+func capturedGoroutine() *GoroutineInfo {
+	if !includeGoroutine.Load() {
+		return nil
+	}
+	buf := make([]byte, 2048)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			return parseGoroutine(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// WrapContext is Wrap for callers holding the context that carries their
+// runtime/pprof labels, as set by pprof.Do or pprof.WithLabels. The labels
+// are read from ctx with pprof.ForLabels, so GoroutineLabels reports them
+// on every Go version and without SetIncludeGoroutine. With
+// SetIncludeGoroutine on they replace the labels parsed from the traceback.
+// WrapContext returns nil if err is nil.
+func WrapContext(ctx context.Context, err error, skip int) Error {
+	if err == nil {
+		return nil
+	}
+	te := newTraceError(err, captureStack(skip+1))
+	if labels := contextLabels(ctx); labels != nil {
+		x := te.setExtra()
+		if x.goroutine == nil {
+			x.goroutine = &GoroutineInfo{}
+		}
+		x.goroutine.Labels = labels
+	}
+	constructed(te, 0)
+	return te
+}
+
+// contextLabels returns the pprof labels of ctx, or nil when there are
+// none.
+func contextLabels(ctx context.Context) map[string]string {
+	var labels map[string]string
+	pprof.ForLabels(ctx, func(key, value string) bool {
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = value
+		return true
+	})
+	return labels
+}
+
+// parseGoroutine extracts the info from the traceback of a single
+// goroutine, which starts with a header such as
+//
+//	goroutine 7 [running] {worker: 3}:
+//
+// and, for goroutines other than main, ends with
+//
+//	created by example.com/app.(*Pool).start in goroutine 1
+//		/src/app/pool.go:42 +0x8c
+func parseGoroutine(stack []byte) *GoroutineInfo {
+	header, body, _ := bytes.Cut(stack, []byte("\n"))
+	rest, ok := strings.CutPrefix(string(header), "goroutine ")
+	if !ok {
+		return nil
+	}
+	id, rest, _ := strings.Cut(rest, " ")
+	g := &GoroutineInfo{}
+	g.ID, _ = strconv.ParseInt(id, 10, 64)
+	if _, labels, ok := strings.Cut(rest, "] {"); ok {
+		g.Labels = parseLabels(strings.TrimSuffix(labels, "}:"))
+	}
+
+	i := bytes.LastIndex(body, []byte("\ncreated by "))
+	if i < 0 {
+		return g
+	}
+	name, loc, _ := strings.Cut(string(body[i+len("\ncreated by "):]), "\n")
+	loc, _, _ = strings.Cut(loc, "\n")
+	name, creator, _ := strings.Cut(name, " in goroutine ")
+	g.CreatorID, _ = strconv.ParseInt(creator, 10, 64)
+	if f, err := parsePanicFrame(name, loc, true); err == nil {
+		g.CreatedBy = f
+	}
+	return g
+}
+
+// parseLabels parses the "k: v, k2: v2" label list of a goroutine header.
+// The runtime quotes keys and values holding characters other than ASCII
+// letters, digits, '.', '/' and '_'.
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for s != "" {
+		key, rest, ok := labelToken(s, ": ")
+		if !ok {
+			break
+		}
+		value, rest, ok := labelToken(rest, ", ")
+		if !ok {
+			break
+		}
+		labels[key] = value
+		s = rest
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// labelToken reads one key or value from the start of s and consumes the
+// separator sep that follows it, if any.
+func labelToken(s, sep string) (token, rest string, ok bool) {
+	if strings.HasPrefix(s, `"`) {
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", "", false
+		}
+		token, _ = strconv.Unquote(quoted)
+		rest = s[len(quoted):]
+	} else {
+		i := strings.Index(s, sep)
+		if i < 0 {
+			i = len(s)
+		}
+		token, rest = s[:i], s[i:]
+	}
+	if rest == "" {
+		return token, "", true
+	}
+	rest, ok = strings.CutPrefix(rest, sep)
+	return token, rest, ok
+}
+
+// GoroutineID returns the ID of the goroutine that constructed the error,
+// or 0 when SetIncludeGoroutine was off.
+func (e *TraceError) GoroutineID() int64 {
+	if e == nil || e.ext().goroutine == nil {
+		return 0
+	}
+	return e.ext().goroutine.ID
+}
+
+// GoroutineLabels returns a copy of the runtime/pprof labels active on the
+// goroutine that constructed the error, or those of the context passed to
+// WrapContext. It returns nil when there were none or they were not
+// recorded.
+func (e *TraceError) GoroutineLabels() map[string]string {
+	if e == nil || e.ext().goroutine == nil {
+		return nil
+	}
+	return maps.Clone(e.ext().goroutine.Labels)
+}
+
+// CreatedBy returns the go statement that started the goroutine that
+// constructed the error. ok is false for the main goroutine and when
+// SetIncludeGoroutine was off.
+func (e *TraceError) CreatedBy() (frame StackFrame, ok bool) {
+	if e == nil || e.ext().goroutine == nil || e.ext().goroutine.CreatedBy == nil {
+		return StackFrame{}, false
+	}
+	return *e.ext().goroutine.CreatedBy, true
+}
+
+// goroutineInfo returns a copy of the info e carries. While Deterministic
+// is active the IDs are zeroed and CreatedBy is normalized like other
+// frames.
+func (e *TraceError) goroutineInfo() *GoroutineInfo {
+	if e.ext().goroutine == nil {
+		return nil
+	}
+	g := *e.ext().goroutine
+	g.Labels = maps.Clone(g.Labels)
+	if g.CreatedBy != nil {
+		f := *g.CreatedBy
+		g.CreatedBy = &f
+	}
+	if d := deterministic.Load(); d != nil {
+		g.ID, g.CreatorID = 0, 0
+		if g.CreatedBy != nil {
+			*g.CreatedBy = d.frame(*g.CreatedBy)
+		}
+	}
+	return &g
+}
@@ -0,0 +1,167 @@
+package errorx_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"runtime/pprof"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func includeGoroutine(t *testing.T) {
+	errorx.SetIncludeGoroutine(true)
+	t.Cleanup(func() { errorx.SetIncludeGoroutine(false) })
+}
+
+// workerError constructs an error on a new goroutine running with labels.
+func workerError(labels pprof.LabelSet) *errorx.TraceError {
+	done := make(chan *errorx.TraceError)
+	go pprof.Do(context.Background(), labels, func(context.Context) {
+		done <- errorx.Errorf("worker failed").(*errorx.TraceError)
+	})
+	return <-done
+}
+
+func TestIncludeGoroutine(t *testing.T) {
+	before := errorx.Errorf("before").(*errorx.TraceError)
+	if before.GoroutineID() != 0 || before.Record().Goroutine != nil {
+		t.Fatalf("goroutine included by default: %+v", before.Record().Goroutine)
+	}
+	includeGoroutine(t)
+	// Go 1.27 reports labels in tracebacks by default only for modules
+	// declaring go 1.27; earlier runtimes ignore the setting.
+	t.Setenv("GODEBUG", "tracebacklabels=1")
+
+	self := errorx.Errorf("self").(*errorx.TraceError)
+	if self.GoroutineID() == 0 {
+		t.Fatal("GoroutineID() = 0")
+	}
+	if f, ok := self.CreatedBy(); !ok || f.Package != "testing" || f.Name != "(*T).Run" {
+		t.Errorf("CreatedBy() = %+v, %v, want testing.(*T).Run", f, ok)
+	}
+
+	te := workerError(pprof.Labels("worker", "7", "job id", `a "quoted" value`, "path", "/v1/items"))
+	if id := te.GoroutineID(); id == 0 || id == self.GoroutineID() {
+		t.Errorf("worker GoroutineID() = %d, test goroutine %d", id, self.GoroutineID())
+	}
+	f, ok := te.CreatedBy()
+	if !ok || f.Name != "workerError" || !strings.HasSuffix(f.File, "goroutine_test.go") || f.LineNumber == 0 {
+		t.Errorf("CreatedBy() = %+v, %v, want workerError in goroutine_test.go", f, ok)
+	}
+	rec := te.Record()
+	if rec.Goroutine == nil || rec.Goroutine.CreatorID != self.GoroutineID() {
+		t.Errorf("Record().Goroutine = %+v, want creator %d", rec.Goroutine, self.GoroutineID())
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", te)
+	var line struct {
+		Err struct {
+			Goroutine struct {
+				ID        int64             `json:"id"`
+				Labels    map[string]string `json:"labels"`
+				CreatedBy struct {
+					Name string `json:"name"`
+				} `json:"created_by"`
+			} `json:"goroutine"`
+		} `json:"err"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil || line.Err.Goroutine.ID != te.GoroutineID() ||
+		line.Err.Goroutine.CreatedBy.Name != "workerError" || !reflect.DeepEqual(line.Err.Goroutine.Labels, te.GoroutineLabels()) {
+		t.Errorf("LogValue = %s", buf.Bytes())
+	}
+	opts := errorx.StandardLogOptions()
+	opts.GoroutineKey = ""
+	buf.Reset()
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", errorx.LogWith(te, opts))
+	if bytes.Contains(buf.Bytes(), []byte(`"goroutine"`)) {
+		t.Errorf("empty GoroutineKey logged goroutine: %s", buf.Bytes())
+	}
+
+	errorx.Deterministic(t, errorx.DeterministicOptions{})
+	g := te.Record().Goroutine
+	if g == nil || g.ID != 0 || g.CreatorID != 0 || g.CreatedBy == nil || g.CreatedBy.File != "goroutine_test.go" {
+		t.Errorf("Record().Goroutine under Deterministic = %+v", g)
+	}
+	if te.GoroutineID() == 0 {
+		t.Error("Deterministic zeroed GoroutineID()")
+	}
+}
+
+func TestGoroutineLabelsFromTraceback(t *testing.T) {
+	includeGoroutine(t)
+	t.Setenv("GODEBUG", "tracebacklabels=1")
+	te := workerError(pprof.Labels("worker", "7", "job id", `a "quoted" value`, "path", "/v1/items"))
+	got := te.GoroutineLabels()
+	if got == nil {
+		t.Skip("runtime does not report goroutine labels in tracebacks")
+	}
+	want := map[string]string{"worker": "7", "job id": "a \"quoted\" value", "path": "/v1/items"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GoroutineLabels() = %q, want %q", got, want)
+	}
+}
+
+func TestWrapContext(t *testing.T) {
+	if errorx.WrapContext(context.Background(), nil, 0) != nil {
+		t.Error("WrapContext(nil) != nil")
+	}
+	plain := errorx.WrapContext(context.Background(), io.EOF, 0).(*errorx.TraceError)
+	if plain.Record().Goroutine != nil {
+		t.Errorf("unlabeled context recorded %+v", plain.Record().Goroutine)
+	}
+
+	want := map[string]string{"worker": "7", "job id": `a "quoted" value`}
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("worker", "7", "job id", `a "quoted" value`))
+	te := errorx.WrapContext(ctx, io.EOF, 0).(*errorx.TraceError)
+	if got := te.GoroutineLabels(); !reflect.DeepEqual(got, want) || te.GoroutineID() != 0 {
+		t.Errorf("GoroutineLabels() = %q, GoroutineID() = %d, want %q without ID", got, te.GoroutineID(), want)
+	}
+	if frames := te.StackFrames(); !errors.Is(te, io.EOF) || len(frames) < 2 || frames[1].Name != "TestWrapContext" {
+		t.Errorf("WrapContext = %v, frames %v", te, frames)
+	}
+
+	includeGoroutine(t)
+	te = errorx.WrapContext(ctx, io.EOF, 0).(*errorx.TraceError)
+	if got := te.GoroutineLabels(); !reflect.DeepEqual(got, want) || te.GoroutineID() == 0 {
+		t.Errorf("with SetIncludeGoroutine: GoroutineLabels() = %q, GoroutineID() = %d", got, te.GoroutineID())
+	}
+}
+
+func TestGoroutineRoundTrip(t *testing.T) {
+	remote := errorx.GoroutineInfo{
+		ID:        12,
+		Labels:    map[string]string{"worker": "3"},
+		CreatedBy: &errorx.StackFrame{File: "/src/pool.go", LineNumber: 42, Name: "(*Pool).start", Package: "example.com/app"},
+		CreatorID: 1,
+	}
+	rec := errorx.Errorf("boom").(*errorx.TraceError).Record()
+	rec.Goroutine = &remote
+
+	got := errorx.FromRecord(rec)
+	if got.GoroutineID() != 12 || !reflect.DeepEqual(got.Record().Goroutine, &remote) {
+		t.Errorf("FromRecord Goroutine = %+v", got.Record().Goroutine)
+	}
+	if f, ok := got.CreatedBy(); !ok || f != *remote.CreatedBy {
+		t.Errorf("CreatedBy() = %+v, %v", f, ok)
+	}
+
+	data, err := got.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded errorx.TraceError
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Record(), got.Record()) {
+		t.Errorf("binary round trip\n got %+v\nwant %+v", decoded.Record(), got.Record())
+	}
+}
@@ -0,0 +1,101 @@
+package errorx
+
+import (
+	"expvar"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Hook observes construction of a *TraceError. caller is the frame that
+// called the constructor. Hooks run synchronously on the constructing
+// goroutine, so they must be fast and safe for concurrent use, and must not
+// retain or mutate te beyond what its concurrency contract allows.
+type Hook func(te *TraceError, caller StackFrame)
+
+type hookEntry struct {
+	id uint64
+	fn Hook
+}
+
+var (
+	hooksMu  sync.Mutex
+	hookSeq  uint64
+	hookList atomic.Pointer[[]hookEntry]
+)
+
+// RegisterHook adds h to the hooks invoked by NewError, NewErrorf, Errorf,
+// Wrap, WrapPrefix, WrapContext and FromPanic, and returns a function that
+// removes it. Registration is safe for concurrent use; while no hook is
+// registered the constructors pay only for a single atomic load.
+func RegisterHook(h Hook) (unregister func()) {
+	if h == nil {
+		return func() {}
+	}
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hookSeq++
+	id := hookSeq
+	var next []hookEntry
+	if cur := hookList.Load(); cur != nil {
+		next = append(next, *cur...)
+	}
+	next = append(next, hookEntry{id: id, fn: h})
+	hookList.Store(&next)
+
+	var once sync.Once
+	return func() { once.Do(func() { removeHook(id) }) }
+}
+
+func removeHook(id uint64) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	cur := hookList.Load()
+	if cur == nil {
+		return
+	}
+	next := make([]hookEntry, 0, len(*cur))
+	for _, e := range *cur {
+		if e.id != id {
+			next = append(next, e)
+		}
+	}
+	if len(next) == 0 {
+		hookList.Store(nil)
+		return
+	}
+	hookList.Store(&next)
+}
+
+// constructed runs the registered hooks for te. skip is the number of
+// frames above the constructor's caller to skip; 0 reports the caller of
+// the function that calls constructed.
+func constructed(te *TraceError, skip int) {
+	hooks := hookList.Load()
+	if hooks == nil {
+		return
+	}
+	runHooks(*hooks, te, skip+1)
+}
+
+func runHooks(hooks []hookEntry, te *TraceError, skip int) {
+	var caller StackFrame
+	var pcs [1]uintptr
+	if runtime.Callers(skip+3, pcs[:]) == 1 {
+		rf, _ := runtime.CallersFrames(pcs[:]).Next()
+		caller = newRuntimeFrame(rf, pcs[0])
+	}
+	for _, h := range hooks {
+		h.fn(te, caller)
+	}
+}
+
+// ExpvarCallerCounter returns a Hook that increments the entry of m named
+// after the calling function (e.g. "github.com/acme/app/store.(*DB).Get")
+// for every constructed error. Publish m with expvar.Publish or create it
+// with expvar.NewMap to expose the counts on /debug/vars.
+func ExpvarCallerCounter(m *expvar.Map) Hook {
+	return func(_ *TraceError, caller StackFrame) {
+		m.Add(caller.FuncName(), 1)
+	}
+}
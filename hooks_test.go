@@ -0,0 +1,93 @@
+package errorx_test
+
+import (
+	"errors"
+	"expvar"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func TestRegisterHookSeesEveryConstructor(t *testing.T) {
+	var mu sync.Mutex
+	var callers []string
+	var seen []*errorx.TraceError
+	unregister := errorx.RegisterHook(func(te *errorx.TraceError, caller errorx.StackFrame) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, te)
+		callers = append(callers, caller.Name)
+	})
+
+	base := errors.New("base")
+	errs := []error{
+		errorx.NewError(base),
+		errorx.Errorf("x"),
+		errorx.NewErrorf("x"),
+		errorx.Wrap(base, 0),
+		errorx.WrapPrefix(base, "p", 0),
+		errorx.FromPanic("p", nil),
+	}
+	unregister()
+	_ = errorx.Errorf("after unregister")
+
+	if len(seen) != len(errs) {
+		t.Fatalf("hook ran %d times, want %d", len(seen), len(errs))
+	}
+	for i, err := range errs {
+		if error(seen[i]) != err {
+			t.Errorf("hook %d saw %v, want %v", i, seen[i], err)
+		}
+		if callers[i] != "TestRegisterHookSeesEveryConstructor" {
+			t.Errorf("hook %d caller = %q", i, callers[i])
+		}
+	}
+}
+
+func TestRegisterHookUnregisterIsIdempotent(t *testing.T) {
+	var a, b int
+	unA := errorx.RegisterHook(func(*errorx.TraceError, errorx.StackFrame) { a++ })
+	unB := errorx.RegisterHook(func(*errorx.TraceError, errorx.StackFrame) { b++ })
+	unA()
+	unA()
+	_ = errorx.Errorf("x")
+	unB()
+	if a != 0 || b != 1 {
+		t.Errorf("a=%d b=%d, want 0 and 1", a, b)
+	}
+}
+
+func TestExpvarCallerCounter(t *testing.T) {
+	m := new(expvar.Map).Init()
+	defer errorx.RegisterHook(errorx.ExpvarCallerCounter(m))()
+
+	for i := 0; i < 3; i++ {
+		_ = errorx.Errorf("x")
+	}
+	var key string
+	m.Do(func(kv expvar.KeyValue) { key = kv.Key })
+	if !strings.HasSuffix(key, "errorx_test.TestExpvarCallerCounter") {
+		t.Errorf("counter key = %q", key)
+	}
+	if got := m.Get(key).String(); got != "3" {
+		t.Errorf("count = %s, want 3", got)
+	}
+}
+
+func TestRegisterHookConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				un := errorx.RegisterHook(func(*errorx.TraceError, errorx.StackFrame) {})
+				_ = errorx.Errorf("x")
+				un()
+			}
+		}()
+	}
+	wg.Wait()
+}
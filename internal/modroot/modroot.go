@@ -0,0 +1,26 @@
+// Package modroot locates the root of the Go module being built or tested.
+package modroot
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Find returns the nearest directory at or above the working directory that
+// holds a go.mod file, or "" when there is none.
+func Find() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,235 @@
+package errorx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// OriginKey returns a short stable key identifying occurrences of the same
+// error at the same place. For errors whose chain contains a *TraceError the
+// key is derived from the outermost layer's Type(), prefix and origin frame,
+// the first frame outside this package, so that messages differing only in
+// their data share a key. Other errors are keyed by their dynamic Go type
+// alone. OriginKey returns "" for nil.
+func OriginKey(err error) string {
+	if err == nil {
+		return ""
+	}
+	h := fnv.New64a()
+	var te *TraceError
+	if errors.As(err, &te) && te != nil {
+		origin := inAppFrame(te.StackFrames())
+		for _, s := range []string{te.Type(), te.prefix, origin.Package, origin.Name, strconv.Itoa(origin.LineNumber)} {
+			_, _ = h.Write([]byte(s))
+			_, _ = h.Write([]byte{0})
+		}
+	} else {
+		_, _ = h.Write([]byte(reflect.TypeOf(err).String()))
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// LimiterOptions configures a Limiter. The zero value of every field
+// selects a sensible default.
+type LimiterOptions struct {
+	// Rate is the sustained number of occurrences per second allowed per
+	// key. Default 1.
+	Rate float64
+	// Burst is the number of occurrences per key allowed ahead of Rate.
+	// Default 1.
+	Burst int
+	// MaxKeys bounds the number of keys whose state is retained. Default
+	// DefaultMaxKeys.
+	MaxKeys int
+	// KeyFunc derives the key. Default OriginKey.
+	KeyFunc func(error) string
+	// Clock is the time source. Default SystemClock.
+	Clock Clock
+}
+
+// Limiter decides whether an occurrence of an error should be logged or
+// reported, so that an error repeated in a hot loop does not flood the
+// logs. Each key gets a token bucket; the least recently used keys are
+// forgotten beyond MaxKeys. Occurrences refused are counted and the count
+// travels with the next occurrence let through. A Limiter is safe for
+// concurrent use.
+type Limiter struct {
+	limiter *keyedLimiter
+	keyFunc func(error) string
+	clock   Clock
+}
+
+// NewLimiter returns a Limiter configured by opts.
+func NewLimiter(opts LimiterOptions) *Limiter {
+	if opts.Rate <= 0 {
+		opts.Rate = 1
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = OriginKey
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	return &Limiter{
+		limiter: newKeyedLimiter(opts.Rate, opts.Burst, opts.MaxKeys),
+		keyFunc: opts.KeyFunc,
+		clock:   opts.Clock,
+	}
+}
+
+// Allow reports whether err may be logged or reported now, and returns the
+// error to log. When it may and earlier occurrences with the same key were
+// suppressed, that error wraps err and carries their count, which Suppressed
+// reports and LogValue logs under LogOptions.SuppressedKey:
+//
+//	if ok, err := limiter.Allow(err); ok {
+//	    logger.Error("poll failed", "err", err) // ... err.suppressed=1532
+//	}
+//
+// Otherwise the returned error is err itself. Allow(nil) returns true, nil.
+func (l *Limiter) Allow(err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	ok, suppressed := l.limiter.allow(l.keyFunc(err), l.clock.Now())
+	if !ok {
+		return false, err
+	}
+	if suppressed > 0 {
+		return true, &suppressedError{err: err, count: suppressed}
+	}
+	return true, err
+}
+
+// Suppressed returns the number of occurrences a Limiter suppressed before
+// letting err through, or 0 when err does not come from Allow.
+func Suppressed(err error) int {
+	var s *suppressedError
+	if errors.As(err, &s) && s != nil {
+		return s.count
+	}
+	return 0
+}
+
+// suppressedError is the error returned by Limiter.Allow for an occurrence
+// that follows suppressed ones. It only adds the count; message, formatting
+// and unwrapping are those of err.
+type suppressedError struct {
+	err   error
+	count int
+}
+
+func (s *suppressedError) Error() string { return s.err.Error() }
+
+func (s *suppressedError) Unwrap() error { return s.err }
+
+// LogValue implements slog.LogValuer.
+func (s *suppressedError) LogValue() slog.Value {
+	return errorLogValue(s, defaultLogOptions())
+}
+
+// Format implements fmt.Formatter by delegating to the wrapped error.
+func (s *suppressedError) Format(st fmt.State, verb rune) {
+	if f, ok := s.err.(fmt.Formatter); ok {
+		f.Format(st, verb)
+		return
+	}
+	_, _ = fmt.Fprintf(st, fmt.FormatString(st, verb), s.err)
+}
+
+// LimitHandler is an slog.Handler middleware that passes every record
+// carrying an error attribute through a Limiter. Records whose error is
+// suppressed are dropped; in the next record let through for the same key
+// the error is replaced by the one returned by Allow, so that it logs the
+// suppressed count. Only the first error of a record is considered, at any
+// key and inside groups; errors added with WithAttrs and records without
+// errors pass unchanged.
+//
+// A LogHandler must come after the LimitHandler, as in
+// NewLimitHandler(NewLogHandler(next, nil), limiter), because it replaces
+// errors with their expanded form.
+type LimitHandler struct {
+	next    slog.Handler
+	limiter *Limiter
+}
+
+// NewLimitHandler returns a LimitHandler that forwards to next the records
+// l lets through.
+func NewLimitHandler(next slog.Handler, l *Limiter) *LimitHandler {
+	return &LimitHandler{next: next, limiter: l}
+}
+
+// Enabled implements slog.Handler.
+func (h *LimitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *LimitHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	allowed := true
+	attrs, found := replaceFirstError(attrs, func(err error) error {
+		var out error
+		allowed, out = h.limiter.Allow(err)
+		return out
+	})
+	if !found {
+		return h.next.Handle(ctx, r)
+	}
+	if !allowed {
+		return nil
+	}
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	out.AddAttrs(attrs...)
+	return h.next.Handle(ctx, out)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *LimitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return &LimitHandler{next: h.next.WithAttrs(attrs), limiter: h.limiter}
+}
+
+// WithGroup implements slog.Handler.
+func (h *LimitHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &LimitHandler{next: h.next.WithGroup(name), limiter: h.limiter}
+}
+
+// replaceFirstError replaces the first error held in attrs, descending
+// into groups, with fn's result. It reports whether there was one; attrs
+// itself is left untouched.
+func replaceFirstError(attrs []slog.Attr, fn func(error) error) ([]slog.Attr, bool) {
+	for i, a := range attrs {
+		var v slog.Value
+		if err := errorOf(a.Value); err != nil {
+			v = slog.AnyValue(fn(err))
+		} else if a.Value.Kind() == slog.KindGroup {
+			group, found := replaceFirstError(a.Value.Group(), fn)
+			if !found {
+				continue
+			}
+			v = slog.GroupValue(group...)
+		} else {
+			continue
+		}
+		out := slices.Clone(attrs)
+		out[i].Value = v
+		return out, true
+	}
+	return attrs, false
+}
@@ -0,0 +1,154 @@
+package errorx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neumachen/errorx"
+)
+
+// pollError fails at one site with a message that varies by attempt.
+func pollError(attempt int) error {
+	return errorx.Errorf("poll %d: %w", attempt, io.ErrUnexpectedEOF)
+}
+
+func otherError() error {
+	return errorx.Errorf("other")
+}
+
+func TestLimiter(t *testing.T) {
+	clock := newFakeClock()
+	l := errorx.NewLimiter(errorx.LimiterOptions{Rate: 1, Burst: 2, Clock: clock})
+
+	var allowed int
+	for i := range 1534 {
+		if ok, _ := l.Allow(pollError(i)); ok {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("allowed %d of 1534 occurrences, want the burst of 2", allowed)
+	}
+	if ok, _ := l.Allow(otherError()); !ok {
+		t.Error("an error from another site was suppressed")
+	}
+
+	clock.Advance(time.Second)
+	ok, err := l.Allow(pollError(1534))
+	if !ok || errorx.Suppressed(err) != 1532 {
+		t.Fatalf("Allow after a second = %v, %v with %d suppressed, want 1532", err, ok, errorx.Suppressed(err))
+	}
+	if err.Error() != "poll 1534: unexpected EOF" || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Allow changed the error: %q", err)
+	}
+	var te *errorx.TraceError
+	if !errors.As(err, &te) || fmt.Sprintf("%+v", err) != fmt.Sprintf("%+v", te) {
+		t.Errorf("%%+v = %+v", err)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Error("poll failed", "err", err)
+	if !strings.Contains(buf.String(), " err.suppressed=1532") {
+		t.Errorf("LogValue = %s", buf.String())
+	}
+
+	clock.Advance(time.Second)
+	if ok, err := l.Allow(pollError(1535)); !ok || errorx.Suppressed(err) != 0 {
+		t.Errorf("Allow = %v, %v with %d suppressed, want the count reset", err, ok, errorx.Suppressed(err))
+	}
+	if ok, err := l.Allow(nil); err != nil || !ok {
+		t.Errorf("Allow(nil) = %v, %v", ok, err)
+	}
+}
+
+func TestLimiterKeyFunc(t *testing.T) {
+	clock := newFakeClock()
+	l := errorx.NewLimiter(errorx.LimiterOptions{
+		KeyFunc: func(err error) string { return err.Error() },
+		MaxKeys: 1,
+		Clock:   clock,
+	})
+	plain := errors.New("plain")
+	if ok, _ := l.Allow(plain); !ok {
+		t.Fatal("first occurrence suppressed")
+	}
+	if ok, _ := l.Allow(plain); ok {
+		t.Error("second occurrence allowed")
+	}
+	if ok, _ := l.Allow(errors.New("plain")); ok {
+		t.Error("an error with the same key was allowed")
+	}
+	if ok, _ := l.Allow(errors.New("evicts plain")); !ok {
+		t.Error("an error with another key was suppressed")
+	}
+	// plain's key was evicted along with its suppressed count.
+	if ok, err := l.Allow(plain); !ok || errorx.Suppressed(err) != 0 {
+		t.Errorf("Allow after eviction = %v, %v with %d suppressed", err, ok, errorx.Suppressed(err))
+	}
+
+	l.Allow(plain)
+	clock.Advance(time.Second)
+	_, err := l.Allow(plain)
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", err)
+	if !strings.Contains(buf.String(), `"err":{"message":"plain","suppressed":1}`) {
+		t.Errorf("LogValue of a plain error = %s", buf.String())
+	}
+}
+
+func TestOriginKey(t *testing.T) {
+	if pollKey := errorx.OriginKey(pollError(1)); pollKey != errorx.OriginKey(pollError(2)) || pollKey == errorx.OriginKey(otherError()) {
+		t.Error("OriginKey does not group by origin")
+	}
+	if errorx.OriginKey(errorx.WrapPrefix(pollError(1), "a", 0)) == errorx.OriginKey(errorx.WrapPrefix(pollError(1), "b", 0)) {
+		t.Error("OriginKey ignores the prefix")
+	}
+	if errorx.OriginKey(errors.New("a")) != errorx.OriginKey(errors.New("b")) || errorx.OriginKey(nil) != "" {
+		t.Error("OriginKey of plain errors is not their type")
+	}
+}
+
+func TestLimitHandler(t *testing.T) {
+	clock := newFakeClock()
+	var buf bytes.Buffer
+	limiter := errorx.NewLimiter(errorx.LimiterOptions{Clock: clock})
+	logger := slog.New(errorx.NewLimitHandler(errorx.NewLogHandler(slog.NewJSONHandler(&buf, nil), nil), limiter)).With("service", "poller")
+
+	for i := range 5 {
+		logger.Error("poll failed", slog.Group("req", slog.Int("attempt", i), slog.Any("err", pollError(i))))
+		logger.Info("still running")
+	}
+	clock.Advance(time.Second)
+	logger.Error("poll failed", slog.Group("req", slog.Int("attempt", 5), slog.Any("err", pollError(5))))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var failed []string
+	for _, line := range lines {
+		if strings.Contains(line, "poll failed") {
+			failed = append(failed, line)
+		}
+		if !strings.Contains(line, `"service":"poller"`) {
+			t.Errorf("WithAttrs lost: %s", line)
+		}
+	}
+	if len(lines) != 7 || len(failed) != 2 {
+		t.Fatalf("logged %d lines, %d of them errors, want 7 and 2:\n%s", len(lines), len(failed), buf.String())
+	}
+	if !strings.Contains(failed[0], `"attempt":0`) || strings.Contains(failed[0], "suppressed") {
+		t.Errorf("first error = %s", failed[0])
+	}
+	if !strings.Contains(failed[1], `"attempt":5`) || !strings.Contains(failed[1], `"suppressed":4`) || !strings.Contains(failed[1], `"stack_frames"`) {
+		t.Errorf("error after the suppressed ones = %s", failed[1])
+	}
+
+	if !errorx.NewLimitHandler(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelWarn}), limiter).Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("Enabled not delegated")
+	}
+}
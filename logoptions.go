@@ -0,0 +1,369 @@
+package errorx
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// StackFormat selects how LogValue renders stack frames.
+type StackFormat int
+
+const (
+	// StackStructured logs frames as a list of objects. JSON handlers
+	// render it natively; text handlers render Go syntax.
+	StackStructured StackFormat = iota
+	// StackString logs frames as one newline-separated string of
+	// "pkg.Func\n\tfile:line" entries, which every handler renders
+	// readably and which log backends index as a stack trace.
+	StackString
+	// StackGoroutine logs the message and frames as Go runtime panic
+	// output (see FormatGoroutineStack), the format Google Cloud Error
+	// Reporting requires to group Go errors.
+	StackGoroutine
+)
+
+// LogOptions controls the shape of the slog.Value produced by LogValue:
+// attribute names, how much of the stack is included and in which form,
+// and whether the layers of a wrapper chain are listed. An empty key omits
+// the corresponding attribute.
+//
+// The zero value omits every attribute; start from StandardLogOptions or
+// one of the presets and adjust.
+type LogOptions struct {
+	MessageKey string
+	CauseKey   string
+	TypeKey    string
+	PrefixKey  string
+	// TimeKey names the construction time of the error and, with
+	// IncludeChain, of each layer.
+	TimeKey     string
+	StackKey    string
+	MetadataKey string
+	// DetailsKey names the group of values attached with WithDetail,
+	// keyed by their Go type.
+	DetailsKey string
+	// ViolationsKey names the list of field violations logged for a
+	// *ValidationError.
+	ViolationsKey string
+	// ChainKey names the list of per-layer entries written when
+	// IncludeChain is set.
+	ChainKey string
+	// CommonFramesKey names the number of frames left out of a chain entry
+	// by ElideCommonFrames.
+	CommonFramesKey string
+	// ProcessKey names the process and build info logged for errors that
+	// carry it (see SetIncludeProcess).
+	ProcessKey string
+	// GoroutineKey names the goroutine ID, labels and creation site logged
+	// for errors that carry them (see SetIncludeGoroutine).
+	GoroutineKey string
+	// SuppressedKey names the number of earlier occurrences a Limiter
+	// suppressed before letting the error through.
+	SuppressedKey string
+
+	// MaxFrames limits the frames logged. Zero logs all frames; a negative
+	// value logs none.
+	MaxFrames int
+	// StackFormat selects how frames are rendered.
+	StackFormat StackFormat
+	// IncludePCs adds program counters to each frame.
+	IncludePCs bool
+	// IncludeChain lists every *TraceError layer of the Unwrap chain with
+	// its own prefix, type and stack.
+	IncludeChain bool
+	// ElideCommonFrames logs in each chain entry only the frames the layer
+	// does not share with the next inner layer (see CommonFrames).
+	ElideCommonFrames bool
+}
+
+// StandardLogOptions returns the default options, which the presets below
+// start from: every attribute under its Record JSON name (message, cause,
+// type, prefix, time, stack_frames, metadata, details, violations, chain,
+// common_frames, process, goroutine and suppressed), with structured frames
+// including program counters.
+func StandardLogOptions() LogOptions {
+	return LogOptions{
+		MessageKey:      "message",
+		CauseKey:        "cause",
+		TypeKey:         "type",
+		PrefixKey:       "prefix",
+		TimeKey:         "time",
+		StackKey:        "stack_frames",
+		MetadataKey:     "metadata",
+		DetailsKey:      "details",
+		ViolationsKey:   "violations",
+		ChainKey:        "chain",
+		CommonFramesKey: "common_frames",
+		ProcessKey:      "process",
+		GoroutineKey:    "goroutine",
+		SuppressedKey:   "suppressed",
+		IncludePCs:      true,
+	}
+}
+
+// ECSLogOptions returns options following the Elastic Common Schema error
+// fields. Log the error under the key "error" to obtain error.message,
+// error.type and error.stack_trace.
+func ECSLogOptions() LogOptions {
+	opts := StandardLogOptions()
+	opts.StackKey = "stack_trace"
+	opts.StackFormat = StackString
+	opts.IncludePCs = false
+	return opts
+}
+
+// GCPLogOptions returns options suited to Google Cloud Logging, which
+// recognizes a "stack_trace" string alongside "message" in the JSON
+// payload. The stack is rendered in Go runtime panic format so that Error
+// Reporting can group the entries.
+func GCPLogOptions() LogOptions {
+	opts := StandardLogOptions()
+	opts.StackKey = "stack_trace"
+	opts.StackFormat = StackGoroutine
+	opts.IncludePCs = false
+	return opts
+}
+
+// OTelLogOptions returns options following the OpenTelemetry exception
+// semantic conventions. Log the error under the key "exception" to obtain
+// exception.message, exception.type and exception.stacktrace.
+func OTelLogOptions() LogOptions {
+	opts := StandardLogOptions()
+	opts.StackKey = "stacktrace"
+	opts.StackFormat = StackString
+	opts.IncludePCs = false
+	return opts
+}
+
+var logOptions atomic.Pointer[LogOptions]
+
+// SetDefaultLogOptions replaces the options used by (*TraceError).LogValue
+// across the process. It is safe for concurrent use.
+func SetDefaultLogOptions(opts LogOptions) {
+	logOptions.Store(&opts)
+}
+
+func defaultLogOptions() LogOptions {
+	if p := logOptions.Load(); p != nil {
+		return *p
+	}
+	return StandardLogOptions()
+}
+
+// LogWith returns a slog.LogValuer that logs err with opts instead of the
+// package-wide defaults. It is the per-error counterpart of
+// SetDefaultLogOptions:
+//
+//	logger.Error("request failed", "error", errorx.LogWith(err, errorx.ECSLogOptions()))
+//
+// Errors whose chain holds no *TraceError are logged as their message.
+func LogWith(err error, opts LogOptions) slog.LogValuer {
+	return optionsValuer{err: err, opts: opts}
+}
+
+type optionsValuer struct {
+	err  error
+	opts LogOptions
+}
+
+func (v optionsValuer) LogValue() slog.Value {
+	if v.err == nil {
+		return slog.Value{}
+	}
+	return errorLogValue(v.err, v.opts)
+}
+
+// errorLogValue builds the LogValue of err shaped by opts. Errors whose
+// chain holds no *TraceError are logged as their message, or as a group
+// adding their details and the count of a Limiter's suppressed occurrences
+// when there are any.
+func errorLogValue(err error, opts LogOptions) slog.Value {
+	var te *TraceError
+	if !errors.As(err, &te) || te == nil {
+		attrs := appendDetails(nil, err, opts)
+		if n := Suppressed(err); n > 0 && opts.SuppressedKey != "" {
+			attrs = append(attrs, slog.Int(opts.SuppressedKey, n))
+		}
+		if len(attrs) == 0 {
+			return slog.StringValue(err.Error())
+		}
+		return slog.GroupValue(append([]slog.Attr{slog.String(opts.MessageKey, err.Error())}, attrs...)...)
+	}
+	return te.logValueOf(err, opts)
+}
+
+// logValueOf builds the LogValue of err, whose chain holds e, adding the
+// count of a Limiter's suppressed occurrences when there is one.
+func (e *TraceError) logValueOf(err error, opts LogOptions) slog.Value {
+	v := e.logValue(err, opts)
+	if n := Suppressed(err); n > 0 && opts.SuppressedKey != "" {
+		v = slog.GroupValue(append(v.Group(), slog.Int(opts.SuppressedKey, n))...)
+	}
+	return v
+}
+
+// logValue builds the LogValue group. root is e or an outer wrapper whose
+// chain holds e; its message and details replace those of e so that outer
+// non-TraceError layers are logged too.
+func (e *TraceError) logValue(root error, opts LogOptions) slog.Value {
+	message := root.Error()
+	attrs := make([]slog.Attr, 0, 8)
+	attrs = appendString(attrs, opts.MessageKey, message)
+	if c := e.Cause(); c != nil && c.Error() != message {
+		attrs = appendString(attrs, opts.CauseKey, c.Error())
+	}
+	attrs = appendString(attrs, opts.TypeKey, e.Type())
+	attrs = appendString(attrs, opts.PrefixKey, e.prefix)
+	if at := e.logTime(); opts.TimeKey != "" && !at.IsZero() {
+		attrs = append(attrs, slog.Time(opts.TimeKey, at))
+	}
+	if opts.StackKey != "" && opts.MaxFrames >= 0 {
+		if frames := limitFrames(e.StackFrames(), opts.MaxFrames); len(frames) > 0 {
+			attrs = append(attrs, slog.Any(opts.StackKey, logFrames(message, frames, opts)))
+		}
+	}
+	if opts.MetadataKey != "" {
+		if md := e.Metadata(); md != nil {
+			attrs = append(attrs, slog.Any(opts.MetadataKey, md))
+		}
+	}
+	attrs = appendDetails(attrs, root, opts)
+	if opts.ViolationsKey != "" {
+		var ve *ValidationError
+		if errors.As(e, &ve) {
+			attrs = append(attrs, slog.Any(opts.ViolationsKey, ve.Violations()))
+		}
+	}
+	if opts.ProcessKey != "" {
+		if p := e.processInfo(); p != nil {
+			attrs = append(attrs, slog.Any(opts.ProcessKey, *p))
+		}
+	}
+	if opts.GoroutineKey != "" {
+		if g := e.goroutineInfo(); g != nil {
+			attrs = append(attrs, slog.Any(opts.GoroutineKey, *g))
+		}
+	}
+	if opts.IncludeChain && opts.ChainKey != "" {
+		if chain := chainLogValues(e, opts); len(chain) > 1 {
+			attrs = append(attrs, slog.Any(opts.ChainKey, chain))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// appendDetails appends the details group of err under opts.DetailsKey,
+// when there are details and the key is set.
+func appendDetails(attrs []slog.Attr, err error, opts LogOptions) []slog.Attr {
+	if opts.DetailsKey == "" {
+		return attrs
+	}
+	details := recordDetails(err)
+	if len(details) == 0 {
+		return attrs
+	}
+	group := make([]slog.Attr, 0, len(details))
+	for _, key := range slices.Sorted(maps.Keys(details)) {
+		group = append(group, slog.Any(key, details[key]))
+	}
+	return append(attrs, slog.Attr{Key: opts.DetailsKey, Value: slog.GroupValue(group...)})
+}
+
+func appendString(attrs []slog.Attr, key, value string) []slog.Attr {
+	if key == "" || value == "" {
+		return attrs
+	}
+	return append(attrs, slog.String(key, value))
+}
+
+// logFrame is the structured frame logged when program counters are
+// excluded; its field names match StackFrame's JSON names.
+type logFrame struct {
+	File       string `json:"file"`
+	LineNumber int    `json:"line_number"`
+	Name       string `json:"name"`
+	Package    string `json:"package"`
+	Inlined    bool   `json:"inlined,omitempty"`
+}
+
+// logFrames renders frames in the form selected by opts. message is only
+// used by StackGoroutine.
+func logFrames(message string, frames []StackFrame, opts LogOptions) any {
+	switch opts.StackFormat {
+	case StackGoroutine:
+		return goroutineStackString(message, frames)
+	case StackString:
+		var b strings.Builder
+		for _, f := range frames {
+			b.WriteString(f.FuncName())
+			b.WriteString("\n\t")
+			b.WriteString(f.File)
+			b.WriteByte(':')
+			b.WriteString(strconv.Itoa(f.LineNumber))
+			if opts.IncludePCs {
+				b.WriteString(" +0x")
+				b.WriteString(strconv.FormatUint(uint64(f.ProgramCounter), 16))
+			}
+			b.WriteByte('\n')
+		}
+		return b.String()
+	}
+	if opts.IncludePCs {
+		return frames
+	}
+	out := make([]logFrame, len(frames))
+	for i, f := range frames {
+		out[i] = logFrame{File: f.File, LineNumber: f.LineNumber, Name: f.Name, Package: f.Package, Inlined: f.Inlined}
+	}
+	return out
+}
+
+// logTime returns the construction time to log, which is zero while
+// Deterministic is active.
+func (e *TraceError) logTime() time.Time {
+	if deterministic.Load() != nil {
+		return time.Time{}
+	}
+	return e.time.Round(0)
+}
+
+// chainLogValues lists each *TraceError layer reachable through single
+// Unwrap calls, outermost first, as maps so that every slog handler can
+// encode them.
+func chainLogValues(e *TraceError, opts LogOptions) []map[string]any {
+	var out []map[string]any
+	for cur := error(e); cur != nil; cur = errors.Unwrap(cur) {
+		te, ok := cur.(*TraceError)
+		if !ok {
+			continue
+		}
+		layer := make(map[string]any, 3)
+		if opts.PrefixKey != "" && te.prefix != "" {
+			layer[opts.PrefixKey] = te.prefix
+		}
+		if opts.TypeKey != "" {
+			layer[opts.TypeKey] = te.Type()
+		}
+		if at := te.logTime(); opts.TimeKey != "" && !at.IsZero() {
+			layer[opts.TimeKey] = at
+		}
+		if opts.StackKey != "" && opts.MaxFrames >= 0 {
+			frames, common := te.StackFrames(), 0
+			if opts.ElideCommonFrames {
+				frames, common = uniqueFrames(te, innerLayer(te))
+			}
+			layer[opts.StackKey] = logFrames(te.Error(), limitFrames(frames, opts.MaxFrames), opts)
+			if common > 0 && opts.CommonFramesKey != "" {
+				layer[opts.CommonFramesKey] = common
+			}
+		}
+		out = append(out, layer)
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func logJSON(t *testing.T, key string, v any) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("msg", key, v)
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("unmarshal %q: %v", buf.String(), err)
+	}
+	group, ok := m[key].(map[string]any)
+	if !ok {
+		t.Fatalf("%s = %T, want group", key, m[key])
+	}
+	return group
+}
+
+func TestLogWithECS(t *testing.T) {
+	err := errorx.WrapPrefix(errors.New("root"), "ctx", 0)
+	got := logJSON(t, "error", errorx.LogWith(err, errorx.ECSLogOptions()))
+
+	if got["message"] != "ctx: root" || got["type"] != "*errors.errorString" {
+		t.Errorf("ECS fields = %v", got)
+	}
+	trace, ok := got["stack_trace"].(string)
+	if !ok || !strings.Contains(trace, "errorx_test.TestLogWithECS\n\t") {
+		t.Errorf("stack_trace = %#v, want string stack", got["stack_trace"])
+	}
+	if strings.Contains(trace, "+0x") {
+		t.Errorf("stack_trace includes PCs without IncludePCs")
+	}
+	if _, ok := got["stack_frames"]; ok {
+		t.Errorf("ECS output contains stack_frames")
+	}
+}
+
+func TestLogOptionsFramesAndKeys(t *testing.T) {
+	opts := errorx.StandardLogOptions()
+	opts.MaxFrames = 1
+	opts.IncludePCs = false
+	opts.CauseKey = ""
+	opts.MessageKey = "msg"
+	err := errorx.Wrap(fmt.Errorf("root"), 0)
+	got := logJSON(t, "err", errorx.LogWith(err, opts))
+
+	if got["msg"] != "root" {
+		t.Errorf("msg = %v", got["msg"])
+	}
+	frames, ok := got["stack_frames"].([]any)
+	if !ok || len(frames) != 1 {
+		t.Fatalf("stack_frames = %v, want one frame", got["stack_frames"])
+	}
+	if _, ok := frames[0].(map[string]any)["program_counter"]; ok {
+		t.Errorf("frame has program_counter without IncludePCs")
+	}
+}
+
+func TestLogOptionsIncludeChain(t *testing.T) {
+	opts := errorx.OTelLogOptions()
+	opts.IncludeChain = true
+	opts.MaxFrames = 2
+	inner := errorx.WrapPrefix(errors.New("root"), "inner", 0)
+	outer := errorx.WrapPrefix(inner, "outer", 0)
+	got := logJSON(t, "exception", errorx.LogWith(outer, opts))
+
+	if _, ok := got["stacktrace"].(string); !ok {
+		t.Errorf("stacktrace = %#v", got["stacktrace"])
+	}
+	chain, ok := got["chain"].([]any)
+	if !ok || len(chain) != 2 {
+		t.Fatalf("chain = %v, want two layers", got["chain"])
+	}
+	if chain[1].(map[string]any)["prefix"] != "inner" {
+		t.Errorf("chain[1] = %v", chain[1])
+	}
+}
+
+func TestSetDefaultLogOptions(t *testing.T) {
+	errorx.SetDefaultLogOptions(errorx.GCPLogOptions())
+	defer errorx.SetDefaultLogOptions(errorx.StandardLogOptions())
+
+	got := logJSON(t, "err", errorx.Errorf("boom"))
+	if _, ok := got["stack_trace"].(string); !ok {
+		t.Errorf("default options not applied: %v", got)
+	}
+}
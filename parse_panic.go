@@ -83,8 +83,8 @@ func ParsePanic(panicToParse string) (Error, error) {
 
 	if state == "done" || state == "parsing" {
 		te := &TraceError{
-			cause:        uncaughtPanic{message: message},
-			parsedFrames: stack,
+			cause: uncaughtPanic{message: message},
+			extra: &traceExtra{parsedFrames: stack},
 		}
 		return te, nil
 	}
@@ -94,11 +94,13 @@ func ParsePanic(panicToParse string) (Error, error) {
 // parsePanicFrame parses one (function, file:line) pair from the panic
 // stack section.
 func parsePanicFrame(name string, line string, createdBy bool) (*StackFrame, error) {
-	idx := strings.LastIndex(name, "(")
-	if idx == -1 && !createdBy {
-		return nil, fmt.Errorf("errorx.ParsePanic: invalid line (no call): %q", name)
-	}
-	if idx != -1 {
+	// "created by" lines name the function without an argument list, so a
+	// parenthesis there belongs to a method receiver such as (*T).Run.
+	if !createdBy {
+		idx := strings.LastIndex(name, "(")
+		if idx == -1 {
+			return nil, fmt.Errorf("errorx.ParsePanic: invalid line (no call): %q", name)
+		}
 		name = name[:idx]
 	}
 
@@ -108,7 +110,7 @@ func parsePanicFrame(name string, line string, createdBy bool) (*StackFrame, err
 		return nil, fmt.Errorf("errorx.ParsePanic: invalid line (no tab): %q", line)
 	}
 
-	idx = strings.LastIndex(line, ":")
+	idx := strings.LastIndex(line, ":")
 	if idx == -1 {
 		return nil, fmt.Errorf("errorx.ParsePanic: invalid line (no line number): %q", line)
 	}
@@ -0,0 +1,100 @@
+package errorx
+
+import (
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// ProcessInfo describes the process and build that produced an error, so
+// that an error in a log store can be traced back to a release.
+type ProcessInfo struct {
+	// Module and Version are the main module's path and version from
+	// debug.ReadBuildInfo; Version is "(devel)" for builds outside the
+	// module cache.
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Revision is the VCS revision stamped by the go command.
+	Revision  string `json:"vcs_revision,omitempty"`
+	GoVersion string `json:"go_version,omitempty"`
+	GOOS      string `json:"goos,omitempty"`
+	GOARCH    string `json:"goarch,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	PID       int    `json:"pid,omitempty"`
+}
+
+// LogValue implements slog.LogValuer, logging the non-empty fields as a
+// group under their JSON names.
+func (p ProcessInfo) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, 8)
+	attrs = appendString(attrs, "module", p.Module)
+	attrs = appendString(attrs, "version", p.Version)
+	attrs = appendString(attrs, "vcs_revision", p.Revision)
+	attrs = appendString(attrs, "go_version", p.GoVersion)
+	attrs = appendString(attrs, "goos", p.GOOS)
+	attrs = appendString(attrs, "goarch", p.GOARCH)
+	attrs = appendString(attrs, "hostname", p.Hostname)
+	if p.PID != 0 {
+		attrs = append(attrs, slog.Int("pid", p.PID))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// process is captured once when the package is initialized.
+var process = captureProcess()
+
+var includeProcess atomic.Bool
+
+func captureProcess() ProcessInfo {
+	p := ProcessInfo{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		PID:       os.Getpid(),
+	}
+	p.Hostname, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		p.Module, p.Version = info.Main.Path, info.Main.Version
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				p.Revision = s.Value
+			}
+		}
+	}
+	return p
+}
+
+// Process returns the process information captured at initialization.
+func Process() ProcessInfo {
+	return process
+}
+
+// SetIncludeProcess controls whether errors constructed afterwards carry
+// Process(), reported in Record.Process and therefore in MarshalJSON, the
+// binary encoding and, under LogOptions.ProcessKey, in LogValue. It is off
+// by default. Errors decoded with FromRecord or a BinaryDecoder report the
+// info they were encoded with, if any. It is safe for concurrent use.
+func SetIncludeProcess(include bool) {
+	includeProcess.Store(include)
+}
+
+// capturedProcess returns the info to attach to an error being constructed
+// in this process, or nil when SetIncludeProcess is off.
+func capturedProcess() *ProcessInfo {
+	if includeProcess.Load() {
+		return &process
+	}
+	return nil
+}
+
+// processInfo returns a copy of the info e carries. It is nil while
+// Deterministic is active.
+func (e *TraceError) processInfo() *ProcessInfo {
+	if e.ext().process == nil || deterministic.Load() != nil {
+		return nil
+	}
+	p := *e.ext().process
+	return &p
+}
@@ -0,0 +1,101 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func includeProcess(t *testing.T) {
+	errorx.SetIncludeProcess(true)
+	t.Cleanup(func() { errorx.SetIncludeProcess(false) })
+}
+
+func TestProcess(t *testing.T) {
+	p := errorx.Process()
+	if p.GOOS != runtime.GOOS || p.GOARCH != runtime.GOARCH || p.GoVersion != runtime.Version() || p.PID != os.Getpid() {
+		t.Errorf("Process() = %+v", p)
+	}
+	if host, _ := os.Hostname(); p.Hostname != host {
+		t.Errorf("Hostname = %q, want %q", p.Hostname, host)
+	}
+}
+
+func TestIncludeProcess(t *testing.T) {
+	before := errorx.Errorf("before").(*errorx.TraceError)
+	if rec := before.Record(); rec.Process != nil {
+		t.Fatalf("Process included by default: %+v", rec.Process)
+	}
+	includeProcess(t)
+	te := errorx.Errorf("after").(*errorx.TraceError)
+
+	if rec := te.Record(); rec.Process == nil || *rec.Process != errorx.Process() {
+		t.Errorf("Record().Process = %+v, want %+v", rec.Process, errorx.Process())
+	}
+	if rec := before.Record(); rec.Process != nil {
+		t.Errorf("error built before enabling gained Process: %+v", rec.Process)
+	}
+
+	data, err := json.Marshal(te)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Process map[string]any `json:"process"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil || doc.Process["goos"] != runtime.GOOS || doc.Process["pid"] != float64(os.Getpid()) {
+		t.Errorf("MarshalJSON = %s", data)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", te)
+	var line struct {
+		Err struct {
+			Process map[string]any `json:"process"`
+		} `json:"err"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil || line.Err.Process["go_version"] != runtime.Version() {
+		t.Errorf("LogValue = %s", buf.Bytes())
+	}
+	opts := errorx.StandardLogOptions()
+	opts.ProcessKey = ""
+	buf.Reset()
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", errorx.LogWith(te, opts))
+	if bytes.Contains(buf.Bytes(), []byte(`"process"`)) {
+		t.Errorf("empty ProcessKey logged process: %s", buf.Bytes())
+	}
+
+	errorx.Deterministic(t, errorx.DeterministicOptions{})
+	if rec := te.Record(); rec.Process != nil {
+		t.Errorf("Process reported under Deterministic: %+v", rec.Process)
+	}
+}
+
+func TestProcessRoundTrip(t *testing.T) {
+	remote := errorx.ProcessInfo{Module: "example.com/svc", Version: "v1.2.3", Revision: "abc123", GOOS: "plan9", GOARCH: "arm", Hostname: "svc-0", PID: 42}
+	rec := errorx.Errorf("boom").(*errorx.TraceError).Record()
+	rec.Process = &remote
+
+	got := errorx.FromRecord(rec)
+	if p := got.Record().Process; p == nil || *p != remote {
+		t.Errorf("FromRecord Process = %+v", p)
+	}
+
+	data, err := got.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded errorx.TraceError
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Record(), got.Record()) {
+		t.Errorf("binary round trip\n got %+v\nwant %+v", decoded.Record(), got.Record())
+	}
+}
@@ -0,0 +1,88 @@
+package errorx
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultMaxKeys is the default bound on the number of grouping keys whose
+// rate-limit state is retained. The least recently used key is evicted when
+// the bound is exceeded.
+const DefaultMaxKeys = 4096
+
+// keyedLimiter is a token bucket per grouping key with a bounded LRU of keys.
+// It also counts how many events were suppressed for each key since the last
+// allowed one. All methods are safe for concurrent use.
+type keyedLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	maxKeys int
+	lru     *list.List
+	items   map[string]*list.Element
+}
+
+type bucketEntry struct {
+	key        string
+	tokens     float64
+	last       time.Time
+	suppressed int
+}
+
+// newKeyedLimiter returns a limiter allowing rate events per second per key
+// with the given burst. Non-positive burst is treated as 1 and non-positive
+// maxKeys as DefaultMaxKeys.
+func newKeyedLimiter(rate float64, burst, maxKeys int) *keyedLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	return &keyedLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		maxKeys: maxKeys,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// allow reports whether an event for key may proceed at time now. When it
+// may, suppressed is the number of events refused for key since the previous
+// allowed event, and the counter is reset.
+func (l *keyedLimiter) allow(key string, now time.Time) (ok bool, suppressed int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var ent *bucketEntry
+	if el, found := l.items[key]; found {
+		l.lru.MoveToFront(el)
+		ent = el.Value.(*bucketEntry)
+		if elapsed := now.Sub(ent.last).Seconds(); elapsed > 0 {
+			ent.tokens += elapsed * l.rate
+			if ent.tokens > l.burst {
+				ent.tokens = l.burst
+			}
+		}
+		ent.last = now
+	} else {
+		ent = &bucketEntry{key: key, tokens: l.burst, last: now}
+		l.items[key] = l.lru.PushFront(ent)
+		for l.lru.Len() > l.maxKeys {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.items, oldest.Value.(*bucketEntry).key)
+		}
+	}
+
+	if ent.tokens < 1 {
+		ent.suppressed++
+		return false, 0
+	}
+	ent.tokens--
+	suppressed = ent.suppressed
+	ent.suppressed = 0
+	return true, suppressed
+}
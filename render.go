@@ -0,0 +1,292 @@
+package errorx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ConsoleOptions configures WriteConsole. The zero value renders every
+// frame of every layer without color.
+type ConsoleOptions struct {
+	// Color enables ANSI color escapes.
+	Color bool
+	// MaxFrames limits the frames printed per layer. Zero prints all of
+	// them; a negative value prints none.
+	MaxFrames int
+	// TrimPathPrefixes are removed from the front of file paths, for
+	// example a module root or GOPATH. The first matching prefix wins.
+	TrimPathPrefixes []string
+	// HideMetadata suppresses the metadata table.
+	HideMetadata bool
+	// ElideCommonFrames prints only the frames each layer does not share
+	// with the next inner layer, followed by a "... N more" line.
+	ElideCommonFrames bool
+}
+
+// LogfmtOptions configures WriteLogfmt. The zero value writes every frame
+// with unprefixed keys.
+type LogfmtOptions struct {
+	// KeyPrefix is prepended to every key, e.g. "error." yields
+	// error.message=... error.stack.0=....
+	KeyPrefix string
+	// MaxFrames limits the stack.N keys written. Zero writes all frames;
+	// a negative value writes none.
+	MaxFrames int
+	// TrimPathPrefixes are removed from the front of file paths.
+	TrimPathPrefixes []string
+}
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiDim   = "\x1b[2m"
+	ansiRed   = "\x1b[31m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// renderWriter wraps an io.Writer and remembers the first write error so
+// renderers can write unconditionally and check once at the end.
+type renderWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (rw *renderWriter) str(s string) {
+	if rw.err == nil {
+		_, rw.err = io.WriteString(rw.w, s)
+	}
+}
+
+func (rw *renderWriter) bytes(b []byte) {
+	if rw.err == nil {
+		_, rw.err = rw.w.Write(b)
+	}
+}
+
+func (rw *renderWriter) color(on bool, code, s string) {
+	if on {
+		rw.str(code)
+		rw.str(s)
+		rw.str(ansiReset)
+		return
+	}
+	rw.str(s)
+}
+
+// WriteConsole writes a human-readable, multi-line rendering of err to w:
+// the full message, then each layer of the Unwrap chain with its prefix,
+// aligned stack frames, and metadata table, ending with the root cause.
+// Errors without any *TraceError in their chain are rendered as a single
+// message line.
+func WriteConsole(w io.Writer, err error, opts ConsoleOptions) error {
+	rw := &renderWriter{w: w}
+	if err == nil {
+		rw.str("<nil>\n")
+		return rw.err
+	}
+	rw.color(opts.Color, ansiBold+ansiRed, "error: ")
+	rw.color(opts.Color, ansiBold, err.Error())
+	rw.str("\n")
+
+	var num [20]byte
+	depth := 0
+	for cur := err; cur != nil; cur = errors.Unwrap(cur) {
+		te, ok := cur.(*TraceError)
+		if !ok {
+			if errors.Unwrap(cur) != nil {
+				// Plain wrappers such as fmt.Errorf("%w") only add text.
+				continue
+			}
+			rw.str("  ")
+			rw.color(opts.Color, ansiDim, "cause ")
+			rw.str(reflect.TypeOf(cur).String())
+			rw.str(": ")
+			rw.str(cur.Error())
+			rw.str("\n")
+			break
+		}
+
+		rw.str("  [")
+		rw.bytes(strconv.AppendInt(num[:0], int64(depth), 10))
+		rw.str("] ")
+		if te.prefix != "" {
+			rw.color(opts.Color, ansiCyan, strconv.Quote(te.prefix))
+		} else {
+			rw.color(opts.Color, ansiDim, "(no prefix)")
+		}
+		rw.str("\n")
+		depth++
+
+		frames, common := te.StackFrames(), 0
+		if opts.ElideCommonFrames {
+			frames, common = uniqueFrames(te, innerLayer(te))
+		}
+		frames = limitFrames(frames, opts.MaxFrames)
+		width := 0
+		for _, f := range frames {
+			if n := len(f.FuncName()); n > width {
+				width = n
+			}
+		}
+		for _, f := range frames {
+			name := f.FuncName()
+			rw.str("      ")
+			rw.str(name)
+			rw.str(strings.Repeat(" ", width-len(name)+2))
+			rw.color(opts.Color, ansiDim, trimPath(f.File, opts.TrimPathPrefixes))
+			rw.str(":")
+			rw.bytes(strconv.AppendInt(num[:0], int64(f.LineNumber), 10))
+			rw.str("\n")
+		}
+		if common > 0 && opts.MaxFrames >= 0 {
+			rw.str("      ")
+			rw.color(opts.Color, ansiDim, "... "+strconv.Itoa(common)+" more")
+			rw.str("\n")
+		}
+
+		if !opts.HideMetadata {
+			writeMetadataTable(rw, te.Metadata(), opts.Color)
+		}
+	}
+	return rw.err
+}
+
+// writeMetadataTable renders a JSON object as aligned key/value rows, and
+// any other JSON value on a single line.
+func writeMetadataTable(rw *renderWriter, md *json.RawMessage, color bool) {
+	if md == nil {
+		return
+	}
+	rw.str("      ")
+	rw.color(color, ansiDim, "metadata")
+	rw.str("\n")
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(*md, &obj); err != nil {
+		rw.str("        ")
+		rw.bytes(*md)
+		rw.str("\n")
+		return
+	}
+	keys := make([]string, 0, len(obj))
+	width := 0
+	for k := range obj {
+		keys = append(keys, k)
+		if len(k) > width {
+			width = len(k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rw.str("        ")
+		rw.color(color, ansiCyan, k)
+		rw.str(strings.Repeat(" ", width-len(k)+2))
+		rw.bytes(obj[k])
+		rw.str("\n")
+	}
+}
+
+// WriteLogfmt writes err as space-separated logfmt pairs:
+//
+//	message="ctx: boom" type=*errors.errorString prefix=ctx cause=boom stack.0=main.run:main.go:12 ...
+//
+// Frames come from the outermost *TraceError in the chain. Metadata, when
+// present, is written as a single quoted JSON value. No trailing newline is
+// written so the output can be embedded in a larger log line.
+func WriteLogfmt(w io.Writer, err error, opts LogfmtOptions) error {
+	rw := &renderWriter{w: w}
+	if err == nil {
+		return nil
+	}
+	var te *TraceError
+	if !errors.As(err, &te) {
+		writeLogfmtPair(rw, opts.KeyPrefix, "message", "", err.Error(), false)
+		writeLogfmtPair(rw, opts.KeyPrefix, "type", "", reflect.TypeOf(err).String(), true)
+		return rw.err
+	}
+
+	writeLogfmtPair(rw, opts.KeyPrefix, "message", "", err.Error(), false)
+	if typ := te.Type(); typ != "" {
+		writeLogfmtPair(rw, opts.KeyPrefix, "type", "", typ, true)
+	}
+	if te.prefix != "" {
+		writeLogfmtPair(rw, opts.KeyPrefix, "prefix", "", te.prefix, true)
+	}
+	if c := te.Cause(); c != nil && c.Error() != err.Error() {
+		writeLogfmtPair(rw, opts.KeyPrefix, "cause", "", c.Error(), true)
+	}
+
+	var num [20]byte
+	var val []byte
+	for i, f := range limitFrames(te.StackFrames(), opts.MaxFrames) {
+		val = append(val[:0], f.FuncName()...)
+		val = append(val, ':')
+		val = append(val, trimPath(f.File, opts.TrimPathPrefixes)...)
+		val = append(val, ':')
+		val = strconv.AppendInt(val, int64(f.LineNumber), 10)
+		writeLogfmtPair(rw, opts.KeyPrefix, "stack.", string(strconv.AppendInt(num[:0], int64(i), 10)), string(val), true)
+	}
+	if md := te.Metadata(); md != nil {
+		var compact bytes.Buffer
+		if json.Compact(&compact, *md) == nil {
+			writeLogfmtPair(rw, opts.KeyPrefix, "metadata", "", compact.String(), true)
+		}
+	}
+	return rw.err
+}
+
+func writeLogfmtPair(rw *renderWriter, prefix, key, suffix, value string, space bool) {
+	if space {
+		rw.str(" ")
+	}
+	rw.str(prefix)
+	rw.str(key)
+	rw.str(suffix)
+	rw.str("=")
+	if !logfmtNeedsQuote(value) {
+		rw.str(value)
+		return
+	}
+	var buf [128]byte
+	rw.bytes(strconv.AppendQuote(buf[:0], value))
+}
+
+func logfmtNeedsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// limitFrames applies a MaxFrames option: zero keeps all frames and a
+// negative value keeps none.
+func limitFrames(frames []StackFrame, limit int) []StackFrame {
+	switch {
+	case limit < 0:
+		return nil
+	case limit > 0 && len(frames) > limit:
+		return frames[:limit]
+	}
+	return frames
+}
+
+// trimPath removes the first matching prefix from path.
+func trimPath(path string, prefixes []string) string {
+	for _, p := range prefixes {
+		if p != "" && strings.HasPrefix(path, p) {
+			return strings.TrimPrefix(path[len(p):], "/")
+		}
+	}
+	return path
+}
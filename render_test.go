@@ -0,0 +1,107 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func renderFixture(t *testing.T) error {
+	t.Helper()
+	inner := errorx.WrapPrefix(errors.New("disk full"), "write block", 0)
+	outer := errorx.WrapPrefix(fmt.Errorf("flush: %w", inner), "save", 0)
+	md := json.RawMessage(`{"user":"u1","attempt":3}`)
+	if err := outer.SetMetadata(&md); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+	return outer
+}
+
+func testDir(t *testing.T) string {
+	t.Helper()
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}
+
+func TestWriteConsole(t *testing.T) {
+	var buf bytes.Buffer
+	err := errorx.WriteConsole(&buf, renderFixture(t), errorx.ConsoleOptions{
+		MaxFrames:        2,
+		TrimPathPrefixes: []string{testDir(t)},
+	})
+	if err != nil {
+		t.Fatalf("WriteConsole: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"error: save: flush: write block: disk full\n",
+		`  [0] "save"`,
+		`  [1] "write block"`,
+		"render_test.go:",
+		"metadata\n",
+		`attempt  3`,
+		"cause *errors.errorString: disk full\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("console output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, testDir(t)) {
+		t.Errorf("path prefix not trimmed:\n%s", out)
+	}
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("color escapes without Color option:\n%s", out)
+	}
+
+	buf.Reset()
+	_ = errorx.WriteConsole(&buf, renderFixture(t), errorx.ConsoleOptions{Color: true})
+	if !strings.Contains(buf.String(), "\x1b[") {
+		t.Errorf("Color option produced no escapes")
+	}
+}
+
+func TestWriteLogfmt(t *testing.T) {
+	var buf bytes.Buffer
+	err := errorx.WriteLogfmt(&buf, renderFixture(t), errorx.LogfmtOptions{
+		KeyPrefix:        "error.",
+		MaxFrames:        2,
+		TrimPathPrefixes: []string{testDir(t)},
+	})
+	if err != nil {
+		t.Fatalf("WriteLogfmt: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`error.message="save: flush: write block: disk full"`,
+		` error.type=*fmt.wrapError`,
+		` error.prefix=save`,
+		` error.cause="flush: write block: disk full"`,
+		` error.stack.0=github.com/neumachen/errorx.WrapPrefix:`,
+		` error.stack.1=github.com/neumachen/errorx_test.renderFixture:render_test.go:`,
+		` error.metadata="{\"user\":\"u1\",\"attempt\":3}"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("logfmt output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "stack.2=") || strings.HasSuffix(out, "\n") {
+		t.Errorf("unexpected logfmt output:\n%s", out)
+	}
+}
+
+func TestWriteLogfmtPlainError(t *testing.T) {
+	var buf bytes.Buffer
+	if err := errorx.WriteLogfmt(&buf, errors.New("x"), errorx.LogfmtOptions{}); err != nil {
+		t.Fatalf("WriteLogfmt: %v", err)
+	}
+	if got, want := buf.String(), `message=x type=*errors.errorString`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
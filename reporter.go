@@ -0,0 +1,503 @@
+package errorx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reporter receives errors for out-of-band reporting, for example to an
+// error-tracking service. Implementations must be safe for concurrent use
+// and should not block the caller.
+type Reporter interface {
+	Report(err error)
+}
+
+// Clock abstracts time for components that batch or rate-limit, and for
+// construction timestamps (see SetClock). The zero configuration of every
+// component uses the system clock; tests may inject a fake implementation.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the Clock backed by package time.
+var SystemClock Clock = systemClock{}
+
+// Report is a single error occurrence delivered to a Sink.
+type Report struct {
+	// Time is when the error was handed to the Reporter.
+	Time time.Time `json:"time"`
+	// Key is the grouping key used for rate limiting.
+	Key string `json:"key"`
+	// Record is the structured form of the error.
+	Record Record `json:"error"`
+}
+
+// Sink delivers batches of reports to their destination. Send is only ever
+// called from a single goroutine per Dispatcher.
+type Sink interface {
+	Send(ctx context.Context, batch []Report) error
+}
+
+// GroupingKey returns a short stable key identifying errors that share the
+// same origin. For errors whose chain contains a *TraceError the key is
+// derived from its Type() and the top three stack frames; other errors are
+// grouped by their dynamic Go type alone. GroupingKey returns "" for nil.
+func GroupingKey(err error) string {
+	if err == nil {
+		return ""
+	}
+	h := fnv.New64a()
+	var te *TraceError
+	if errors.As(err, &te) {
+		_, _ = h.Write([]byte(te.Type()))
+		frames := te.StackFrames()
+		if len(frames) > 3 {
+			frames = frames[:3]
+		}
+		for _, f := range frames {
+			_, _ = h.Write([]byte{0})
+			_, _ = h.Write([]byte(f.Package))
+			_, _ = h.Write([]byte(f.Name))
+		}
+	} else {
+		_, _ = h.Write([]byte(reflect.TypeOf(err).String()))
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// DispatcherOptions configures a Dispatcher. The zero value of every field
+// selects a sensible default.
+type DispatcherOptions struct {
+	// Sinks receive every batch. A Dispatcher without sinks discards
+	// reports after accounting for them.
+	Sinks []Sink
+	// QueueSize bounds the number of reports waiting to be batched.
+	// Reports arriving while the queue is full are dropped. Default 1024.
+	QueueSize int
+	// BatchSize is the maximum number of reports per Send. Default 100.
+	BatchSize int
+	// FlushInterval is the longest a report waits in a partial batch.
+	// Default 5s.
+	FlushInterval time.Duration
+	// SendTimeout bounds each Sink.Send call, so that a stuck sink cannot
+	// hold up the Dispatcher. Default 30s.
+	SendTimeout time.Duration
+	// RateLimit is the sustained number of reports per second allowed per
+	// grouping key. Zero disables rate limiting.
+	RateLimit float64
+	// Burst is the number of reports per key allowed ahead of RateLimit.
+	// Default 1.
+	Burst int
+	// MaxKeys bounds the number of grouping keys whose rate-limit state is
+	// retained. Default DefaultMaxKeys.
+	MaxKeys int
+	// SampleRate is the fraction of reports kept, in (0, 1]. Zero keeps
+	// everything.
+	SampleRate float64
+	// KeyFunc derives the grouping key. Default GroupingKey.
+	KeyFunc func(error) string
+	// Clock is the time source. Default SystemClock.
+	Clock Clock
+	// Rand returns a pseudo-random number in [0, 1) for sampling. Default
+	// math/rand/v2.Float64.
+	Rand func() float64
+	// OnSinkError is called with errors returned by sinks. Errors are
+	// otherwise counted and discarded.
+	OnSinkError func(error)
+}
+
+// DispatcherStats is a snapshot of a Dispatcher's counters.
+type DispatcherStats struct {
+	// Accepted counts reports that were queued.
+	Accepted uint64
+	// Sent counts reports delivered to sinks, including ones a sink
+	// subsequently failed on.
+	Sent uint64
+	// Dropped counts reports refused because the queue was full or the
+	// Dispatcher was closed.
+	Dropped uint64
+	// RateLimited counts reports refused by the per-key rate limit.
+	RateLimited uint64
+	// Sampled counts reports discarded by sampling.
+	Sampled uint64
+	// SinkErrors counts failed Send calls.
+	SinkErrors uint64
+}
+
+// Dispatcher is an asynchronous Reporter. Report is non-blocking: reports
+// are sampled, rate-limited per grouping key, and placed on a bounded queue
+// that a single background goroutine drains into batches for the configured
+// sinks. Create one with NewDispatcher and release it with Shutdown or
+// Close.
+type Dispatcher struct {
+	sinks       []Sink
+	batchSize   int
+	interval    time.Duration
+	sendTimeout time.Duration
+	sampleRate  float64
+	keyFunc     func(error) string
+	clock       Clock
+	rand        func() float64
+	onSinkError func(error)
+	limiter     *keyedLimiter
+
+	queue   chan queuedReport
+	flushes chan flushRequest
+	quit    chan struct{}
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	// shutdownCtx bounds the final drain; it is set before quit is closed.
+	shutdownCtx context.Context
+
+	accepted, sent, dropped, rateLimited, sampled, sinkErrors atomic.Uint64
+}
+
+type queuedReport struct {
+	err error
+	at  time.Time
+	key string
+}
+
+type flushRequest struct {
+	ctx  context.Context
+	done chan error
+}
+
+// NewDispatcher starts a Dispatcher configured by opts.
+func NewDispatcher(opts DispatcherOptions) *Dispatcher {
+	d := &Dispatcher{
+		sinks:       append([]Sink(nil), opts.Sinks...),
+		batchSize:   opts.BatchSize,
+		interval:    opts.FlushInterval,
+		sendTimeout: opts.SendTimeout,
+		sampleRate:  opts.SampleRate,
+		keyFunc:     opts.KeyFunc,
+		clock:       opts.Clock,
+		rand:        opts.Rand,
+		onSinkError: opts.OnSinkError,
+		flushes:     make(chan flushRequest),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1024
+	}
+	d.queue = make(chan queuedReport, queueSize)
+	if d.batchSize <= 0 {
+		d.batchSize = 100
+	}
+	if d.interval <= 0 {
+		d.interval = 5 * time.Second
+	}
+	if d.sendTimeout <= 0 {
+		d.sendTimeout = 30 * time.Second
+	}
+	if d.keyFunc == nil {
+		d.keyFunc = GroupingKey
+	}
+	if d.clock == nil {
+		d.clock = SystemClock
+	}
+	if d.rand == nil {
+		d.rand = rand.Float64
+	}
+	if opts.RateLimit > 0 {
+		d.limiter = newKeyedLimiter(opts.RateLimit, opts.Burst, opts.MaxKeys)
+	}
+	go d.run()
+	return d
+}
+
+// Report queues err for delivery. It never blocks; reports that are sampled
+// out, rate-limited, or arrive while the queue is full are counted and
+// discarded. Report ignores nil errors.
+func (d *Dispatcher) Report(err error) {
+	if err == nil {
+		return
+	}
+	if d.sampleRate > 0 && d.sampleRate < 1 && d.rand() >= d.sampleRate {
+		d.sampled.Add(1)
+		return
+	}
+	now := d.clock.Now()
+	key := d.keyFunc(err)
+	if d.limiter != nil {
+		if ok, _ := d.limiter.allow(key, now); !ok {
+			d.rateLimited.Add(1)
+			return
+		}
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		d.dropped.Add(1)
+		return
+	}
+	select {
+	case d.queue <- queuedReport{err: err, at: now, key: key}:
+		d.accepted.Add(1)
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+// Flush delivers every report queued before the call and waits for the
+// sinks to return, or for ctx to be done. It returns the first sink error
+// encountered during the flush, or ctx.Err().
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	req := flushRequest{ctx: ctx, done: make(chan error, 1)}
+	select {
+	case d.flushes <- req:
+	case <-d.done:
+		return errors.New("errorx: Dispatcher is closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting reports, delivers everything still queued, and
+// waits for the background goroutine to exit, or for ctx to be done. Sends
+// still in progress when ctx is done are canceled and the reports not yet
+// sent are counted as dropped; Shutdown then returns ctx.Err() without
+// waiting further for sinks that ignore cancellation. Only the first call
+// sets the deadline of the final delivery; later calls just wait.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		d.shutdownCtx = ctx
+		close(d.quit)
+	}
+	d.mu.Unlock()
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close is Shutdown without a deadline; each send is still bounded by
+// DispatcherOptions.SendTimeout. Close is idempotent.
+func (d *Dispatcher) Close() error {
+	return d.Shutdown(context.Background())
+}
+
+// Stats returns a snapshot of the Dispatcher's counters.
+func (d *Dispatcher) Stats() DispatcherStats {
+	return DispatcherStats{
+		Accepted:    d.accepted.Load(),
+		Sent:        d.sent.Load(),
+		Dropped:     d.dropped.Load(),
+		RateLimited: d.rateLimited.Load(),
+		Sampled:     d.sampled.Load(),
+		SinkErrors:  d.sinkErrors.Load(),
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	batch := make([]Report, 0, d.batchSize)
+	tick := d.clock.After(d.interval)
+
+	// add appends one queued report and sends the batch once it is full.
+	add := func(ctx context.Context, q queuedReport) error {
+		batch = append(batch, Report{Time: q.at, Key: q.key, Record: recordOf(q.err)})
+		if len(batch) < d.batchSize {
+			return nil
+		}
+		err := d.send(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+	// drain moves everything currently queued into batches.
+	drain := func(ctx context.Context) error {
+		var first error
+		for {
+			select {
+			case q := <-d.queue:
+				if err := add(ctx, q); err != nil && first == nil {
+					first = err
+				}
+			default:
+				if err := d.send(ctx, batch); err != nil && first == nil {
+					first = err
+				}
+				batch = batch[:0]
+				return first
+			}
+		}
+	}
+
+	for {
+		select {
+		case q := <-d.queue:
+			_ = add(context.Background(), q)
+		case <-tick:
+			_ = d.send(context.Background(), batch)
+			batch = batch[:0]
+			tick = d.clock.After(d.interval)
+		case req := <-d.flushes:
+			req.done <- drain(req.ctx)
+		case <-d.quit:
+			_ = drain(d.shutdownCtx)
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, batch []Report) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		d.dropped.Add(uint64(len(batch)))
+		return err
+	}
+	d.sent.Add(uint64(len(batch)))
+	var first error
+	for _, s := range d.sinks {
+		// Each sink gets its own copy so it may retain the slice.
+		out := append([]Report(nil), batch...)
+		if err := d.sendTo(ctx, s, out); err != nil {
+			d.sinkErrors.Add(1)
+			if d.onSinkError != nil {
+				d.onSinkError(err)
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// sendTo calls s.Send bounded by the send timeout.
+func (d *Dispatcher) sendTo(ctx context.Context, s Sink, batch []Report) error {
+	ctx, cancel := context.WithTimeout(ctx, d.sendTimeout)
+	defer cancel()
+	return s.Send(ctx, batch)
+}
+
+// recordOf returns the Record for err, using the first *TraceError in its
+// chain when there is one.
+func recordOf(err error) Record {
+	var te *TraceError
+	if errors.As(err, &te) {
+		r := te.Record()
+		// An outer non-TraceError wrapper may have added context.
+		r.Message = err.Error()
+		return r
+	}
+	return Record{Message: err.Error(), Type: reflect.TypeOf(err).String()}
+}
+
+var panicReporter atomic.Pointer[Reporter]
+
+// SetPanicReporter installs r as the Reporter that FromPanic hands every
+// constructed error to. Passing nil disables panic reporting.
+func SetPanicReporter(r Reporter) {
+	if r == nil {
+		panicReporter.Store(nil)
+		return
+	}
+	panicReporter.Store(&r)
+}
+
+func reportPanic(te *TraceError) {
+	if r := panicReporter.Load(); r != nil {
+		(*r).Report(te)
+	}
+}
+
+// HTTPMiddleware returns a handler that recovers panics raised by next,
+// reports them to r as FromPanic errors, and replies with 500 Internal
+// Server Error unless next already started the response. http.ErrAbortHandler
+// is re-panicked untouched so that net/http can abort the response as
+// intended.
+func HTTPMiddleware(r Reporter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		w := &responseWriter{ResponseWriter: rw}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			te := fromPanic(v, debug.Stack())
+			constructed(te, 0)
+			r.Report(te)
+			if !w.wrote {
+				http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// responseWriter records whether the response was started. Unwrap lets
+// http.ResponseController reach the optional interfaces of the original
+// writer.
+type responseWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	// Informational headers do not start the response.
+	if code >= 200 {
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher for handlers that assert it directly.
+func (w *responseWriter) Flush() {
+	w.wrote = true
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker for handlers that assert it directly.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.wrote = true
+	}
+	return conn, rw, err
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,325 @@
+package errorx_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neumachen/errorx"
+)
+
+// fakeSink records every batch it receives.
+type fakeSink struct {
+	mu      sync.Mutex
+	batches [][]errorx.Report
+	err     error
+}
+
+func (s *fakeSink) Send(_ context.Context, batch []errorx.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, batch)
+	return s.err
+}
+
+func (s *fakeSink) reports() []errorx.Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []errorx.Report
+	for _, b := range s.batches {
+		out = append(out, b...)
+	}
+	return out
+}
+
+func (s *fakeSink) batchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+func TestDispatcherBatchesBySize(t *testing.T) {
+	sink := &fakeSink{}
+	d := errorx.NewDispatcher(errorx.DispatcherOptions{
+		Sinks:     []errorx.Sink{sink},
+		BatchSize: 2,
+		Clock:     newFakeClock(),
+	})
+	for i := 0; i < 5; i++ {
+		d.Report(errorx.Errorf("boom %d", i))
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got := sink.batchCount(); got != 3 {
+		t.Errorf("batches = %d, want 3", got)
+	}
+	reports := sink.reports()
+	if len(reports) != 5 {
+		t.Fatalf("reports = %d, want 5", len(reports))
+	}
+	if reports[0].Record.Message != "boom 0" {
+		t.Errorf("first message = %q", reports[0].Record.Message)
+	}
+	if reports[0].Key == "" {
+		t.Errorf("grouping key is empty")
+	}
+	if st := d.Stats(); st.Accepted != 5 || st.Sent != 5 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestDispatcherFlushesOnInterval(t *testing.T) {
+	clock := newFakeClock()
+	sink := &fakeSink{}
+	d := errorx.NewDispatcher(errorx.DispatcherOptions{
+		Sinks:         []errorx.Sink{sink},
+		FlushInterval: time.Second,
+		Clock:         clock,
+	})
+	defer d.Close()
+
+	clock.waitForWaiters(t, 1)
+	d.Report(errorx.Errorf("boom"))
+	// The report may still be queued when the first tick fires, so keep
+	// ticking until it has been delivered.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.reports()) == 0 && time.Now().Before(deadline) {
+		clock.Advance(time.Second)
+		clock.waitForWaiters(t, 1)
+	}
+	if got := len(sink.reports()); got != 1 {
+		t.Fatalf("reports after interval = %d, want 1", got)
+	}
+}
+
+func TestDispatcherFlush(t *testing.T) {
+	sink := &fakeSink{err: errors.New("sink down")}
+	var sinkErrs int
+	d := errorx.NewDispatcher(errorx.DispatcherOptions{
+		Sinks:       []errorx.Sink{sink},
+		Clock:       newFakeClock(),
+		OnSinkError: func(error) { sinkErrs++ },
+	})
+	defer d.Close()
+
+	d.Report(errorx.Errorf("boom"))
+	if err := d.Flush(context.Background()); err == nil || err.Error() != "sink down" {
+		t.Errorf("Flush error = %v, want sink down", err)
+	}
+	if got := len(sink.reports()); got != 1 {
+		t.Errorf("reports = %d, want 1", got)
+	}
+	if st := d.Stats(); st.SinkErrors != 1 || sinkErrs != 1 {
+		t.Errorf("sink errors = %d (callback %d), want 1", st.SinkErrors, sinkErrs)
+	}
+}
+
+func TestDispatcherRateLimitsPerKey(t *testing.T) {
+	clock := newFakeClock()
+	sink := &fakeSink{}
+	d := errorx.NewDispatcher(errorx.DispatcherOptions{
+		Sinks:     []errorx.Sink{sink},
+		RateLimit: 1,
+		Burst:     2,
+		Clock:     clock,
+		KeyFunc:   func(err error) string { return err.Error() },
+	})
+	for i := 0; i < 5; i++ {
+		d.Report(errors.New("a"))
+	}
+	d.Report(errors.New("b"))
+	clock.Advance(time.Second)
+	d.Report(errors.New("a"))
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	st := d.Stats()
+	if st.Accepted != 4 {
+		t.Errorf("accepted = %d, want 4 (2 burst a, 1 b, 1 refill a)", st.Accepted)
+	}
+	if st.RateLimited != 3 {
+		t.Errorf("rate limited = %d, want 3", st.RateLimited)
+	}
+}
+
+func TestDispatcherSampling(t *testing.T) {
+	sink := &fakeSink{}
+	rolls := []float64{0.1, 0.9, 0.4, 0.6}
+	var i int
+	d := errorx.NewDispatcher(errorx.DispatcherOptions{
+		Sinks:      []errorx.Sink{sink},
+		SampleRate: 0.5,
+		Clock:      newFakeClock(),
+		Rand: func() float64 {
+			r := rolls[i%len(rolls)]
+			i++
+			return r
+		},
+	})
+	for range rolls {
+		d.Report(errors.New("x"))
+	}
+	_ = d.Close()
+	if st := d.Stats(); st.Accepted != 2 || st.Sampled != 2 {
+		t.Errorf("stats = %+v, want 2 accepted and 2 sampled", st)
+	}
+}
+
+func TestDispatcherDropsWhenQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	sink := &blockingSink{entered: make(chan struct{}), block: block}
+	d := errorx.NewDispatcher(errorx.DispatcherOptions{
+		Sinks:     []errorx.Sink{sink},
+		QueueSize: 1,
+		BatchSize: 1,
+		Clock:     newFakeClock(),
+	})
+	// The first report occupies the worker; the next fills the queue.
+	d.Report(errors.New("1"))
+	<-sink.entered
+	d.Report(errors.New("2"))
+	d.Report(errors.New("3"))
+	close(block)
+	_ = d.Close()
+
+	st := d.Stats()
+	if st.Dropped != 1 {
+		t.Errorf("dropped = %d, want 1", st.Dropped)
+	}
+	d.Report(errors.New("after close"))
+	if got := d.Stats().Dropped; got != 2 {
+		t.Errorf("dropped after close = %d, want 2", got)
+	}
+}
+
+type blockingSink struct {
+	once    sync.Once
+	entered chan struct{}
+	block   chan struct{}
+}
+
+func (s *blockingSink) Send(context.Context, []errorx.Report) error {
+	s.once.Do(func() { close(s.entered) })
+	<-s.block
+	return nil
+}
+
+// stuckSink blocks until its context is done, or, when it ignores
+// cancellation, until release is closed.
+type stuckSink struct {
+	ignoreCtx bool
+	release   chan struct{}
+}
+
+func (s *stuckSink) Send(ctx context.Context, _ []errorx.Report) error {
+	if s.ignoreCtx {
+		<-s.release
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDispatcherSendTimeout(t *testing.T) {
+	d := errorx.NewDispatcher(errorx.DispatcherOptions{
+		Sinks:       []errorx.Sink{&stuckSink{}},
+		SendTimeout: 10 * time.Millisecond,
+		Clock:       newFakeClock(),
+	})
+	d.Report(errorx.Errorf("boom"))
+	if err := d.Flush(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flush error = %v, want the send timeout", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if st := d.Stats(); st.SinkErrors != 1 {
+		t.Errorf("sink errors = %d, want 1", st.SinkErrors)
+	}
+}
+
+func TestDispatcherShutdownDeadline(t *testing.T) {
+	sink := &stuckSink{ignoreCtx: true, release: make(chan struct{})}
+	defer close(sink.release)
+	d := errorx.NewDispatcher(errorx.DispatcherOptions{Sinks: []errorx.Sink{sink}, Clock: newFakeClock()})
+	d.Report(errorx.Errorf("boom"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown error = %v, want the deadline", err)
+	}
+}
+
+func TestGroupingKey(t *testing.T) {
+	mk := func() error { return errorx.Errorf("boom") }
+	a, b := mk(), mk()
+	if errorx.GroupingKey(a) != errorx.GroupingKey(b) {
+		t.Errorf("same call site produced different keys")
+	}
+	if errorx.GroupingKey(a) == errorx.GroupingKey(errorx.Errorf("elsewhere")) {
+		t.Errorf("different call sites produced the same key")
+	}
+	if errorx.GroupingKey(nil) != "" {
+		t.Errorf("GroupingKey(nil) is not empty")
+	}
+}
+
+type reporterFunc func(error)
+
+func (f reporterFunc) Report(err error) { f(err) }
+
+func TestSetPanicReporter(t *testing.T) {
+	var got error
+	errorx.SetPanicReporter(reporterFunc(func(err error) { got = err }))
+	defer errorx.SetPanicReporter(nil)
+
+	te := errorx.FromPanic("kaboom", nil)
+	if got != te {
+		t.Errorf("reported %v, want the FromPanic error", got)
+	}
+}
+
+func TestHTTPMiddlewareReportsPanics(t *testing.T) {
+	var got error
+	h := errorx.HTTPMiddleware(reporterFunc(func(err error) { got = err }),
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("handler exploded") }))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	var te *errorx.TraceError
+	if !errors.As(got, &te) || te.Type() != "panic" || te.Error() != "handler exploded" {
+		t.Errorf("reported %v, want panic error", got)
+	}
+
+	got = nil
+	h = errorx.HTTPMiddleware(reporterFunc(func(err error) { got = err }),
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte("partial"))
+			panic("after writing")
+		}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "partial" || got == nil {
+		t.Errorf("panic after writing: status %d, body %q, reported %v", rec.Code, rec.Body.String(), got)
+	}
+
+	h = errorx.HTTPMiddleware(reporterFunc(func(error) {}), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("the middleware hides http.Flusher")
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,83 @@
+package errorx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// WriterSink is a Sink that writes each report as one line of JSON.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a Sink that writes newline-delimited JSON reports
+// to w. Writes are serialized, so w need not be safe for concurrent use.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Send implements Sink.
+func (s *WriterSink) Send(_ context.Context, batch []Report) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range batch {
+		if err := enc.Encode(&batch[i]); err != nil {
+			return fmt.Errorf("errorx: WriterSink: %w", err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("errorx: WriterSink: %w", err)
+	}
+	return nil
+}
+
+// HTTPSink is a Sink that POSTs each batch as a JSON array to a URL.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+	header http.Header
+}
+
+// NewHTTPSink returns a Sink posting to url with client. A nil client
+// selects http.DefaultClient. Extra request headers, such as authorization,
+// may be supplied in header.
+func NewHTTPSink(url string, client *http.Client, header http.Header) *HTTPSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPSink{url: url, client: client, header: header.Clone()}
+}
+
+// Send implements Sink. Any response status outside 2xx is an error.
+func (s *HTTPSink) Send(ctx context.Context, batch []Report) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("errorx: HTTPSink: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("errorx: HTTPSink: %w", err)
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("errorx: HTTPSink: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("errorx: HTTPSink: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package errorx_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func testReports() []errorx.Report {
+	te := errorx.WrapPrefix(errorx.Errorf("root"), "ctx", 0).(*errorx.TraceError)
+	return []errorx.Report{
+		{Key: "k1", Record: te.Record()},
+		{Key: "k2", Record: errorx.Record{Message: "plain"}},
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	if err := errorx.NewWriterSink(&buf).Send(context.Background(), testReports()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	sc := bufio.NewScanner(&buf)
+	var lines int
+	for sc.Scan() {
+		var r errorx.Report
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("line %d is not a JSON report: %v", lines, err)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("lines = %d, want 2", lines)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var got []errorx.Report
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	header := http.Header{"Authorization": {"Bearer t"}}
+	sink := errorx.NewHTTPSink(srv.URL, srv.Client(), header)
+	if err := sink.Send(context.Background(), testReports()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(got) != 2 || got[0].Record.Message != "ctx: root" {
+		t.Errorf("server received %+v", got)
+	}
+	if auth != "Bearer t" {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
+
+func TestHTTPSinkStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := errorx.NewHTTPSink(srv.URL, nil, nil).Send(context.Background(), testReports())
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Send error = %v, want status 503", err)
+	}
+}
@@ -0,0 +1,247 @@
+package errorx
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// LogHandlerOptions configures a LogHandler.
+type LogHandlerOptions struct {
+	// StackLevel is the minimum record level at which expanded errors
+	// include their stack frames. Below it only the message, type, prefix,
+	// cause and metadata are logged. Default slog.LevelError.
+	StackLevel slog.Leveler
+	// TopLevelAttrs adds "error.type" and "error.origin" attributes at the
+	// top level of the record, outside any group opened with WithGroup, for
+	// the first *TraceError found, so that they can be indexed without
+	// descending into the error group. error.origin is the
+	// package-qualified function of the first frame outside this package.
+	TopLevelAttrs bool
+	// LogOptions shapes the expanded errors. Nil selects the package-wide
+	// defaults at the time each record is handled.
+	LogOptions *LogOptions
+}
+
+// LogHandler is an slog.Handler middleware that expands error attributes
+// whose chain contains a *TraceError into the structured form produced by
+// LogValue, at any key and inside groups, and chooses stack verbosity by
+// record level. All other attributes pass through unchanged.
+type LogHandler struct {
+	next slog.Handler
+	opts LogHandlerOptions
+
+	// pending holds WithGroup/WithAttrs calls that could not be applied to
+	// next yet: an attribute carries a TraceError whose expansion depends
+	// on the level of the record being handled, or, with TopLevelAttrs, a
+	// group is open that the error attributes must stay out of.
+	pending []handlerOp
+	derived *atomic.Pointer[derivedHandler]
+}
+
+type handlerOp struct {
+	group string
+	attrs []slog.Attr
+}
+
+// derivedHandler is next with the pending operations applied, built once
+// per LogHandler for each of the two stack verbosities and rebuilt only
+// when the package-wide LogOptions it was built from change.
+type derivedHandler struct {
+	src  *LogOptions
+	once [2]sync.Once
+	// root is next with the leading operations applied. With
+	// TopLevelAttrs, rest holds the operations from the first group on,
+	// with expanded attributes, which Handle folds into each record so
+	// that error.type and error.origin stay at the top level.
+	root  [2]slog.Handler
+	rest  [2][]handlerOp
+	first [2]*TraceError
+}
+
+// NewLogHandler returns a LogHandler that forwards to next. A nil opts is
+// equivalent to the zero LogHandlerOptions.
+func NewLogHandler(next slog.Handler, opts *LogHandlerOptions) *LogHandler {
+	h := &LogHandler{next: next}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.StackLevel == nil {
+		h.opts.StackLevel = slog.LevelError
+	}
+	return h
+}
+
+// Enabled implements slog.Handler.
+func (h *LogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	src := h.opts.LogOptions
+	if src == nil {
+		src = logOptions.Load()
+	}
+	opts := StandardLogOptions()
+	if src != nil {
+		opts = *src
+	}
+	verbose := 1
+	if r.Level < h.opts.StackLevel.Level() {
+		opts.MaxFrames = -1
+		verbose = 0
+	}
+
+	next, rest, first := h.next, []handlerOp(nil), (*TraceError)(nil)
+	if len(h.pending) > 0 {
+		d := h.derived.Load()
+		if d == nil || d.src != src {
+			d = &derivedHandler{src: src}
+			h.derived.Store(d)
+		}
+		d.once[verbose].Do(func() { h.derive(d, verbose, opts) })
+		next, rest, first = d.root[verbose], d.rest[verbose], d.first[verbose]
+	}
+
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	attrs := make([]slog.Attr, 0, r.NumAttrs()+2)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, expandAttr(a, opts, &first))
+		return true
+	})
+	attrs = foldOps(rest, attrs)
+	if h.opts.TopLevelAttrs && first != nil {
+		attrs = append(attrs, slog.String("error.type", first.Type()))
+		if origin := inAppFrame(first.resolvedFrames()); origin.Name != "" {
+			attrs = append(attrs, slog.String("error.origin", origin.FuncName()))
+		}
+	}
+	out.AddAttrs(attrs...)
+	return next.Handle(ctx, out)
+}
+
+// derive fills d for one stack verbosity.
+func (h *LogHandler) derive(d *derivedHandler, verbose int, opts LogOptions) {
+	next := h.next
+	var first *TraceError
+	for i, op := range h.pending {
+		if op.group != "" && h.opts.TopLevelAttrs {
+			rest := make([]handlerOp, len(h.pending)-i)
+			for j, op := range h.pending[i:] {
+				rest[j] = handlerOp{group: op.group, attrs: expandAttrs(op.attrs, opts, &first)}
+			}
+			d.rest[verbose] = rest
+			break
+		}
+		if op.group != "" {
+			next = next.WithGroup(op.group)
+			continue
+		}
+		next = next.WithAttrs(expandAttrs(op.attrs, opts, &first))
+	}
+	d.root[verbose], d.first[verbose] = next, first
+}
+
+// foldOps nests attrs inside the groups of ops, after the attributes ops
+// bind at each level, as next.WithGroup and next.WithAttrs would have.
+func foldOps(ops []handlerOp, attrs []slog.Attr) []slog.Attr {
+	for i := len(ops) - 1; i >= 0; i-- {
+		if ops[i].group != "" {
+			attrs = []slog.Attr{{Key: ops[i].group, Value: slog.GroupValue(attrs...)}}
+			continue
+		}
+		attrs = append(slices.Clip(ops[i].attrs), attrs...)
+	}
+	return attrs
+}
+
+// WithAttrs implements slog.Handler.
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	if len(h.pending) == 0 && !containsTraceError(attrs) {
+		return &LogHandler{next: h.next.WithAttrs(attrs), opts: h.opts}
+	}
+	return h.withOp(handlerOp{attrs: attrs})
+}
+
+// WithGroup implements slog.Handler.
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	if len(h.pending) == 0 && !h.opts.TopLevelAttrs {
+		return &LogHandler{next: h.next.WithGroup(name), opts: h.opts}
+	}
+	return h.withOp(handlerOp{group: name})
+}
+
+func (h *LogHandler) withOp(op handlerOp) *LogHandler {
+	pending := make([]handlerOp, len(h.pending), len(h.pending)+1)
+	copy(pending, h.pending)
+	return &LogHandler{next: h.next, opts: h.opts, pending: append(pending, op), derived: new(atomic.Pointer[derivedHandler])}
+}
+
+// traceErrorOf returns the error held by v and the first *TraceError in its
+// chain, if v holds an error at all.
+func traceErrorOf(v slog.Value) (error, *TraceError) {
+	err := errorOf(v)
+	if err == nil {
+		return nil, nil
+	}
+	var te *TraceError
+	if !errors.As(err, &te) || te == nil {
+		return nil, nil
+	}
+	return err, te
+}
+
+// errorOf returns the error held by v, or nil.
+func errorOf(v slog.Value) error {
+	if v.Kind() != slog.KindAny && v.Kind() != slog.KindLogValuer {
+		return nil
+	}
+	err, _ := v.Any().(error)
+	return err
+}
+
+func containsTraceError(attrs []slog.Attr) bool {
+	for _, a := range attrs {
+		if _, te := traceErrorOf(a.Value); te != nil {
+			return true
+		}
+		if a.Value.Kind() == slog.KindGroup && containsTraceError(a.Value.Group()) {
+			return true
+		}
+	}
+	return false
+}
+
+func expandAttrs(attrs []slog.Attr, opts LogOptions, first **TraceError) []slog.Attr {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = expandAttr(a, opts, first)
+	}
+	return out
+}
+
+// expandAttr replaces a TraceError-carrying value with its structured form
+// and descends into groups. first records the first TraceError seen.
+func expandAttr(a slog.Attr, opts LogOptions, first **TraceError) slog.Attr {
+	if err, te := traceErrorOf(a.Value); te != nil {
+		if *first == nil {
+			*first = te
+		}
+		return slog.Attr{Key: a.Key, Value: te.logValueOf(err, opts)}
+	}
+	v := a.Value.Resolve()
+	if v.Kind() != slog.KindGroup {
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+	return slog.Attr{Key: a.Key, Value: slog.GroupValue(expandAttrs(v.Group(), opts, first)...)}
+}
@@ -0,0 +1,144 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+
+	"github.com/neumachen/errorx"
+)
+
+func TestLogHandlerSlogtest(t *testing.T) {
+	var buf bytes.Buffer
+	newHandler := func(t *testing.T) slog.Handler {
+		buf.Reset()
+		return errorx.NewLogHandler(slog.NewJSONHandler(&buf, nil), nil)
+	}
+	result := func(t *testing.T) map[string]any {
+		var m map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatalf("unmarshal %q: %v", buf.String(), err)
+		}
+		return m
+	}
+	slogtest.Run(t, newHandler, result)
+}
+
+func TestLogHandlerSlogtestTopLevelAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	newHandler := func(t *testing.T) slog.Handler {
+		buf.Reset()
+		return errorx.NewLogHandler(slog.NewJSONHandler(&buf, nil), &errorx.LogHandlerOptions{TopLevelAttrs: true})
+	}
+	result := func(t *testing.T) map[string]any {
+		var m map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatalf("unmarshal %q: %v", buf.String(), err)
+		}
+		return m
+	}
+	slogtest.Run(t, newHandler, result)
+}
+
+func TestLogHandlerDerivedOnce(t *testing.T) {
+	var buf bytes.Buffer
+	var derived int
+	inner := &countingHandler{Handler: slog.NewJSONHandler(&buf, nil), derived: &derived}
+	l := slog.New(errorx.NewLogHandler(inner, nil)).With("err", errorx.Errorf("bound")).WithGroup("g")
+	for range 3 {
+		l.Error("e", "k", "v")
+		l.Info("i", "k", "v")
+	}
+	if derived != 4 {
+		t.Errorf("WithAttrs/WithGroup reached next %d times for 6 records, want 4 (2 ops x 2 verbosities)", derived)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 || !strings.Contains(lines[0], `"stack_frames"`) || strings.Contains(lines[1], `"stack_frames"`) {
+		t.Errorf("output:\n%s", buf.String())
+	}
+}
+
+// countingHandler counts the handlers derived from it and its descendants.
+type countingHandler struct {
+	slog.Handler
+	derived *int
+}
+
+func (h *countingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	*h.derived++
+	return &countingHandler{Handler: h.Handler.WithAttrs(attrs), derived: h.derived}
+}
+
+func (h *countingHandler) WithGroup(name string) slog.Handler {
+	*h.derived++
+	return &countingHandler{Handler: h.Handler.WithGroup(name), derived: h.derived}
+}
+
+func handlerLog(t *testing.T, opts *errorx.LogHandlerOptions, log func(*slog.Logger)) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	log(slog.New(errorx.NewLogHandler(slog.NewJSONHandler(&buf, nil), opts)))
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("unmarshal %q: %v", buf.String(), err)
+	}
+	return m
+}
+
+func TestLogHandlerStackByLevel(t *testing.T) {
+	err := errorx.WrapPrefix(fmt.Errorf("root"), "ctx", 0)
+
+	warn := handlerLog(t, nil, func(l *slog.Logger) { l.Warn("w", "failure", err) })
+	group, ok := warn["failure"].(map[string]any)
+	if !ok {
+		t.Fatalf("failure = %T, want group", warn["failure"])
+	}
+	if group["message"] != "ctx: root" {
+		t.Errorf("message = %v", group["message"])
+	}
+	if _, ok := group["stack_frames"]; ok {
+		t.Errorf("Warn record includes stack_frames")
+	}
+
+	errRec := handlerLog(t, nil, func(l *slog.Logger) { l.Error("e", "failure", err) })
+	if _, ok := errRec["failure"].(map[string]any)["stack_frames"]; !ok {
+		t.Errorf("Error record lacks stack_frames")
+	}
+}
+
+func TestLogHandlerNestedAndWrapped(t *testing.T) {
+	// The error is wrapped by fmt.Errorf and bound via With inside a group,
+	// so the handler must both see through the wrapper and defer expansion
+	// until the record level is known.
+	err := fmt.Errorf("outer: %w", errorx.Errorf("inner"))
+	m := handlerLog(t, &errorx.LogHandlerOptions{TopLevelAttrs: true}, func(l *slog.Logger) {
+		l.WithGroup("req").With("err", err).Info("i", slog.Group("sub", slog.Any("e", err)))
+	})
+
+	req := m["req"].(map[string]any)
+	bound, ok := req["err"].(map[string]any)
+	if !ok || bound["message"] != "outer: inner" {
+		t.Errorf("bound err = %v", req["err"])
+	}
+	sub := req["sub"].(map[string]any)
+	if e, ok := sub["e"].(map[string]any); !ok || e["type"] != "*errors.errorString" {
+		t.Errorf("nested err = %v", sub["e"])
+	}
+	if m["error.type"] != "*errors.errorString" || req["error.type"] != nil {
+		t.Errorf("error.type = %v, inside the group %v, want it at the top level only", m["error.type"], req["error.type"])
+	}
+	if origin, _ := m["error.origin"].(string); !strings.HasSuffix(origin, "TestLogHandlerNestedAndWrapped") {
+		t.Errorf("error.origin = %v", m["error.origin"])
+	}
+}
+
+func TestLogHandlerPassesThroughPlainErrors(t *testing.T) {
+	m := handlerLog(t, nil, func(l *slog.Logger) { l.Error("e", "err", fmt.Errorf("plain")) })
+	if m["err"] != "plain" {
+		t.Errorf("err = %v", m["err"])
+	}
+}
@@ -25,31 +25,86 @@ type StackFrame struct {
 	// ProgramCounter is the raw runtime program counter for the frame.
 	// It may be zero for frames that were parsed from a text stack trace.
 	ProgramCounter uintptr `json:"program_counter"`
+	// Inlined reports that the compiler inlined the function into its
+	// caller, so the frame has no physical stack frame of its own.
+	Inlined bool `json:"inlined,omitempty"`
 }
 
-// NewStackFrame builds a StackFrame from a program counter. Frames whose
-// program counter does not resolve to a known function are returned with
-// only ProgramCounter populated.
+// NewStackFrame builds the innermost StackFrame for a program counter, as
+// recorded by runtime.Callers. For a PC inside inlined code it is the
+// inlined function's frame; NewStackFrames also returns the frames the
+// function was inlined into. Frames whose program counter does not resolve
+// to a known function are returned with only ProgramCounter populated.
 func NewStackFrame(pc uintptr) StackFrame {
-	f := StackFrame{ProgramCounter: pc}
-	fn := f.Func()
-	if fn == nil {
-		return f
+	return NewStackFrames(pc)[0]
+}
+
+// NewStackFrames returns the logical frames at a program counter, innermost
+// first: the function containing pc and, when it was inlined, each function
+// it was inlined into, up to the physical frame. The result is never empty.
+//
+// PCs from runtime.Callers already list inlined calls as separate entries;
+// use StackFrames or runtime.CallersFrames for whole stacks and
+// NewStackFrames for PCs from other sources, such as a recorded stack trace
+// or a profile.
+func NewStackFrames(pc uintptr) []StackFrame {
+	// With more PCs to come, CallersFrames inserts the outer frames implied
+	// by an inlined PC unless the next PC is already the outer frame's. The
+	// zero sentinel never is, and resolves to nothing itself.
+	it := runtime.CallersFrames([]uintptr{pc, 0})
+	var frames []StackFrame
+	for {
+		rf, more := it.Next()
+		if rf.Function != "" {
+			fpc := pc
+			if len(frames) > 0 {
+				// Outer frames get a PC in the form runtime.Callers
+				// would have recorded for them.
+				fpc = rf.PC + 1
+			}
+			frames = append(frames, newRuntimeFrame(rf, fpc))
+		}
+		if !more {
+			break
+		}
+	}
+	if len(frames) == 0 {
+		return []StackFrame{{ProgramCounter: pc}}
+	}
+	return frames
+}
+
+// newRuntimeFrame converts a frame from runtime.CallersFrames, recording pc
+// as its program counter.
+func newRuntimeFrame(rf runtime.Frame, pc uintptr) StackFrame {
+	pkg, name := splitPackageAndName(rf.Function)
+	return StackFrame{
+		File:           rf.File,
+		LineNumber:     rf.Line,
+		Name:           name,
+		Package:        pkg,
+		ProgramCounter: pc,
+		// CallersFrames leaves Func nil for inlined Go frames.
+		Inlined: rf.Func == nil,
 	}
-	f.Package, f.Name = packageAndName(fn)
-	// pc-1 because the recorded program counters are usually return
-	// addresses; subtracting one lands on the calling instruction.
-	f.File, f.LineNumber = fn.FileLine(pc - 1)
-	return f
 }
 
-// Func returns the runtime.Func describing the frame, or nil if the program
-// counter does not resolve.
+// Func returns the runtime.Func describing the frame's function, or nil if
+// the program counter does not resolve. It is resolved at the same
+// instruction as the frame itself, so its Name matches Package and Name,
+// including for inlined frames; their Entry is that of the function they
+// were inlined into.
 func (s StackFrame) Func() *runtime.Func {
 	if s.ProgramCounter == 0 {
 		return nil
 	}
-	return runtime.FuncForPC(s.ProgramCounter)
+	fn := runtime.FuncForPC(s.ProgramCounter)
+	// Program counters are return addresses; the call is the instruction
+	// before, which may belong to a different inlined function.
+	if fn != nil && s.ProgramCounter > fn.Entry() {
+		fn = runtime.FuncForPC(s.ProgramCounter - 1)
+	}
+	return fn
 }
 
 // String returns a one-frame description suitable for diagnostic stack
@@ -59,8 +114,12 @@ func (s StackFrame) Func() *runtime.Func {
 //	\t<file>:<line> +0x<pc>
 //
 // String never reads source files from disk. To attach the source line, call
-// SourceLine explicitly and append it.
+// SourceLine explicitly and append it. Under Deterministic the PC, path and
+// line are normalized.
 func (s StackFrame) String() string {
+	if d := deterministic.Load(); d != nil {
+		s = d.frame(s)
+	}
 	var b strings.Builder
 	if s.Package != "" || s.Name != "" {
 		b.WriteString(s.Package)
@@ -76,6 +135,20 @@ func (s StackFrame) String() string {
 	return b.String()
 }
 
+// FuncName returns the package-qualified function name as the runtime
+// spells it in runtime.Frame.Function, e.g.
+// "example.com/app/store.(*DB).Get".
+func (s StackFrame) FuncName() string {
+	switch {
+	case s.Package == "":
+		return s.Name
+	case strings.HasSuffix(s.Package, "/"):
+		return s.Package + s.Name
+	default:
+		return s.Package + "." + s.Name
+	}
+}
+
 // SourceLine returns the line of source code referenced by the frame. It
 // reads the file from disk, so callers should treat the result as opt-in
 // debug aid and avoid invoking it on hot paths or untrusted file paths.
@@ -94,15 +167,10 @@ func (s *StackFrame) SourceLine() (string, error) {
 	return string(bytes.Trim(lines[s.LineNumber-1], " \t")), nil
 }
 
-// packageAndName splits the runtime function's qualified name into a package
-// path (with trailing slash for the leading import path component, when
-// present) and a function name with center-dots normalized.
-func packageAndName(fn *runtime.Func) (string, string) {
-	return splitPackageAndName(fn.Name())
-}
-
-// splitPackageAndName performs the same split on a raw qualified function
-// name, as returned by runtime.Frame.Function.
+// splitPackageAndName splits a qualified function name, as returned by
+// runtime.Frame.Function, into a package path (with trailing slash for the
+// leading import path component, when present) and a function name with
+// center-dots normalized.
 func splitPackageAndName(qualified string) (string, string) {
 	name := qualified
 	pkg := ""
@@ -41,6 +41,65 @@ func TestNewStackFrame(t *testing.T) {
 	})
 }
 
+// callersInlined is small enough to be inlined into its caller unless the
+// tests are built with -gcflags=-l.
+func callersInlined() []uintptr {
+	return callers()
+}
+
+// callers returns the stack of its caller.
+//
+//go:noinline
+func callers() []uintptr {
+	pcs := make([]uintptr, 8)
+	return pcs[:runtime.Callers(2, pcs)]
+}
+
+func TestNewStackFrames(t *testing.T) {
+	pcs := callersInlined()
+	frames := errorx.NewStackFrames(pcs[0])
+	t.Logf("callersInlined inlined: %v", frames[0].Inlined)
+
+	// For the first PC alone, NewStackFrames must report what CallersFrames
+	// reports for the whole stack up to the first physical frame.
+	it := runtime.CallersFrames(pcs)
+	for i := 0; ; i++ {
+		rf, more := it.Next()
+		if i >= len(frames) {
+			t.Fatalf("NewStackFrames returned %d frames, want more: %+v", len(frames), frames)
+		}
+		f := frames[i]
+		if got := f.Package + "." + f.Name; got != rf.Function || f.File != rf.File || f.LineNumber != rf.Line || f.Inlined != (rf.Func == nil) {
+			t.Errorf("frame %d = %+v, want %s at %s:%d (inlined %v)", i, f, rf.Function, rf.File, rf.Line, rf.Func == nil)
+		}
+		if f.ProgramCounter != pcs[i] {
+			t.Errorf("frame %d PC = %#x, want %#x as recorded by runtime.Callers", i, f.ProgramCounter, pcs[i])
+		}
+		if rf.Func != nil || !more {
+			if len(frames) != i+1 {
+				t.Errorf("NewStackFrames returned %d frames, want %d", len(frames), i+1)
+			}
+			break
+		}
+	}
+
+	for _, f := range frames {
+		if fn := f.Func(); fn == nil || fn.Name() != f.Package+"."+f.Name {
+			t.Errorf("%s: Func() = %v", f.Name, fn)
+		}
+		if got := errorx.NewStackFrame(f.ProgramCounter); got != f {
+			t.Errorf("NewStackFrame(%#x) = %+v, want %+v", f.ProgramCounter, got, f)
+		}
+	}
+	if frames[0].Name != "callersInlined" {
+		t.Errorf("innermost frame = %s, want callersInlined", frames[0].Name)
+	}
+
+	if got := errorx.NewStackFrames(0); len(got) != 1 || got[0] != (errorx.StackFrame{}) {
+		t.Errorf("NewStackFrames(0) = %+v", got)
+	}
+}
+
 func TestStackFrame_StringDoesNotReadSource(t *testing.T) {
 	pc, _, _, ok := runtime.Caller(0)
 	if !ok {
@@ -0,0 +1,167 @@
+package errorx
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// FormatOptions controls the %+v output of (*TraceError).Format.
+type FormatOptions struct {
+	// ElideCommonFrames prints every *TraceError layer of the Unwrap
+	// chain, outermost first, instead of only the outermost stack. Each
+	// inner layer starts with a "caused by: " line holding its message, and
+	// the frames a layer shares with the next inner one are replaced by a
+	// "... N more" line, as in Java stack traces.
+	ElideCommonFrames bool
+}
+
+var formatOptions atomic.Pointer[FormatOptions]
+
+// SetFormatOptions replaces the options used by %+v across the process. It
+// is safe for concurrent use.
+func SetFormatOptions(opts FormatOptions) {
+	formatOptions.Store(&opts)
+}
+
+// RecordOptions controls the optional parts of Record, and so of
+// MarshalJSON.
+type RecordOptions struct {
+	// IncludeChain fills Record.Chain with every *TraceError layer of the
+	// Unwrap chain.
+	IncludeChain bool
+	// ElideCommonFrames keeps in each Chain entry only the frames the
+	// layer does not share with the next inner layer, and counts the rest
+	// in CommonFrames.
+	ElideCommonFrames bool
+}
+
+var recordOptions atomic.Pointer[RecordOptions]
+
+// SetRecordOptions replaces the options used by Record across the process.
+// It is safe for concurrent use.
+func SetRecordOptions(opts RecordOptions) {
+	recordOptions.Store(&opts)
+}
+
+// ChainLayer is one *TraceError layer in Record.Chain.
+type ChainLayer struct {
+	// Message is the layer's Error().
+	Message string `json:"message,omitempty"`
+	// Prefix is the layer's own prefix.
+	Prefix string `json:"prefix,omitempty"`
+	// Type is the layer's Type().
+	Type string `json:"type,omitempty"`
+	// Time is when the layer was constructed, zero while Deterministic is
+	// active.
+	Time time.Time `json:"time,omitzero"`
+	// StackFrames holds the layer's frames, without those counted in
+	// CommonFrames.
+	StackFrames []StackFrame `json:"stack_frames,omitempty"`
+	// CommonFrames is the number of frames left out by
+	// RecordOptions.ElideCommonFrames.
+	CommonFrames int `json:"common_frames,omitempty"`
+}
+
+// recordChain returns Record.Chain for e under the current RecordOptions,
+// or nil when the chain is off or e has no inner layer.
+func recordChain(e *TraceError) []ChainLayer {
+	opts := recordOptions.Load()
+	if opts == nil || !opts.IncludeChain || innerLayer(e) == nil {
+		return nil
+	}
+	var out []ChainLayer
+	for cur := error(e); cur != nil; cur = errors.Unwrap(cur) {
+		te, ok := cur.(*TraceError)
+		if !ok || te == nil {
+			continue
+		}
+		frames, common := te.StackFrames(), 0
+		if opts.ElideCommonFrames {
+			frames, common = uniqueFrames(te, innerLayer(te))
+		}
+		out = append(out, ChainLayer{
+			Message:      te.Error(),
+			Prefix:       te.prefix,
+			Type:         te.Type(),
+			Time:         te.logTime(),
+			StackFrames:  frames,
+			CommonFrames: common,
+		})
+	}
+	return out
+}
+
+// CommonFrames returns the number of frames at the bottom of a's and b's
+// stacks that are the same call sites, typically the frames below the point
+// where b was wrapped into a. It returns 0 when either is nil.
+func CommonFrames(a, b *TraceError) int {
+	if a == nil || b == nil {
+		return 0
+	}
+	return commonFrames(a.resolvedFrames(), b.resolvedFrames())
+}
+
+func commonFrames(a, b []StackFrame) int {
+	n := 0
+	for n < len(a) && n < len(b) {
+		x, y := a[len(a)-1-n], b[len(b)-1-n]
+		if x.File != y.File || x.LineNumber != y.LineNumber || x.Package != y.Package || x.Name != y.Name {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// innerLayer returns the next *TraceError below e in the Unwrap chain, or
+// nil when there is none.
+func innerLayer(e *TraceError) *TraceError {
+	for cur := e.cause; cur != nil; cur = errors.Unwrap(cur) {
+		if te, ok := cur.(*TraceError); ok && te != nil {
+			return te
+		}
+	}
+	return nil
+}
+
+// uniqueFrames returns the frames of e that it does not share with inner,
+// and the number of shared frames left out.
+func uniqueFrames(e, inner *TraceError) ([]StackFrame, int) {
+	frames := e.StackFrames()
+	common := CommonFrames(e, inner)
+	return frames[:len(frames)-common], common
+}
+
+// formatChain writes the %+v output for FormatOptions.ElideCommonFrames.
+func (e *TraceError) formatChain(w io.Writer) {
+	rw := &renderWriter{w: w}
+	rw.str(e.Error())
+	rw.str("\n")
+	var num [20]byte
+	for te := e; te != nil; {
+		inner := innerLayer(te)
+		if debugStack := te.ext().debugStack; len(debugStack) > 0 {
+			rw.bytes(debugStack)
+		} else {
+			frames, common := uniqueFrames(te, inner)
+			for _, f := range frames {
+				rw.str(f.String())
+			}
+			if common > 0 {
+				rw.str("... ")
+				rw.bytes(strconv.AppendInt(num[:0], int64(common), 10))
+				rw.str(" more\n")
+			}
+		}
+		if inner == nil {
+			break
+		}
+		rw.str("caused by: ")
+		rw.str(inner.Error())
+		rw.str("\n")
+		te = inner
+	}
+}
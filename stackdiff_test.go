@@ -0,0 +1,139 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+func diffInner() error {
+	return errorx.WrapPrefix(errors.New("disk full"), "write", 0)
+}
+
+func diffOuter() error {
+	err := diffInner()
+	return errorx.WrapPrefix(fmt.Errorf("flush: %w", err), "save", 0)
+}
+
+func TestCommonFrames(t *testing.T) {
+	outer := diffOuter().(*errorx.TraceError)
+	var inner *errorx.TraceError
+	if !errors.As(outer.Unwrap(), &inner) {
+		t.Fatal("no inner layer")
+	}
+	frames := outer.StackFrames()
+	common := errorx.CommonFrames(outer, inner)
+	if common != len(frames)-2 || frames[1].Name != "diffOuter" {
+		t.Errorf("CommonFrames = %d of %d frames, want all but WrapPrefix and diffOuter: %v", common, len(frames), frames)
+	}
+	if got := errorx.CommonFrames(inner, outer); got != common {
+		t.Errorf("CommonFrames is not symmetric: %d != %d", got, common)
+	}
+	if got := errorx.CommonFrames(outer, outer); got != len(frames) {
+		t.Errorf("CommonFrames(outer, outer) = %d, want %d", got, len(frames))
+	}
+	if got := errorx.CommonFrames(nil, outer); got != 0 {
+		t.Errorf("CommonFrames(nil, outer) = %d", got)
+	}
+}
+
+func TestFormatElideCommonFrames(t *testing.T) {
+	err := diffOuter()
+	full := fmt.Sprintf("%+v", err)
+	if strings.Contains(full, "caused by:") || strings.Count(full, "TestFormatElideCommonFrames") != 1 {
+		t.Errorf("default %%+v changed:\n%s", full)
+	}
+
+	errorx.SetFormatOptions(errorx.FormatOptions{ElideCommonFrames: true})
+	t.Cleanup(func() { errorx.SetFormatOptions(errorx.FormatOptions{}) })
+	out := fmt.Sprintf("%+v", err)
+
+	outerPart, innerPart, ok := strings.Cut(out, "caused by: write: disk full\n")
+	if !ok || !strings.HasPrefix(outerPart, "save: flush: write: disk full\n") {
+		t.Fatalf("%%+v with ElideCommonFrames:\n%s", out)
+	}
+	common := errorx.CommonFrames(err.(*errorx.TraceError), errors.Unwrap(errors.Unwrap(err)).(*errorx.TraceError))
+	if !strings.Contains(outerPart, "diffOuter\n") || !strings.HasSuffix(outerPart, fmt.Sprintf("... %d more\n", common)) ||
+		strings.Contains(outerPart, "TestFormatElideCommonFrames") {
+		t.Errorf("outer layer not elided:\n%s", outerPart)
+	}
+	if !strings.Contains(innerPart, "diffInner\n") || !strings.Contains(innerPart, "TestFormatElideCommonFrames") || strings.Contains(innerPart, " more\n") {
+		t.Errorf("innermost layer not printed in full:\n%s", innerPart)
+	}
+}
+
+func TestConsoleAndLogElideCommonFrames(t *testing.T) {
+	err := diffOuter()
+	inner := errors.Unwrap(errors.Unwrap(err)).(*errorx.TraceError)
+	common := errorx.CommonFrames(err.(*errorx.TraceError), inner)
+
+	var buf bytes.Buffer
+	if werr := errorx.WriteConsole(&buf, err, errorx.ConsoleOptions{ElideCommonFrames: true}); werr != nil {
+		t.Fatal(werr)
+	}
+	if out := buf.String(); strings.Count(out, " more\n") != 1 || !strings.Contains(out, fmt.Sprintf("      ... %d more\n", common)) {
+		t.Errorf("WriteConsole with ElideCommonFrames:\n%s", out)
+	}
+
+	opts := errorx.StandardLogOptions()
+	opts.IncludeChain = true
+	opts.ElideCommonFrames = true
+	buf.Reset()
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", errorx.LogWith(err, opts))
+	var line struct {
+		Err struct {
+			StackFrames []json.RawMessage `json:"stack_frames"`
+			Chain       []struct {
+				StackFrames  []json.RawMessage `json:"stack_frames"`
+				CommonFrames int               `json:"common_frames"`
+			} `json:"chain"`
+		} `json:"err"`
+	}
+	if jerr := json.Unmarshal(buf.Bytes(), &line); jerr != nil || len(line.Err.Chain) != 2 {
+		t.Fatalf("LogValue = %s", buf.Bytes())
+	}
+	if c := line.Err.Chain[0]; len(c.StackFrames) != 2 || c.CommonFrames != common {
+		t.Errorf("outer chain entry has %d frames and common_frames %d, want 2 and %d", len(c.StackFrames), c.CommonFrames, common)
+	}
+	if c := line.Err.Chain[1]; c.CommonFrames != 0 || len(c.StackFrames) != len(inner.StackFrames()) {
+		t.Errorf("inner chain entry has %d frames and common_frames %d", len(c.StackFrames), c.CommonFrames)
+	}
+	if len(line.Err.StackFrames) != len(err.(*errorx.TraceError).StackFrames()) {
+		t.Errorf("top-level stack_frames elided: %d frames", len(line.Err.StackFrames))
+	}
+}
+
+func TestRecordChain(t *testing.T) {
+	err := diffOuter().(*errorx.TraceError)
+	inner := errors.Unwrap(errors.Unwrap(err)).(*errorx.TraceError)
+	common := errorx.CommonFrames(err, inner)
+	if chain := err.Record().Chain; chain != nil {
+		t.Errorf("Record().Chain = %v without RecordOptions", chain)
+	}
+
+	errorx.SetRecordOptions(errorx.RecordOptions{IncludeChain: true, ElideCommonFrames: true})
+	t.Cleanup(func() { errorx.SetRecordOptions(errorx.RecordOptions{}) })
+	rec := err.Record()
+	if len(rec.Chain) != 2 || len(rec.StackFrames) != len(err.StackFrames()) {
+		t.Fatalf("Record().Chain = %+v", rec.Chain)
+	}
+	if c := rec.Chain[0]; c.Message != "save: flush: write: disk full" || c.Prefix != "save" || len(c.StackFrames) != 2 || c.CommonFrames != common {
+		t.Errorf("outer chain entry = %q (%q) with %d frames and %d common, want 2 and %d", c.Message, c.Prefix, len(c.StackFrames), c.CommonFrames, common)
+	}
+	if c := rec.Chain[1]; c.Message != "write: disk full" || c.CommonFrames != 0 || len(c.StackFrames) != len(inner.StackFrames()) {
+		t.Errorf("inner chain entry = %q with %d frames and %d common", c.Message, len(c.StackFrames), c.CommonFrames)
+	}
+	data, _ := json.Marshal(err)
+	if !bytes.Contains(data, []byte(fmt.Sprintf(`"common_frames":%d`, common))) {
+		t.Errorf("MarshalJSON = %s", data)
+	}
+	if chain := inner.Record().Chain; chain != nil {
+		t.Errorf("Record().Chain of a single layer = %v", chain)
+	}
+}
@@ -0,0 +1,461 @@
+package errorx
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrBuildIDMismatch is returned by Symbolizer.Symbolize when a Record was
+// captured by a different binary than the one being used to resolve it.
+var ErrBuildIDMismatch = errors.New("errorx: build ID mismatch")
+
+// pclntab constants shared with the runtime (internal/abi).
+const (
+	pcdataInlTreeIndex = 2
+	funcdataInlTree    = 3
+	// inlinedCallSize is the size of an inline tree entry since Go 1.21:
+	// funcID uint8, padding, then nameOff, parentPc and startLine int32.
+	inlinedCallSize = 16
+	// funcHeaderSize is the size of the runtime's _func struct before its
+	// pcdata and funcdata offset arrays.
+	funcHeaderSize = 44
+)
+
+// Symbolizer resolves program counters captured by another process, such as
+// Record.Stack from a log store, into StackFrames using the symbol tables of
+// the ELF Go binary that captured them. Logging only the PCs and resolving
+// them later keeps error logging cheap.
+//
+// Frames are resolved as runtime.CallersFrames would have resolved them in
+// the original process: each PC from runtime.Callers stands for one logical
+// frame, and PCs inside inlined code report the inlined function, using the
+// binary's pclntab and inline tree. Stripped binaries (-ldflags="-s -w")
+// work too. Position-independent executables are not supported, since their
+// PCs depend on the load address.
+//
+// A Symbolizer is safe for concurrent use.
+type Symbolizer struct {
+	file    *elf.File
+	buildID string
+	table   *gosym.Table
+
+	order     binary.ByteOrder
+	quantum   uint64
+	textStart uint64
+	ptrSize   int
+	funcnames []byte
+	pctab     []byte
+	ftab      []byte
+	nfunc     int
+	// gofunc is the address funcdata offsets are relative to; zero when
+	// the binary has no inline tree this package can read.
+	gofunc uint64
+
+	mu       sync.Mutex
+	sections map[*elf.Section][]byte
+}
+
+// OpenSymbolizer opens the ELF Go binary at path. Call Close when done.
+func OpenSymbolizer(path string) (*Symbolizer, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("errorx: OpenSymbolizer: %w", err)
+	}
+	s, err := newSymbolizer(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("errorx: OpenSymbolizer %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func newSymbolizer(f *elf.File) (*Symbolizer, error) {
+	pclnSec, text := f.Section(".gopclntab"), f.Section(".text")
+	if pclnSec == nil || text == nil {
+		return nil, errors.New("no Go symbol table")
+	}
+	if f.Type == elf.ET_DYN {
+		return nil, errors.New("position-independent executables are not supported")
+	}
+	pcln, err := pclnSec.Data()
+	if err != nil {
+		return nil, err
+	}
+	var symtab []byte
+	if sec := f.Section(".gosymtab"); sec != nil {
+		if symtab, err = sec.Data(); err != nil {
+			return nil, err
+		}
+	}
+	table, err := gosym.NewTable(symtab, gosym.NewLineTable(pcln, text.Addr))
+	if err != nil {
+		return nil, err
+	}
+	s := &Symbolizer{
+		file:      f,
+		buildID:   elfBuildID(f),
+		table:     table,
+		order:     f.ByteOrder,
+		textStart: text.Addr,
+		sections:  make(map[*elf.Section][]byte),
+	}
+	s.parseInlineTables(f, pclnSec.Addr, pcln)
+	return s, nil
+}
+
+// parseInlineTables locates the function table and inline tree data used by
+// inlined. It leaves gofunc zero when the binary's layout is not one it
+// understands, which disables inline resolution.
+func (s *Symbolizer) parseInlineTables(f *elf.File, pclnAddr uint64, pcln []byte) {
+	// Binaries importing this package are built by Go 1.24 or later, so
+	// only the Go 1.20+ table header and Go 1.21+ inline tree are handled.
+	if len(pcln) < 8 || s.order.Uint32(pcln) != 0xfffffff1 {
+		return
+	}
+	s.ptrSize = int(pcln[7])
+	if s.ptrSize != 4 && s.ptrSize != 8 || len(pcln) < 8+8*s.ptrSize {
+		return
+	}
+	word := func(i int) uint64 { return s.word(pcln[8+i*s.ptrSize:]) }
+	nfunc, funcnameOff, pctabOff, pclnOff := word(0), word(3), word(6), word(7)
+	if funcnameOff > uint64(len(pcln)) || pctabOff > uint64(len(pcln)) || pclnOff > uint64(len(pcln)) ||
+		nfunc >= (uint64(len(pcln))-pclnOff)/8 {
+		return
+	}
+	s.quantum = uint64(pcln[6])
+	s.funcnames = pcln[funcnameOff:]
+	s.pctab = pcln[pctabOff:]
+	s.ftab = pcln[pclnOff:]
+	s.nfunc = int(nfunc)
+	s.gofunc = s.findGofunc(f, pclnAddr, pclnAddr+funcnameOff)
+}
+
+// findGofunc returns the base address of funcdata, the go:func.* symbol.
+// Recent linkers give it its own section. Older stripped binaries have
+// neither that nor a symbol table; for them the runtime's module data is
+// found by its leading pcHeader and funcnametab pointers, and the field that
+// holds a valid inline tree base is taken.
+func (s *Symbolizer) findGofunc(f *elf.File, pclnAddr, funcnamesAddr uint64) uint64 {
+	if sec := f.Section(".go.func"); sec != nil && s.validGofunc(sec.Addr) {
+		return sec.Addr
+	}
+	if syms, err := f.Symbols(); err == nil {
+		for _, sym := range syms {
+			if sym.Name == "go:func.*" {
+				return sym.Value
+			}
+		}
+	}
+	const maxFields = 64
+	for _, name := range []string{".go.module", ".noptrdata", ".data"} {
+		sec := f.Section(name)
+		if sec == nil || sec.Type == elf.SHT_NOBITS {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			continue
+		}
+		p := s.ptrSize
+		for off := 0; off+2*p <= len(data); off += p {
+			if s.word(data[off:]) != pclnAddr || s.word(data[off+p:]) != funcnamesAddr {
+				continue
+			}
+			for i := 2; i < maxFields && off+(i+1)*p <= len(data); i++ {
+				if g := s.word(data[off+i*p:]); g != 0 && s.validGofunc(g) {
+					return g
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// validGofunc reports whether the first inline tree entries of a sample of
+// functions, read relative to g, name real functions.
+func (s *Symbolizer) validGofunc(g uint64) bool {
+	checked := 0
+	for i := 0; i < s.nfunc && checked < 16; i += s.nfunc/256 + 1 {
+		funcOff := uint64(s.order.Uint32(s.ftab[8*i+4:]))
+		if funcOff >= uint64(len(s.ftab)) {
+			return false
+		}
+		inltree, ok := s.funcdata(s.ftab[funcOff:], funcdataInlTree)
+		if !ok {
+			continue
+		}
+		call := s.read(g+uint64(inltree), inlinedCallSize)
+		if call == nil {
+			return false
+		}
+		name := s.funcName(int32(s.order.Uint32(call[4:])))
+		if !strings.Contains(name, ".") || int32(s.order.Uint32(call[8:])) < 0 {
+			return false
+		}
+		checked++
+	}
+	return checked > 0
+}
+
+// funcdata returns funcdata offset i of the _func record fn.
+func (s *Symbolizer) funcdata(fn []byte, i uint32) (uint32, bool) {
+	if len(fn) < funcHeaderSize {
+		return 0, false
+	}
+	npcdata := s.order.Uint32(fn[28:])
+	if i >= uint32(fn[funcHeaderSize-1]) || uint64(len(fn)) < funcHeaderSize+4*uint64(npcdata+i+1) {
+		return 0, false
+	}
+	off := s.order.Uint32(fn[funcHeaderSize+4*(npcdata+i):])
+	return off, off != ^uint32(0)
+}
+
+// pcdata returns the pc-value table offset for pcdata i of fn.
+func (s *Symbolizer) pcdata(fn []byte, i uint32) (uint32, bool) {
+	if len(fn) < funcHeaderSize {
+		return 0, false
+	}
+	if i >= s.order.Uint32(fn[28:]) || uint64(len(fn)) < funcHeaderSize+4*uint64(i+1) {
+		return 0, false
+	}
+	off := s.order.Uint32(fn[funcHeaderSize+4*i:])
+	return off, off != 0
+}
+
+func (s *Symbolizer) word(b []byte) uint64 {
+	if s.ptrSize == 4 {
+		return uint64(s.order.Uint32(b))
+	}
+	return s.order.Uint64(b)
+}
+
+// BuildID returns the Go build ID of the binary, as recorded in
+// Record.BuildID by errors it captured.
+func (s *Symbolizer) BuildID() string { return s.buildID }
+
+// Close closes the underlying binary.
+func (s *Symbolizer) Close() error { return s.file.Close() }
+
+// Frames resolves pcs, as returned by runtime.Callers and stored in
+// Record.Stack, into one StackFrame per PC. PCs the binary does not cover
+// are returned with only ProgramCounter populated.
+func (s *Symbolizer) Frames(pcs []uintptr) []StackFrame {
+	frames := make([]StackFrame, 0, len(pcs))
+	for _, pc := range pcs {
+		frames = append(frames, s.frame(pc))
+	}
+	return frames
+}
+
+func (s *Symbolizer) frame(pc uintptr) StackFrame {
+	f := StackFrame{ProgramCounter: pc}
+	if pc == 0 {
+		return f
+	}
+	// pc-1 because the recorded program counters are return addresses (or
+	// one past an inline mark); subtracting one lands on the call.
+	tracepc := uint64(pc) - 1
+	file, line, fn := s.table.PCToLine(tracepc)
+	if fn == nil {
+		return f
+	}
+	name := fn.Name
+	if inl := s.inlined(tracepc); inl != "" {
+		name, f.Inlined = inl, true
+	}
+	f.Package, f.Name = splitPackageAndName(name)
+	f.File, f.LineNumber = file, line
+	return f
+}
+
+// Symbolize returns r with StackFrames resolved from r.Stack. It returns an
+// error wrapping ErrBuildIDMismatch when r records a build ID other than the
+// binary's; records without a build ID are resolved unchecked.
+func (s *Symbolizer) Symbolize(r Record) (Record, error) {
+	if r.BuildID != "" && s.buildID != "" && r.BuildID != s.buildID {
+		return r, fmt.Errorf("%w: record from %q, binary is %q", ErrBuildIDMismatch, r.BuildID, s.buildID)
+	}
+	if len(r.Stack) > 0 {
+		r.StackFrames = s.Frames(r.Stack)
+	}
+	return r, nil
+}
+
+// inlined returns the name of the innermost function inlined at pc, or ""
+// when pc is not in inlined code or the inline tree is unavailable.
+func (s *Symbolizer) inlined(pc uint64) string {
+	if s.gofunc == 0 {
+		return ""
+	}
+	fn, entry, ok := s.funcAt(pc)
+	if !ok {
+		return ""
+	}
+	pcdata, ok := s.pcdata(fn, pcdataInlTreeIndex)
+	if !ok {
+		return ""
+	}
+	inltree, ok := s.funcdata(fn, funcdataInlTree)
+	if !ok {
+		return ""
+	}
+	ix := s.pcvalue(pcdata, entry, pc)
+	if ix < 0 {
+		return ""
+	}
+	call := s.read(s.gofunc+uint64(inltree)+uint64(ix)*inlinedCallSize, inlinedCallSize)
+	if call == nil {
+		return ""
+	}
+	return s.funcName(int32(s.order.Uint32(call[4:])))
+}
+
+// funcAt binary-searches the function table for the _func record covering
+// pc and returns it with the function's entry address.
+func (s *Symbolizer) funcAt(pc uint64) ([]byte, uint64, bool) {
+	if s.nfunc == 0 || pc < s.textStart {
+		return nil, 0, false
+	}
+	off := pc - s.textStart
+	entryOff := func(i int) uint64 { return uint64(s.order.Uint32(s.ftab[8*i:])) }
+	// The table has nfunc entries plus a terminating end-of-text entry.
+	lo, hi := 0, s.nfunc
+	if off >= entryOff(s.nfunc) {
+		return nil, 0, false
+	}
+	for lo+1 < hi {
+		mid := (lo + hi) / 2
+		if entryOff(mid) <= off {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	funcOff := uint64(s.order.Uint32(s.ftab[8*lo+4:]))
+	if funcOff >= uint64(len(s.ftab)) {
+		return nil, 0, false
+	}
+	return s.ftab[funcOff:], s.textStart + entryOff(lo), true
+}
+
+// pcvalue decodes the pc-value table at off for the value at target, the
+// way the runtime's pcvalue does. It returns -1 when target is not covered.
+func (s *Symbolizer) pcvalue(off uint32, entry, target uint64) int32 {
+	if uint64(off) >= uint64(len(s.pctab)) {
+		return -1
+	}
+	p := s.pctab[off:]
+	pc, val := entry, int32(-1)
+	for first := true; len(p) > 0; first = false {
+		uvdelta, n := binary.Uvarint(p)
+		if n <= 0 || (uvdelta == 0 && !first) {
+			return -1
+		}
+		p = p[n:]
+		val += int32(-(uint32(uvdelta) & 1) ^ (uint32(uvdelta) >> 1))
+		pcdelta, n := binary.Uvarint(p)
+		if n <= 0 {
+			return -1
+		}
+		p = p[n:]
+		pc += pcdelta * s.quantum
+		if target < pc {
+			return val
+		}
+	}
+	return -1
+}
+
+// funcName reads a NUL-terminated name from the function name table.
+func (s *Symbolizer) funcName(off int32) string {
+	if off < 0 || int(off) >= len(s.funcnames) {
+		return ""
+	}
+	name := s.funcnames[off:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name)
+}
+
+// read returns n bytes at virtual address addr, or nil if no section of
+// the binary holds them.
+func (s *Symbolizer) read(addr, n uint64) []byte {
+	for _, sec := range s.file.Sections {
+		if sec.Type == elf.SHT_NOBITS || addr < sec.Addr || addr+n > sec.Addr+sec.Size {
+			continue
+		}
+		s.mu.Lock()
+		data, ok := s.sections[sec]
+		if !ok {
+			data, _ = sec.Data()
+			s.sections[sec] = data
+		}
+		s.mu.Unlock()
+		if start := addr - sec.Addr; start+n <= uint64(len(data)) {
+			return data[start : start+n]
+		}
+		return nil
+	}
+	return nil
+}
+
+// elfBuildID returns the Go build ID from f's .note.go.buildid note.
+func elfBuildID(f *elf.File) string {
+	sec := f.Section(".note.go.buildid")
+	if sec == nil {
+		return ""
+	}
+	data, err := sec.Data()
+	if err != nil || len(data) < 16 {
+		return ""
+	}
+	// The note is namesz, descsz and type words, the name "Go\x00\x00" and
+	// the ID itself.
+	descsz := uint64(f.ByteOrder.Uint32(data[4:]))
+	if f.ByteOrder.Uint32(data) != 4 || string(data[12:16]) != "Go\x00\x00" || 16+descsz > uint64(len(data)) {
+		return ""
+	}
+	return string(data[16 : 16+descsz])
+}
+
+// buildIDMarker precedes the build ID at the start of the text segment of
+// every Go binary; non-ELF binaries are searched for it.
+const buildIDMarker = "\xff Go build ID: \""
+
+// executableBuildID is the Go build ID of the running binary, read once.
+var executableBuildID = sync.OnceValue(func() string {
+	path, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		return elfBuildID(f)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 32<<10)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	i := bytes.Index(head, []byte(buildIDMarker))
+	if i < 0 {
+		return ""
+	}
+	id, _, ok := bytes.Cut(head[i+len(buildIDMarker):], []byte{'"'})
+	if !ok {
+		return ""
+	}
+	return string(id)
+})
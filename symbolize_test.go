@@ -0,0 +1,92 @@
+package errorx_test
+
+import (
+	"debug/elf"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+// symbolizeInlined is small enough to be inlined into its caller, so its
+// frame is only recoverable through the binary's inline tree.
+func symbolizeInlined() error {
+	return errorx.Errorf("lookup failed")
+}
+
+// openTestBinary opens the running test binary, which captured the PCs
+// being resolved.
+func openTestBinary(t *testing.T) *errorx.Symbolizer {
+	t.Helper()
+	path, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Skip("test binary is not ELF")
+	}
+	pie := f.Type == elf.ET_DYN
+	f.Close()
+	if pie {
+		t.Skip("test binary is position-independent")
+	}
+	s, err := errorx.OpenSymbolizer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSymbolizer(t *testing.T) {
+	te := symbolizeInlined().(*errorx.TraceError)
+	s := openTestBinary(t)
+
+	if s.BuildID() == "" || te.BuildID() != s.BuildID() || te.Record().BuildID != s.BuildID() {
+		t.Errorf("build IDs: symbolizer %q, error %q, record %q", s.BuildID(), te.BuildID(), te.Record().BuildID)
+	}
+
+	want := te.StackFrames()
+	got := s.Frames(te.Stack())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Frames(Stack())\n got %+v\nwant %+v", got, want)
+	}
+	if len(got) < 2 || got[1].Name != "symbolizeInlined" {
+		t.Errorf("inlined frame not resolved: %+v", got)
+	}
+
+	rec := te.Record()
+	rec.StackFrames = nil
+	rec, err := s.Symbolize(rec)
+	if err != nil || !reflect.DeepEqual(rec.StackFrames, want) {
+		t.Errorf("Symbolize = %+v, %v", rec.StackFrames, err)
+	}
+}
+
+func TestSymbolizerBuildIDMismatch(t *testing.T) {
+	s := openTestBinary(t)
+	rec := symbolizeInlined().(*errorx.TraceError).Record()
+	rec.StackFrames = nil
+	rec.BuildID = "other"
+	got, err := s.Symbolize(rec)
+	if !errors.Is(err, errorx.ErrBuildIDMismatch) {
+		t.Errorf("Symbolize error = %v, want ErrBuildIDMismatch", err)
+	}
+	if got.StackFrames != nil {
+		t.Errorf("mismatched record was symbolized: %+v", got.StackFrames)
+	}
+
+	unknown := s.Frames([]uintptr{0, 1})
+	if unknown[0] != (errorx.StackFrame{}) || unknown[1] != (errorx.StackFrame{ProgramCounter: 1}) {
+		t.Errorf("unresolvable PCs = %+v", unknown)
+	}
+}
+
+func TestOpenSymbolizerRejectsNonGoFiles(t *testing.T) {
+	if _, err := errorx.OpenSymbolizer("symbolize_test.go"); err == nil {
+		t.Error("OpenSymbolizer accepted a source file")
+	}
+}
@@ -0,0 +1,96 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/neumachen/errorx"
+)
+
+func TestTime(t *testing.T) {
+	clk := newFakeClock()
+	errorx.SetClock(clk)
+	t.Cleanup(func() { errorx.SetClock(nil) })
+
+	inner := errorx.Errorf("disk full").(*errorx.TraceError)
+	clk.Advance(150 * time.Millisecond)
+	outer := errorx.WrapPrefix(inner, "save", 0).(*errorx.TraceError)
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if !inner.Time().Equal(start) {
+		t.Errorf("inner Time() = %v, want %v", inner.Time(), start)
+	}
+	if d := outer.Time().Sub(inner.Time()); d != 150*time.Millisecond {
+		t.Errorf("latency between layers = %v, want 150ms", d)
+	}
+	if got := outer.Record().Time; !got.Equal(outer.Time()) {
+		t.Errorf("Record().Time = %v, want %v", got, outer.Time())
+	}
+
+	data, err := json.Marshal(outer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"time":"2024-01-02T03:04:05.15Z"`)) {
+		t.Errorf("MarshalJSON = %s", data)
+	}
+
+	opts := errorx.StandardLogOptions()
+	opts.IncludeChain = true
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", errorx.LogWith(outer, opts))
+	var line struct {
+		Err struct {
+			Time  time.Time `json:"time"`
+			Chain []struct {
+				Time time.Time `json:"time"`
+			} `json:"chain"`
+		} `json:"err"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil || !line.Err.Time.Equal(outer.Time()) ||
+		len(line.Err.Chain) != 2 || !line.Err.Chain[0].Time.Equal(outer.Time()) || !line.Err.Chain[1].Time.Equal(inner.Time()) {
+		t.Errorf("LogValue = %s", buf.Bytes())
+	}
+
+	errorx.Deterministic(t, errorx.DeterministicOptions{})
+	if got := outer.Record().Time; !got.IsZero() {
+		t.Errorf("Record().Time under Deterministic = %v", got)
+	}
+	if data, _ := json.Marshal(outer); bytes.Contains(data, []byte(`"time"`)) {
+		t.Errorf("MarshalJSON under Deterministic = %s", data)
+	}
+}
+
+func TestTimeRoundTrip(t *testing.T) {
+	te := errorx.Errorf("boom").(*errorx.TraceError)
+	if te.Time().IsZero() {
+		t.Fatal("Time() is zero with the system clock")
+	}
+	got := errorx.FromRecord(te.Record())
+	if !got.Time().Equal(te.Time()) {
+		t.Errorf("FromRecord Time() = %v, want %v", got.Time(), te.Time())
+	}
+
+	data, err := te.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded errorx.TraceError
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Time().Equal(te.Time()) {
+		t.Errorf("binary round trip Time() = %v, want %v", decoded.Time(), te.Time())
+	}
+
+	parsed, err := errorx.ParsePanic("panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/src/main.go:5 +0x1d\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at := parsed.(*errorx.TraceError).Time(); !at.IsZero() {
+		t.Errorf("ParsePanic Time() = %v, want zero", at)
+	}
+}
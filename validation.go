@@ -0,0 +1,191 @@
+package errorx
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactedValue replaces the rejected value of fields marked with
+// FieldValidator.Redact.
+const RedactedValue = "[REDACTED]"
+
+// validationErrorType is the Type() of a TraceError wrapping a
+// *ValidationError directly.
+const validationErrorType = "*errorx.ValidationError"
+
+// Violation describes one failed validation rule.
+type Violation struct {
+	// Field is the dotted path of the offending field, e.g. "user.email".
+	Field string `json:"field"`
+	// Rule is a short machine-readable rule name, e.g. "required".
+	Rule string `json:"rule"`
+	// Message is the human-readable description.
+	Message string `json:"message"`
+	// Value is the rejected value, RedactedValue for redacted fields, or
+	// nil when not recorded. Values other than strings, booleans and
+	// finite numbers are stored as their fmt.Sprint text.
+	Value any `json:"value,omitempty"`
+}
+
+// ValidationError collects field violations. It is always delivered as the
+// cause of a *TraceError built by Validator.Err, so it can be found with
+// errors.As and its violations appear in Record, MarshalJSON and LogValue.
+type ValidationError struct {
+	violations []Violation
+}
+
+// Error lists the violations as "field: message" pairs.
+func (v *ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("validation failed")
+	for i, vi := range v.violations {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		b.WriteString(vi.Field)
+		b.WriteString(": ")
+		b.WriteString(vi.Message)
+	}
+	return b.String()
+}
+
+// Violations returns a copy of the collected violations in the order they
+// were recorded.
+func (v *ValidationError) Violations() []Violation {
+	out := make([]Violation, len(v.violations))
+	copy(out, v.violations)
+	return out
+}
+
+// Validator accumulates violations through a builder API:
+//
+//	v := errorx.NewValidator()
+//	v.Field("user.email").Required(req.Email)
+//	v.Field("user.password").Redact().MinLen(req.Password, 12)
+//	if err := v.Err(); err != nil {
+//	    return err
+//	}
+//
+// A Validator is not safe for concurrent use.
+type Validator struct {
+	violations []Violation
+}
+
+// NewValidator returns an empty Validator.
+func NewValidator() *Validator {
+	return &Validator{}
+}
+
+// Field starts validating the field at path.
+func (v *Validator) Field(path string) *FieldValidator {
+	return &FieldValidator{v: v, path: path}
+}
+
+// Add records a violation directly.
+func (v *Validator) Add(field, rule, message string, value any) {
+	v.violations = append(v.violations, Violation{Field: field, Rule: rule, Message: message, Value: violationValue(value)})
+}
+
+// Valid reports whether no violation has been recorded.
+func (v *Validator) Valid() bool {
+	return len(v.violations) == 0
+}
+
+// Err returns nil when no violation was recorded, and otherwise a
+// *TraceError whose cause is a *ValidationError holding the violations.
+// The stack is captured at the call to Err.
+func (v *Validator) Err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	ve := &ValidationError{violations: append([]Violation(nil), v.violations...)}
+	te := newTraceError(ve, captureStack(1))
+	constructed(te, 0)
+	return te
+}
+
+// FieldValidator applies rules to a single field. Every rule method
+// returns the receiver so rules can be chained.
+type FieldValidator struct {
+	v      *Validator
+	path   string
+	redact bool
+}
+
+// Redact replaces the rejected value with RedactedValue in violations
+// recorded for this field afterwards.
+func (f *FieldValidator) Redact() *FieldValidator {
+	f.redact = true
+	return f
+}
+
+// Check records a violation of rule with message when ok is false.
+func (f *FieldValidator) Check(ok bool, rule, message string, value any) *FieldValidator {
+	if !ok {
+		if f.redact {
+			value = RedactedValue
+		}
+		f.v.Add(f.path, rule, message, value)
+	}
+	return f
+}
+
+// Required rejects zero values: empty strings, nil pointers, slices and
+// maps, and zero numbers and structs.
+func (f *FieldValidator) Required(value any) *FieldValidator {
+	ok := value != nil && !reflect.ValueOf(value).IsZero()
+	if rv := reflect.ValueOf(value); ok && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) {
+		ok = rv.Len() > 0
+	}
+	return f.Check(ok, "required", "is required", value)
+}
+
+// MinLen rejects strings shorter than n characters.
+func (f *FieldValidator) MinLen(s string, n int) *FieldValidator {
+	return f.Check(utf8.RuneCountInString(s) >= n, "min_len", fmt.Sprintf("must be at least %d characters", n), s)
+}
+
+// MaxLen rejects strings longer than n characters.
+func (f *FieldValidator) MaxLen(s string, n int) *FieldValidator {
+	return f.Check(utf8.RuneCountInString(s) <= n, "max_len", fmt.Sprintf("must be at most %d characters", n), s)
+}
+
+// OneOf rejects strings not in allowed.
+func (f *FieldValidator) OneOf(s string, allowed ...string) *FieldValidator {
+	for _, a := range allowed {
+		if s == a {
+			return f
+		}
+	}
+	return f.Check(false, "one_of", "must be one of "+strings.Join(allowed, ", "), s)
+}
+
+// violationValue keeps scalar values JSON can encode and stringifies the
+// rest, including NaN and infinite floats, so that a Record holding
+// violations marshals.
+func violationValue(value any) any {
+	switch v := value.(type) {
+	case nil, string, bool,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64:
+		return value
+	case float32:
+		if isFinite(float64(v)) {
+			return value
+		}
+	case float64:
+		if isFinite(v) {
+			return value
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
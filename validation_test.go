@@ -0,0 +1,148 @@
+package errorx_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+type signupRequest struct {
+	Email    string
+	Password string
+	Plan     string
+	Tags     []string
+}
+
+func validateSignup(req signupRequest) error {
+	v := errorx.NewValidator()
+	v.Field("user.email").Required(req.Email)
+	v.Field("user.password").Redact().MinLen(req.Password, 12)
+	v.Field("plan").OneOf(req.Plan, "free", "pro")
+	v.Field("tags").Required(req.Tags)
+	return v.Err()
+}
+
+func TestValidatorNoViolations(t *testing.T) {
+	err := validateSignup(signupRequest{Email: "a@b.c", Password: "correct horse battery", Plan: "pro", Tags: []string{"x"}})
+	if err != nil {
+		t.Fatalf("validateSignup = %v, want nil", err)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := validateSignup(signupRequest{Password: "hunter2", Plan: "gold", Tags: []string{}})
+
+	var ve *errorx.ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("errors.As(%T, *ValidationError) = false", err)
+	}
+	want := []errorx.Violation{
+		{Field: "user.email", Rule: "required", Message: "is required", Value: ""},
+		{Field: "user.password", Rule: "min_len", Message: "must be at least 12 characters", Value: errorx.RedactedValue},
+		{Field: "plan", Rule: "one_of", Message: "must be one of free, pro", Value: "gold"},
+		{Field: "tags", Rule: "required", Message: "is required", Value: "[]"},
+	}
+	if got := ve.Violations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Violations\n got %+v\nwant %+v", got, want)
+	}
+	if !strings.HasPrefix(err.Error(), "validation failed: user.email: is required; user.password: ") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Error() leaks a redacted value: %q", err.Error())
+	}
+
+	te := err.(*errorx.TraceError)
+	if te.Type() != "*errorx.ValidationError" {
+		t.Errorf("Type() = %q", te.Type())
+	}
+	if frames := te.StackFrames(); len(frames) < 2 || !strings.HasSuffix(frames[1].Name, "validateSignup") {
+		t.Errorf("stack does not start at the Err call: %v", frames)
+	}
+	if rec := te.Record(); !reflect.DeepEqual(rec.Violations, want) {
+		t.Errorf("Record().Violations = %+v", rec.Violations)
+	}
+}
+
+func TestValidationErrorJSONAndLog(t *testing.T) {
+	err := errorx.WrapPrefix(validateSignup(signupRequest{Email: "a@b.c", Password: "hunter2", Plan: "free", Tags: []string{"x"}}), "signup", 0)
+
+	data, jerr := json.Marshal(err)
+	if jerr != nil {
+		t.Fatalf("Marshal: %v", jerr)
+	}
+	var rec struct {
+		Violations []map[string]any `json:"violations"`
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(rec.Violations) != 1 || rec.Violations[0]["field"] != "user.password" || rec.Violations[0]["value"] != errorx.RedactedValue {
+		t.Errorf("JSON violations = %v", rec.Violations)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("signup failed", "error", err)
+	var line struct {
+		Error struct {
+			Violations []map[string]any `json:"violations"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Unmarshal log line: %v\n%s", err, buf.Bytes())
+	}
+	if len(line.Error.Violations) != 1 || line.Error.Violations[0]["rule"] != "min_len" {
+		t.Errorf("logged violations = %v", line.Error.Violations)
+	}
+}
+
+func TestValidationErrorRoundTrip(t *testing.T) {
+	err := errorx.WrapPrefix(validateSignup(signupRequest{Plan: "gold", Tags: []string{"x"}}), "signup", 0).(*errorx.TraceError)
+	want := err.Record()
+
+	data, merr := err.MarshalBinary()
+	if merr != nil {
+		t.Fatalf("MarshalBinary: %v", merr)
+	}
+	var decoded errorx.TraceError
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+
+	for name, got := range map[string]*errorx.TraceError{"binary": &decoded, "record": errorx.FromRecord(want)} {
+		var ve *errorx.ValidationError
+		if !errors.As(got, &ve) {
+			t.Errorf("%s: decoded error has no *ValidationError", name)
+			continue
+		}
+		if rec := got.Record(); !reflect.DeepEqual(rec.Violations, want.Violations) || rec.Message != want.Message || rec.Type != want.Type {
+			t.Errorf("%s: record\n got %+v\nwant %+v", name, rec, want)
+		}
+	}
+}
+
+func TestViolationValuesAreJSONSafe(t *testing.T) {
+	v := errorx.NewValidator()
+	v.Field("ch").Check(false, "custom", "is invalid", make(chan int))
+	v.Add("count", "min", "must be positive", -1)
+	v.Add("ratio", "finite", "must be finite", math.NaN())
+	v.Add("limit", "finite", "must be finite", float32(math.Inf(-1)))
+	if v.Valid() {
+		t.Fatal("Valid() = true with violations")
+	}
+	err := v.Err()
+	if _, merr := json.Marshal(err); merr != nil {
+		t.Errorf("Marshal: %v", merr)
+	}
+	var ve *errorx.ValidationError
+	if !errors.As(err, &ve) || ve.Violations()[2].Value != "NaN" || ve.Violations()[3].Value != "-Inf" {
+		t.Errorf("non-finite values = %v", ve.Violations())
+	}
+}
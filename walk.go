@@ -0,0 +1,149 @@
+package errorx
+
+import "reflect"
+
+// WalkAction tells Walk how to proceed after visiting a layer.
+type WalkAction int
+
+const (
+	// WalkContinue descends into the errors the layer wraps.
+	WalkContinue WalkAction = iota
+	// WalkSkip leaves out the errors the layer wraps and continues with
+	// its siblings.
+	WalkSkip
+	// WalkStop ends the walk.
+	WalkStop
+)
+
+// Walk calls fn for err and every error it wraps, in the depth-first
+// pre-order that errors.Is and errors.As use. It follows both
+// Unwrap() error and Unwrap() []error, skipping nil entries. depth is 0 for
+// err itself, and path holds the index taken into each layer's wrapped
+// errors on the way down, so len(path) == depth; path is reused between
+// calls and must be copied to be retained.
+//
+// An error that wraps one of its own ancestors is visited once and not
+// descended into again, so cyclic chains terminate. Errors reachable along
+// several paths, such as one error joined twice, are visited once per path.
+// Walk does nothing when err is nil.
+func Walk(err error, fn func(layer error, depth int, path []int) WalkAction) {
+	if err == nil {
+		return
+	}
+	w := walker{fn: fn}
+	w.walk(err)
+}
+
+type walker struct {
+	fn func(layer error, depth int, path []int) WalkAction
+	// ancestors holds the layers from the root down to the current one.
+	ancestors []error
+	path      []int
+}
+
+// walk visits err and its descendants and reports whether to stop.
+func (w *walker) walk(err error) bool {
+	switch w.fn(err, len(w.ancestors), w.path) {
+	case WalkStop:
+		return true
+	case WalkSkip:
+		return false
+	}
+	if w.isAncestor(err) {
+		return false
+	}
+	var children []error
+	switch x := err.(type) {
+	case interface{ Unwrap() error }:
+		if next := x.Unwrap(); next != nil {
+			children = []error{next}
+		}
+	case interface{ Unwrap() []error }:
+		children = x.Unwrap()
+	}
+	w.ancestors = append(w.ancestors, err)
+	defer func() { w.ancestors = w.ancestors[:len(w.ancestors)-1] }()
+	for i, child := range children {
+		if child == nil {
+			continue
+		}
+		w.path = append(w.path, i)
+		stop := w.walk(child)
+		w.path = w.path[:len(w.path)-1]
+		if stop {
+			return true
+		}
+	}
+	return false
+}
+
+// isAncestor reports whether err is one of the layers above it. Errors of
+// types that cannot be compared are never reported.
+func (w *walker) isAncestor(err error) bool {
+	if !reflect.TypeOf(err).Comparable() {
+		return false
+	}
+	for _, a := range w.ancestors {
+		if reflect.TypeOf(a) == reflect.TypeOf(err) && a == err {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the first error in err's tree, in Walk order, that is
+// assignable to T, or for which an As(any) bool method reports true when
+// given a *T. It is the generic form of errors.As:
+//
+//	if ve, ok := errorx.Find[*errorx.ValidationError](err); ok {
+//	    ...
+//	}
+//
+// Unlike errors.As, T may be any type.
+func Find[T any](err error) (T, bool) {
+	var found T
+	var ok bool
+	Walk(err, func(layer error, _ int, _ []int) WalkAction {
+		if t, match := layer.(T); match {
+			found, ok = t, true
+			return WalkStop
+		}
+		if x, hasAs := layer.(interface{ As(any) bool }); hasAs && x.As(&found) {
+			ok = true
+			return WalkStop
+		}
+		return WalkContinue
+	})
+	return found, ok
+}
+
+// Layers returns every *TraceError in err's tree, outermost first, in Walk
+// order. It returns nil when there is none.
+func Layers(err error) []*TraceError {
+	var layers []*TraceError
+	Walk(err, func(layer error, _ int, _ []int) WalkAction {
+		if te, ok := layer.(*TraceError); ok && te != nil {
+			layers = append(layers, te)
+		}
+		return WalkContinue
+	})
+	return layers
+}
+
+// Root returns the innermost error of err's tree, reached by following the
+// first non-nil wrapped error of each layer. For a plain Unwrap chain it is
+// the error errors.Unwrap eventually stops at; for a tree built with
+// errors.Join or fmt.Errorf with several %w verbs it is the root of the
+// first branch. Root returns nil when err is nil.
+func Root(err error) error {
+	root, last := err, -1
+	Walk(err, func(layer error, depth int, _ []int) WalkAction {
+		// The first branch ends where the walk stops descending.
+		if depth <= last {
+			return WalkStop
+		}
+		root, last = layer, depth
+		return WalkContinue
+	})
+	return root
+}
@@ -0,0 +1,190 @@
+package errorx_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/neumachen/errorx"
+)
+
+// visit is one Walk callback invocation.
+type visit struct {
+	msg   string
+	depth int
+	path  []int
+}
+
+func walkAll(err error, action func(error) errorx.WalkAction) []visit {
+	var got []visit
+	errorx.Walk(err, func(layer error, depth int, path []int) errorx.WalkAction {
+		got = append(got, visit{layer.Error(), depth, slices.Clone(path)})
+		return action(layer)
+	})
+	return got
+}
+
+func TestWalk(t *testing.T) {
+	a, b := errors.New("a"), errors.New("b")
+	inner := errorx.WrapPrefix(a, "inner", 0)
+	err := errorx.WrapPrefix(joinedValue{inner, nil, b}, "outer", 0)
+	joined := "joined"
+
+	got := walkAll(err, func(error) errorx.WalkAction { return errorx.WalkContinue })
+	want := []visit{
+		{"outer: " + joined, 0, nil},
+		{joined, 1, []int{0}},
+		{"inner: a", 2, []int{0, 0}},
+		{"a", 3, []int{0, 0, 0}},
+		{"b", 2, []int{0, 2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk visited\n%v\nwant\n%v", got, want)
+	}
+
+	got = walkAll(err, func(layer error) errorx.WalkAction {
+		if layer == inner {
+			return errorx.WalkSkip
+		}
+		return errorx.WalkContinue
+	})
+	if msgs := messages(got); !slices.Equal(msgs, []string{"outer: " + joined, joined, "inner: a", "b"}) {
+		t.Errorf("WalkSkip visited %q", msgs)
+	}
+
+	got = walkAll(err, func(layer error) errorx.WalkAction {
+		if layer == a {
+			return errorx.WalkStop
+		}
+		return errorx.WalkContinue
+	})
+	if msgs := messages(got); !slices.Equal(msgs, []string{"outer: " + joined, joined, "inner: a", "a"}) {
+		t.Errorf("WalkStop visited %q", msgs)
+	}
+
+	errorx.Walk(nil, func(error, int, []int) errorx.WalkAction {
+		t.Error("Walk(nil) called fn")
+		return errorx.WalkContinue
+	})
+}
+
+func messages(visits []visit) []string {
+	msgs := make([]string, len(visits))
+	for i, v := range visits {
+		msgs[i] = v.msg
+	}
+	return msgs
+}
+
+// loopError wraps next, which may lead back to itself.
+type loopError struct {
+	name string
+	next error
+}
+
+func (e *loopError) Error() string { return e.name }
+func (e *loopError) Unwrap() error { return e.next }
+
+// joinedValue is an uncomparable multi-error that, unlike errors.Join,
+// keeps nil entries.
+type joinedValue []error
+
+func (e joinedValue) Error() string   { return "joined" }
+func (e joinedValue) Unwrap() []error { return e }
+
+func TestWalkCycles(t *testing.T) {
+	x, y := &loopError{name: "x"}, &loopError{name: "y"}
+	x.next, y.next = y, x
+	got := messages(walkAll(errorx.Wrap(x, 0), func(error) errorx.WalkAction { return errorx.WalkContinue }))
+	if !slices.Equal(got, []string{"x", "x", "y", "x"}) {
+		t.Errorf("cyclic chain visited %q", got)
+	}
+	if root := errorx.Root(x); root != x {
+		t.Errorf("Root of cycle = %v", root)
+	}
+
+	// One error reachable along two paths is visited along each.
+	shared := errors.New("shared")
+	got = messages(walkAll(joinedValue{shared, shared}, func(error) errorx.WalkAction { return errorx.WalkContinue }))
+	if !slices.Equal(got, []string{"joined", "shared", "shared"}) {
+		t.Errorf("shared error visited %q", got)
+	}
+}
+
+// asCode reports its code through an As method rather than its type.
+type asCode struct{ code int }
+
+func (e asCode) Error() string { return fmt.Sprintf("code %d", e.code) }
+func (e asCode) As(target any) bool {
+	if p, ok := target.(*int); ok {
+		*p = e.code
+		return true
+	}
+	return false
+}
+
+func TestFind(t *testing.T) {
+	v := errorx.NewValidator()
+	v.Add("name", "required", "is required", "")
+	pathErr := &fs.PathError{Op: "open", Path: "/x", Err: fs.ErrNotExist}
+	err := errorx.WrapPrefix(errors.Join(errorx.Wrap(pathErr, 0), v.Err(), asCode{code: 7}), "load", 0)
+
+	if got, ok := errorx.Find[*fs.PathError](err); !ok || got != pathErr {
+		t.Errorf("Find[*fs.PathError] = %v, %v", got, ok)
+	}
+	if got, ok := errorx.Find[*errorx.ValidationError](err); !ok || len(got.Violations()) != 1 {
+		t.Errorf("Find[*ValidationError] = %v, %v", got, ok)
+	}
+	if got, ok := errorx.Find[*errorx.TraceError](err); !ok || got.Prefix() != "load" {
+		t.Errorf("Find[*TraceError] = %v, %v, want the outermost layer", got, ok)
+	}
+	if got, ok := errorx.Find[*loopError](err); ok {
+		t.Errorf("Find[*loopError] = %v, want no match", got)
+	}
+	if got, ok := errorx.Find[int](err); !ok || got != 7 {
+		t.Errorf("Find[int] through As = %v, %v", got, ok)
+	}
+	if _, ok := errorx.Find[error](nil); ok {
+		t.Error("Find in nil matched")
+	}
+}
+
+func TestLayers(t *testing.T) {
+	a := errorx.WrapPrefix(errors.New("a"), "a", 0)
+	b := errorx.WrapPrefix(errors.New("b"), "b", 0)
+	outer := errorx.WrapPrefix(fmt.Errorf("both: %w, %w", a, b), "outer", 0)
+
+	var prefixes []string
+	for _, te := range errorx.Layers(outer) {
+		prefixes = append(prefixes, te.Prefix())
+	}
+	if !slices.Equal(prefixes, []string{"outer", "a", "b"}) {
+		t.Errorf("Layers prefixes = %q", prefixes)
+	}
+	if got := errorx.Layers(errors.New("plain")); got != nil {
+		t.Errorf("Layers of a plain error = %v", got)
+	}
+}
+
+func TestRoot(t *testing.T) {
+	base := errors.New("base")
+	chain := errorx.WrapPrefix(fmt.Errorf("read: %w", errorx.Wrap(base, 0)), "load", 0)
+	if got := errorx.Root(chain); got != base {
+		t.Errorf("Root(chain) = %v, want base", got)
+	}
+
+	first, second := errors.New("first"), errors.New("second")
+	tree := errorx.Wrap(joinedValue{nil, errorx.Wrap(first, 0), second}, 0)
+	if got := errorx.Root(tree); got != first {
+		t.Errorf("Root(tree) = %v, want first", got)
+	}
+	if got := errorx.Root(base); got != base {
+		t.Errorf("Root(base) = %v", got)
+	}
+	if got := errorx.Root(nil); got != nil {
+		t.Errorf("Root(nil) = %v", got)
+	}
+}